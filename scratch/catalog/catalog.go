@@ -0,0 +1,255 @@
+// Package catalog searches the library catalog, a Solr index with a VuFind
+// style schema, and returns minimized records for a model.
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is a search in the library catalog. All set fields must match.
+type Query struct {
+	Query    string // free text
+	Title    string
+	Author   string
+	ISBN     string
+	ISSN     string
+	YearFrom int
+	YearTo   int
+	OpenOnly bool     // only open access records
+	Facets   []string // names from facetFields
+	Page     int      // starting at 1
+	Rows     int
+}
+
+// Record is the minimized record we pass on to the model, the same
+// projection as scratch/dr0/minimize.sh.
+type Record struct {
+	ID     string   `json:"id,omitempty"`
+	Title  string   `json:"title"`
+	Author []string `json:"author,omitempty"`
+	Year   string   `json:"year,omitempty"`
+	URL    []string `json:"url,omitempty"`
+	DOI    []string `json:"doi,omitempty"`
+	ISBN   []string `json:"isbn,omitempty"`
+	// Catalogs are the names of the backends that returned the record.
+	Catalogs []string `json:"catalogs,omitempty"`
+}
+
+// FacetCount is the number of hits with a field value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Result is a page of search results.
+type Result struct {
+	Query   string                  `json:"query"`
+	Found   int                     `json:"found"`
+	Page    int                     `json:"page"`
+	Pages   int                     `json:"pages"`
+	Records []Record                `json:"records"`
+	Facets  map[string][]FacetCount `json:"facets,omitempty"`
+	Merged  []string                `json:"merged,omitempty"` // duplicates merged into records, if the catalog merges
+}
+
+// facetFields maps facet names offered to the model to Solr fields.
+var facetFields = map[string]string{
+	"year":         "publishDateSort",
+	"access_state": "access_state_str",
+	"format":       "format",
+	"language":     "language",
+}
+
+const (
+	DefaultRows = 10
+	MaxRows     = 50
+)
+
+// PageRows returns the page and rows of a query with defaults and limits
+// applied.
+func (q Query) PageRows() (page, rows int) {
+	rows = q.Rows
+	if rows <= 0 {
+		rows = DefaultRows
+	}
+	return max(q.Page, 1), min(rows, MaxRows)
+}
+
+// Solr searches a Solr select endpoint with a VuFind style schema,
+// e.g. https://example.org/solr/biblio/select.
+type Solr struct {
+	URL    string
+	client *http.Client
+}
+
+func NewSolr(selectURL string) *Solr {
+	return &Solr{URL: selectURL, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (c *Solr) Name() string { return "solr" }
+
+// solrEscaper escapes the characters with a meaning in the Solr query
+// syntax, except double quotes, so phrases still work.
+var solrEscaper = strings.NewReplacer(
+	`\`, `\\`, `+`, `\+`, `-`, `\-`, `&`, `\&`, `|`, `\|`, `!`, `\!`, `(`, `\(`, `)`, `\)`,
+	`{`, `\{`, `}`, `\}`, `[`, `\[`, `]`, `\]`, `^`, `\^`, `~`, `\~`, `*`, `\*`, `?`, `\?`,
+	`:`, `\:`, `/`, `\/`,
+)
+
+// phrase quotes a value as a Solr phrase.
+func phrase(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(strings.TrimSpace(s)) + `"`
+}
+
+// solrQuery returns the q parameter for a query.
+func solrQuery(q Query) (string, error) {
+	var clauses []string
+	if s := strings.TrimSpace(q.Query); s != "" {
+		if strings.Count(s, `"`)%2 == 1 {
+			s = strings.ReplaceAll(s, `"`, "")
+		}
+		clauses = append(clauses, "("+solrEscaper.Replace(s)+")")
+	}
+	if q.Title != "" {
+		clauses = append(clauses, "title:"+phrase(q.Title))
+	}
+	if q.Author != "" {
+		clauses = append(clauses, "author:"+phrase(q.Author))
+	}
+	// Identifiers are indexed without hyphens.
+	normalize := strings.NewReplacer("-", "", " ", "")
+	if q.ISBN != "" {
+		clauses = append(clauses, "isbn:"+phrase(normalize.Replace(q.ISBN)))
+	}
+	if q.ISSN != "" {
+		clauses = append(clauses, "issn:"+phrase(q.ISSN))
+	}
+	if len(clauses) == 0 {
+		return "", fmt.Errorf("query, title, author, isbn or issn is required")
+	}
+	return strings.Join(clauses, " AND "), nil
+}
+
+// Search runs a query and returns one page of minimized records.
+func (c *Solr) Search(ctx context.Context, q Query) (*Result, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("no catalog configured, set -catalog-url or CATALOG_URL")
+	}
+	query, err := solrQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	q.Page, q.Rows = q.PageRows()
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("q.op", "AND")
+	params.Set("wt", "json")
+	params.Set("fl", "id,title,author,publishDateSort,url,doi_str_mv,isbn")
+	params.Set("start", strconv.Itoa((q.Page-1)*q.Rows))
+	params.Set("rows", strconv.Itoa(q.Rows))
+	if q.YearFrom > 0 || q.YearTo > 0 {
+		from, to := "*", "*"
+		if q.YearFrom > 0 {
+			from = strconv.Itoa(q.YearFrom)
+		}
+		if q.YearTo > 0 {
+			to = strconv.Itoa(q.YearTo)
+		}
+		params.Add("fq", fmt.Sprintf("publishDateSort:[%s TO %s]", from, to))
+	}
+	if q.OpenOnly {
+		params.Add("fq", `access_state_str:"Open Access"`)
+	}
+	if len(q.Facets) > 0 {
+		params.Set("facet", "true")
+		params.Set("facet.mincount", "1")
+		params.Set("facet.limit", "10")
+		for _, name := range q.Facets {
+			field, ok := facetFields[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown facet %q", name)
+			}
+			params.Add("facet.field", field)
+		}
+	}
+	u := c.URL
+	if strings.Contains(u, "?") {
+		u += "&" + params.Encode()
+	} else {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("catalog request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	var data struct {
+		Response struct {
+			NumFound int `json:"numFound"`
+			Docs     []struct {
+				ID              string   `json:"id"`
+				Title           string   `json:"title"`
+				Author          []string `json:"author"`
+				PublishDateSort string   `json:"publishDateSort"`
+				URL             []string `json:"url"`
+				DOI             []string `json:"doi_str_mv"`
+				ISBN            []string `json:"isbn"`
+			} `json:"docs"`
+		} `json:"response"`
+		FacetCounts struct {
+			FacetFields map[string][]any `json:"facet_fields"`
+		} `json:"facet_counts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	result := &Result{
+		Query:   query,
+		Found:   data.Response.NumFound,
+		Page:    q.Page,
+		Pages:   (data.Response.NumFound + q.Rows - 1) / q.Rows,
+		Records: []Record{},
+	}
+	for _, doc := range data.Response.Docs {
+		result.Records = append(result.Records, Record{
+			ID:     doc.ID,
+			Title:  doc.Title,
+			Author: doc.Author,
+			Year:   doc.PublishDateSort,
+			URL:    doc.URL,
+			DOI:    doc.DOI,
+			ISBN:   doc.ISBN,
+		})
+	}
+	for _, name := range q.Facets {
+		// Solr returns facets as a flat list: value, count, value, count.
+		flat := data.FacetCounts.FacetFields[facetFields[name]]
+		counts := []FacetCount{}
+		for i := 0; i+1 < len(flat); i += 2 {
+			value, _ := flat[i].(string)
+			count, _ := flat[i+1].(float64)
+			counts = append(counts, FacetCount{Value: value, Count: int(count)})
+		}
+		if result.Facets == nil {
+			result.Facets = make(map[string][]FacetCount)
+		}
+		result.Facets[name] = counts
+	}
+	return result, nil
+}
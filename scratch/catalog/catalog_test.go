@@ -0,0 +1,243 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// solrStub serves the saved Solr response of scratch/dr0 for every query,
+// with facet counts added if facets are requested, and keeps the query
+// parameters of each request.
+type solrStub struct {
+	*httptest.Server
+	mu     sync.Mutex
+	params []url.Values
+}
+
+func newSolrStub(t *testing.T) *solrStub {
+	t.Helper()
+	b, err := os.ReadFile("../dr0/full.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &solrStub{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.params = append(s.params, r.URL.Query())
+		s.mu.Unlock()
+		var data map[string]any
+		if err := json.Unmarshal(b, &data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.FormValue("facet") == "true" {
+			data["facet_counts"] = map[string]any{"facet_fields": map[string]any{
+				"publishDateSort":  []any{"2025", 21, "2024", 9, "1969", 1},
+				"access_state_str": []any{"Open Access", 30, "Closed Access", 6},
+				"format":           []any{"Article, E-Article", 25, "Book, E-Book", 11},
+			}}
+		}
+		json.NewEncoder(w).Encode(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *solrStub) last() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.params[len(s.params)-1]
+}
+
+func TestSolrQuery(t *testing.T) {
+	var cases = []struct {
+		query Query
+		want  string
+		err   string
+	}{
+		{Query{Query: "deep research"}, `(deep research)`, ""},
+		{Query{Query: `"deep research" agents`}, `("deep research" agents)`, ""},
+		{Query{Query: `"deep research`}, `(deep research)`, ""},
+		{Query{Query: "C++ (programming): a/b"}, `(C\+\+ \(programming\)\: a\/b)`, ""},
+		{Query{Title: "Deep Research"}, `title:"Deep Research"`, ""},
+		{Query{Title: `The "Deep" Web`}, `title:"The \"Deep\" Web"`, ""},
+		{Query{Author: " Shiri, Ali "}, `author:"Shiri, Ali"`, ""},
+		{Query{ISBN: "978-3-16-148410-0"}, `isbn:"9783161484100"`, ""},
+		{Query{ISBN: "978 3 16 148410 0"}, `isbn:"9783161484100"`, ""},
+		{Query{ISSN: "0028-0836"}, `issn:"0028-0836"`, ""},
+		{Query{Query: "agents", Title: "deep research", Author: "Shiri"},
+			`(agents) AND title:"deep research" AND author:"Shiri"`, ""},
+		{Query{YearFrom: 2020}, "", "query, title, author, isbn or issn is required"},
+		{Query{Query: "  "}, "", "query, title, author, isbn or issn is required"},
+	}
+	for _, c := range cases {
+		got, err := solrQuery(c.query)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%+v: got %q, %v, want error %q", c.query, got, err, c.err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%+v: got %q, %v, want %q", c.query, got, err, c.want)
+		}
+	}
+}
+
+func TestSolrSearch(t *testing.T) {
+	stub := newSolrStub(t)
+	solr := NewSolr(stub.URL + "/solr/biblio/select")
+	result, err := solr.Search(context.Background(), Query{Title: "deep research", YearFrom: 2020, OpenOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := stub.last()
+	for k, want := range map[string]string{
+		"q": `title:"deep research"`, "q.op": "AND", "wt": "json", "fl": "id,title,author,publishDateSort,url,doi_str_mv,isbn",
+		"start": "0", "rows": "10",
+	} {
+		if got := params.Get(k); got != want {
+			t.Errorf("%s: got %q, want %q", k, got, want)
+		}
+	}
+	if got, want := params["fq"], []string{"publishDateSort:[2020 TO *]", `access_state_str:"Open Access"`}; !slices.Equal(got, want) {
+		t.Errorf("fq: got %q, want %q", got, want)
+	}
+	if params.Has("facet") {
+		t.Errorf("facets requested without facets: %v", params)
+	}
+	if result.Query != `title:"deep research"` || result.Found != 36 || result.Page != 1 || result.Pages != 4 {
+		t.Errorf("got query %q, found %d, page %d of %d", result.Query, result.Found, result.Page, result.Pages)
+	}
+	if len(result.Records) != 10 || result.Facets != nil {
+		t.Fatalf("got %d records and facets %v", len(result.Records), result.Facets)
+	}
+	// The projection of minimize.sh, plus id and identifiers.
+	want := []Record{
+		{
+			ID:     "126-ZnRiaGxlOm9haTpiaW9kaXZlcnNpdHlsaWJyYXJ5Lm9yZzppdGVtLzg2NzIw",
+			Title:  "Recovery of deep research vehicle Alvin",
+			Author: []string{"United States. Naval Ship Systems Command."},
+			URL:    []string{"https://www.biodiversitylibrary.org/item/86720"},
+			DOI:    []string{"https://doi.org/10.5962/bhl.title.39120"},
+		},
+		{
+			ID:     "126-ZnRiaGxlOm9haTpiaW9kaXZlcnNpdHlsaWJyYXJ5Lm9yZzp0aXRsZS8zOTEyMA",
+			Title:  "Recovery of deep research vehicle Alvin",
+			Author: []string{"United States. Naval Ship Systems Command."},
+			Year:   "1969",
+			URL:    []string{"https://www.biodiversitylibrary.org/bibliography/39120"},
+			DOI:    []string{"https://doi.org/10.5962/bhl.title.39120"},
+		},
+		{
+			ID:     "ai-49-aHR0cHM6Ly9kb2kub3JnLzEwLjIxMzkvc3Nybi41MjI0OTM1",
+			Title:  `"Deep Research": A Research Paradigm Shift`,
+			Author: []string{"Shiri, Ali"},
+			Year:   "2025",
+			URL:    []string{"https://doi.org/10.2139/ssrn.5224935"},
+			DOI:    []string{"10.2139/ssrn.5224935"},
+		},
+	}
+	for i, w := range want {
+		if !reflect.DeepEqual(result.Records[i], w) {
+			t.Errorf("record %d: got %+v, want %+v", i, result.Records[i], w)
+		}
+	}
+	b, err := json.Marshal(result.Records[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); strings.Contains(got, "spellingShingle") || strings.Contains(got, `"year"`) {
+		t.Errorf("record not minimized: %s", got)
+	}
+}
+
+func TestSolrPaging(t *testing.T) {
+	var cases = []struct {
+		page, rows  int
+		start, size string
+		pages       int
+	}{
+		{0, 0, "0", "10", 4},
+		{1, 10, "0", "10", 4},
+		{3, 10, "20", "10", 4},
+		{4, 10, "30", "10", 4},
+		{2, 7, "7", "7", 6},
+		{-1, 36, "0", "36", 1},
+		{2, 12, "12", "12", 3},
+		{1, 100, "0", "50", 1},
+	}
+	stub := newSolrStub(t)
+	solr := NewSolr(stub.URL + "/select?indent=true")
+	for _, c := range cases {
+		result, err := solr.Search(context.Background(), Query{Query: "deep research", Page: c.page, Rows: c.rows})
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := stub.last()
+		if params.Get("start") != c.start || params.Get("rows") != c.size || params.Get("indent") != "true" {
+			t.Errorf("page %d rows %d: got start %s rows %s, want %s and %s",
+				c.page, c.rows, params.Get("start"), params.Get("rows"), c.start, c.size)
+		}
+		if result.Pages != c.pages || result.Page != max(c.page, 1) {
+			t.Errorf("page %d rows %d: got page %d of %d, want %d pages", c.page, c.rows, result.Page, result.Pages, c.pages)
+		}
+	}
+}
+
+func TestSolrFacets(t *testing.T) {
+	stub := newSolrStub(t)
+	solr := NewSolr(stub.URL)
+	result, err := solr.Search(context.Background(), Query{Query: "deep research", Facets: []string{"year", "access_state", "language"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := stub.last()
+	if got, want := params["facet.field"], []string{"publishDateSort", "access_state_str", "language"}; !slices.Equal(got, want) {
+		t.Errorf("facet.field: got %q, want %q", got, want)
+	}
+	if params.Get("facet") != "true" || params.Get("facet.mincount") != "1" || params.Get("facet.limit") != "10" {
+		t.Errorf("facet parameters %v", params)
+	}
+	want := map[string][]FacetCount{
+		"year":         {{"2025", 21}, {"2024", 9}, {"1969", 1}},
+		"access_state": {{"Open Access", 30}, {"Closed Access", 6}},
+		"language":     {},
+	}
+	if !reflect.DeepEqual(result.Facets, want) {
+		t.Errorf("got facets %v, want %v", result.Facets, want)
+	}
+
+	if _, err := solr.Search(context.Background(), Query{Query: "x", Facets: []string{"publisher"}}); err == nil || !strings.Contains(err.Error(), `unknown facet "publisher"`) {
+		t.Errorf("got %v, want unknown facet error", err)
+	}
+}
+
+func TestSolrErrors(t *testing.T) {
+	if _, err := NewSolr("").Search(context.Background(), Query{Query: "x"}); err == nil || !strings.Contains(err.Error(), "no catalog configured") {
+		t.Errorf("got %v, want no catalog error", err)
+	}
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"msg":"undefined field titel"}}`, http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	if _, err := NewSolr(failing.URL).Search(context.Background(), Query{Query: "x"}); err == nil || !strings.Contains(err.Error(), "unexpected status 400: {\"error\":{\"msg\":\"undefined field titel\"}}") {
+		t.Errorf("got %v, want status error", err)
+	}
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>"))
+	}))
+	defer garbage.Close()
+	if _, err := NewSolr(garbage.URL).Search(context.Background(), Query{Query: "x"}); err == nil || !strings.Contains(err.Error(), "decode response") {
+		t.Errorf("got %v, want decode error", err)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miku/unplugged/scratch/catalog"
+)
+
+// apiURL appends a path and parameters to a base URL, which may have
+// parameters of its own, like a mailto for polite API use.
+func apiURL(base, path string, params url.Values) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	query := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			query.Add(k, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// getJSON fetches a URL and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("catalog request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// filterValue removes the characters separating filters in OpenAlex and
+// Crossref filter parameters.
+var filterValue = strings.NewReplacer(",", " ", ":", " ")
+
+// OpenAlex searches the works of an OpenAlex style API, e.g.
+// https://api.openalex.org.
+type OpenAlex struct {
+	URL    string
+	client *http.Client
+}
+
+func NewOpenAlex(baseURL string) *OpenAlex {
+	return &OpenAlex{URL: baseURL, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (c *OpenAlex) Name() string { return "openalex" }
+
+// Search runs a query against /works. OpenAlex has no ISBN filter and no
+// facets from the catalog, so ISBN searches fail and facets are ignored.
+func (c *OpenAlex) Search(ctx context.Context, q catalog.Query) (*catalog.Result, error) {
+	if q.ISBN != "" {
+		return nil, fmt.Errorf("isbn search is not supported")
+	}
+	page, rows := q.PageRows()
+	params := url.Values{}
+	var filters, desc []string
+	if s := strings.TrimSpace(q.Query); s != "" {
+		params.Set("search", s)
+		desc = append(desc, s)
+	}
+	if q.Title != "" {
+		filters = append(filters, "title.search:"+filterValue.Replace(q.Title))
+	}
+	if q.Author != "" {
+		filters = append(filters, "raw_author_name.search:"+filterValue.Replace(q.Author))
+	}
+	if q.ISSN != "" {
+		filters = append(filters, "primary_location.source.issn:"+filterValue.Replace(q.ISSN))
+	}
+	if len(desc) == 0 && len(filters) == 0 {
+		return nil, fmt.Errorf("query, title, author or issn is required")
+	}
+	if q.YearFrom > 0 {
+		filters = append(filters, fmt.Sprintf("from_publication_date:%d-01-01", q.YearFrom))
+	}
+	if q.YearTo > 0 {
+		filters = append(filters, fmt.Sprintf("to_publication_date:%d-12-31", q.YearTo))
+	}
+	if q.OpenOnly {
+		filters = append(filters, "is_oa:true")
+	}
+	if len(filters) > 0 {
+		params.Set("filter", strings.Join(filters, ","))
+		desc = append(desc, strings.Join(filters, ","))
+	}
+	params.Set("page", strconv.Itoa(page))
+	params.Set("per_page", strconv.Itoa(rows))
+	u, err := apiURL(c.URL, "/works", params)
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Meta struct {
+			Count int `json:"count"`
+		} `json:"meta"`
+		Results []struct {
+			ID              string `json:"id"`
+			DOI             string `json:"doi"`
+			DisplayName     string `json:"display_name"`
+			PublicationYear int    `json:"publication_year"`
+			Authorships     []struct {
+				Author struct {
+					DisplayName string `json:"display_name"`
+				} `json:"author"`
+			} `json:"authorships"`
+			PrimaryLocation struct {
+				LandingPageURL string `json:"landing_page_url"`
+			} `json:"primary_location"`
+			OpenAccess struct {
+				OAURL string `json:"oa_url"`
+			} `json:"open_access"`
+		} `json:"results"`
+	}
+	if err := getJSON(ctx, c.client, u, &data); err != nil {
+		return nil, err
+	}
+	result := &catalog.Result{
+		Query:   strings.Join(desc, " "),
+		Found:   data.Meta.Count,
+		Page:    page,
+		Pages:   (data.Meta.Count + rows - 1) / rows,
+		Records: []catalog.Record{},
+	}
+	for _, w := range data.Results {
+		rec := catalog.Record{Title: w.DisplayName}
+		// IDs are URLs like https://openalex.org/W2741809807. Without one,
+		// recordKey falls back to the URL or title.
+		if w.ID != "" {
+			rec.ID = "openalex:" + w.ID[strings.LastIndex(w.ID, "/")+1:]
+		}
+		for _, a := range w.Authorships {
+			if a.Author.DisplayName != "" {
+				rec.Author = append(rec.Author, a.Author.DisplayName)
+			}
+		}
+		if w.PublicationYear > 0 {
+			rec.Year = strconv.Itoa(w.PublicationYear)
+		}
+		for _, u := range []string{w.DOI, w.PrimaryLocation.LandingPageURL, w.OpenAccess.OAURL} {
+			if u != "" && !slices.Contains(rec.URL, u) {
+				rec.URL = append(rec.URL, u)
+			}
+		}
+		if w.DOI != "" {
+			rec.DOI = []string{w.DOI}
+		}
+		result.Records = append(result.Records, rec)
+	}
+	return result, nil
+}
+
+// Crossref searches the works of a Crossref style API, e.g.
+// https://api.crossref.org. A mailto parameter in the URL gets requests into
+// the polite pool.
+type Crossref struct {
+	URL    string
+	client *http.Client
+}
+
+func NewCrossref(baseURL string) *Crossref {
+	return &Crossref{URL: baseURL, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (c *Crossref) Name() string { return "crossref" }
+
+// Search runs a query against /works. Crossref does not know about open
+// access, so those searches fail, and facets are ignored.
+func (c *Crossref) Search(ctx context.Context, q catalog.Query) (*catalog.Result, error) {
+	if q.OpenOnly {
+		return nil, fmt.Errorf("open access search is not supported")
+	}
+	page, rows := q.PageRows()
+	params := url.Values{}
+	var filters []string
+	if s := strings.TrimSpace(q.Query); s != "" {
+		params.Set("query", s)
+	}
+	if q.Title != "" {
+		params.Set("query.bibliographic", q.Title)
+	}
+	if q.Author != "" {
+		params.Set("query.author", q.Author)
+	}
+	if q.ISBN != "" {
+		filters = append(filters, "isbn:"+filterValue.Replace(q.ISBN))
+	}
+	if q.ISSN != "" {
+		filters = append(filters, "issn:"+filterValue.Replace(q.ISSN))
+	}
+	if len(params) == 0 && len(filters) == 0 {
+		return nil, fmt.Errorf("query, title, author, isbn or issn is required")
+	}
+	if q.YearFrom > 0 {
+		filters = append(filters, fmt.Sprintf("from-pub-date:%d", q.YearFrom))
+	}
+	if q.YearTo > 0 {
+		filters = append(filters, fmt.Sprintf("until-pub-date:%d", q.YearTo))
+	}
+	if len(filters) > 0 {
+		params.Set("filter", strings.Join(filters, ","))
+	}
+	desc := params.Encode()
+	params.Set("rows", strconv.Itoa(rows))
+	params.Set("offset", strconv.Itoa((page-1)*rows))
+	u, err := apiURL(c.URL, "/works", params)
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Message struct {
+			TotalResults int `json:"total-results"`
+			Items        []struct {
+				DOI    string   `json:"DOI"`
+				Title  []string `json:"title"`
+				Author []struct {
+					Given  string `json:"given"`
+					Family string `json:"family"`
+					Name   string `json:"name"` // organizations
+				} `json:"author"`
+				Issued struct {
+					DateParts [][]any `json:"date-parts"`
+				} `json:"issued"`
+				URL  string   `json:"URL"`
+				ISBN []string `json:"ISBN"`
+			} `json:"items"`
+		} `json:"message"`
+	}
+	if err := getJSON(ctx, c.client, u, &data); err != nil {
+		return nil, err
+	}
+	if unescaped, err := url.QueryUnescape(desc); err == nil {
+		desc = unescaped
+	}
+	result := &catalog.Result{
+		Query:   desc,
+		Found:   data.Message.TotalResults,
+		Page:    page,
+		Pages:   (data.Message.TotalResults + rows - 1) / rows,
+		Records: []catalog.Record{},
+	}
+	for _, w := range data.Message.Items {
+		rec := catalog.Record{Title: strings.Join(w.Title, " "), ISBN: w.ISBN}
+		if w.DOI != "" {
+			rec.ID = "crossref:" + strings.ToLower(w.DOI)
+		}
+		for _, a := range w.Author {
+			switch {
+			case a.Family != "" && a.Given != "":
+				rec.Author = append(rec.Author, a.Family+", "+a.Given)
+			case a.Family != "":
+				rec.Author = append(rec.Author, a.Family)
+			case a.Name != "":
+				rec.Author = append(rec.Author, a.Name)
+			}
+		}
+		// Dates are lists like [[2025, 2, 11]], with null for unknown parts.
+		if len(w.Issued.DateParts) > 0 && len(w.Issued.DateParts[0]) > 0 {
+			if year, ok := w.Issued.DateParts[0][0].(float64); ok {
+				rec.Year = strconv.Itoa(int(year))
+			}
+		}
+		if w.URL != "" {
+			rec.URL = []string{w.URL}
+		}
+		if w.DOI != "" {
+			rec.DOI = []string{w.DOI}
+		}
+		result.Records = append(result.Records, rec)
+	}
+	return result, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miku/unplugged/scratch/catalog"
+)
+
+// worksStub serves a fixed works response and keeps the path and query
+// parameters of the last request.
+type worksStub struct {
+	*httptest.Server
+	path   string
+	params url.Values
+}
+
+func newWorksStub(t *testing.T, status int, body string) *worksStub {
+	t.Helper()
+	s := &worksStub{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.path, s.params = r.URL.Path, r.URL.Query()
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+const openAlexWorks = `{
+  "meta": {"count": 42},
+  "results": [
+    {
+      "id": "https://openalex.org/W2741809807",
+      "doi": "https://doi.org/10.7717/peerj.4375",
+      "display_name": "The state of OA",
+      "publication_year": 2018,
+      "authorships": [
+        {"author": {"display_name": "Heather Piwowar"}},
+        {"author": {"display_name": ""}},
+        {"author": {"display_name": "Jason Priem"}}
+      ],
+      "primary_location": {"landing_page_url": "https://doi.org/10.7717/peerj.4375"},
+      "open_access": {"oa_url": "https://peerj.com/articles/4375.pdf"}
+    },
+    {
+      "id": "",
+      "doi": null,
+      "display_name": "A work without identifiers",
+      "publication_year": 0,
+      "authorships": [],
+      "primary_location": {"landing_page_url": "https://example.org/work"},
+      "open_access": {"oa_url": null}
+    }
+  ]
+}`
+
+func TestOpenAlexSearch(t *testing.T) {
+	stub := newWorksStub(t, http.StatusOK, openAlexWorks)
+	c := NewOpenAlex(stub.URL + "/?mailto=dr0@example.org")
+	result, err := c.Search(context.Background(), catalog.Query{
+		Query:    "open access",
+		Author:   "Piwowar, H",
+		YearFrom: 2015,
+		OpenOnly: true,
+		Page:     2,
+		Rows:     5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.path != "/works" {
+		t.Errorf("path = %q, want /works", stub.path)
+	}
+	wantParams := url.Values{
+		"mailto":   {"dr0@example.org"},
+		"search":   {"open access"},
+		"filter":   {"raw_author_name.search:Piwowar  H,from_publication_date:2015-01-01,is_oa:true"},
+		"page":     {"2"},
+		"per_page": {"5"},
+	}
+	if !reflect.DeepEqual(stub.params, wantParams) {
+		t.Errorf("params = %v, want %v", stub.params, wantParams)
+	}
+	if result.Found != 42 || result.Page != 2 || result.Pages != 9 {
+		t.Errorf("found, page, pages = %d, %d, %d, want 42, 2, 9", result.Found, result.Page, result.Pages)
+	}
+	want := []catalog.Record{
+		{
+			ID:     "openalex:W2741809807",
+			Title:  "The state of OA",
+			Author: []string{"Heather Piwowar", "Jason Priem"},
+			Year:   "2018",
+			URL:    []string{"https://doi.org/10.7717/peerj.4375", "https://peerj.com/articles/4375.pdf"},
+			DOI:    []string{"https://doi.org/10.7717/peerj.4375"},
+		},
+		{
+			Title: "A work without identifiers",
+			URL:   []string{"https://example.org/work"},
+		},
+	}
+	if !reflect.DeepEqual(result.Records, want) {
+		t.Errorf("records = %+v, want %+v", result.Records, want)
+	}
+	if key := recordKey(result.Records[1]); key != "https://example.org/work" {
+		t.Errorf("key of record without id = %q, want its URL", key)
+	}
+}
+
+func TestOpenAlexErrors(t *testing.T) {
+	var cases = []struct {
+		status int
+		query  catalog.Query
+		err    string
+	}{
+		{http.StatusOK, catalog.Query{ISBN: "9783161484100"}, "isbn search is not supported"},
+		{http.StatusOK, catalog.Query{YearFrom: 2020}, "query, title, author or issn is required"},
+		{http.StatusForbidden, catalog.Query{Query: "x"}, "unexpected status 403"},
+		{http.StatusOK, catalog.Query{Query: "x"}, "decode response"},
+	}
+	for _, c := range cases {
+		stub := newWorksStub(t, c.status, "not json")
+		_, err := NewOpenAlex(stub.URL).Search(context.Background(), c.query)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Search(%+v) error = %v, want %q", c.query, err, c.err)
+		}
+	}
+}
+
+const crossrefWorks = `{
+  "message": {
+    "total-results": 3,
+    "items": [
+      {
+        "DOI": "10.1000/ABC.1",
+        "title": ["Deep research", "agents"],
+        "author": [
+          {"given": "Ada", "family": "Lovelace"},
+          {"family": "Babbage"},
+          {"name": "Analytical Engine Society"},
+          {"given": "Nobody"}
+        ],
+        "issued": {"date-parts": [[2025, 2, 11]]},
+        "URL": "https://doi.org/10.1000/abc.1",
+        "ISBN": ["9783161484100"]
+      },
+      {
+        "title": ["Undated report"],
+        "issued": {"date-parts": [[null]]},
+        "URL": "https://example.org/report"
+      },
+      {
+        "title": ["Untitled, unlinked"],
+        "issued": {}
+      }
+    ]
+  }
+}`
+
+func TestCrossrefSearch(t *testing.T) {
+	stub := newWorksStub(t, http.StatusOK, crossrefWorks)
+	c := NewCrossref(stub.URL + "?mailto=dr0@example.org")
+	result, err := c.Search(context.Background(), catalog.Query{
+		Title:    "deep research",
+		ISBN:     "978-3-16-148410-0",
+		YearFrom: 2020,
+		YearTo:   2025,
+		Page:     3,
+		Rows:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.path != "/works" {
+		t.Errorf("path = %q, want /works", stub.path)
+	}
+	wantParams := url.Values{
+		"mailto":              {"dr0@example.org"},
+		"query.bibliographic": {"deep research"},
+		"filter":              {"isbn:978-3-16-148410-0,from-pub-date:2020,until-pub-date:2025"},
+		"rows":                {"2"},
+		"offset":              {"4"},
+	}
+	if !reflect.DeepEqual(stub.params, wantParams) {
+		t.Errorf("params = %v, want %v", stub.params, wantParams)
+	}
+	wantQuery := "filter=isbn:978-3-16-148410-0,from-pub-date:2020,until-pub-date:2025&query.bibliographic=deep research"
+	if result.Query != wantQuery {
+		t.Errorf("query = %q, want %q", result.Query, wantQuery)
+	}
+	if result.Found != 3 || result.Page != 3 || result.Pages != 2 {
+		t.Errorf("found, page, pages = %d, %d, %d, want 3, 3, 2", result.Found, result.Page, result.Pages)
+	}
+	want := []catalog.Record{
+		{
+			ID:     "crossref:10.1000/abc.1",
+			Title:  "Deep research agents",
+			Author: []string{"Lovelace, Ada", "Babbage", "Analytical Engine Society"},
+			Year:   "2025",
+			URL:    []string{"https://doi.org/10.1000/abc.1"},
+			DOI:    []string{"10.1000/ABC.1"},
+			ISBN:   []string{"9783161484100"},
+		},
+		{
+			Title: "Undated report",
+			URL:   []string{"https://example.org/report"},
+		},
+		{
+			Title: "Untitled, unlinked",
+		},
+	}
+	if !reflect.DeepEqual(result.Records, want) {
+		t.Errorf("records = %+v, want %+v", result.Records, want)
+	}
+	for i, key := range []string{"crossref:10.1000/abc.1", "https://example.org/report", "Untitled, unlinked"} {
+		if got := recordKey(result.Records[i]); got != key {
+			t.Errorf("key of record %d = %q, want %q", i, got, key)
+		}
+	}
+}
+
+func TestCrossrefErrors(t *testing.T) {
+	var cases = []struct {
+		status int
+		query  catalog.Query
+		err    string
+	}{
+		{http.StatusOK, catalog.Query{Query: "x", OpenOnly: true}, "open access search is not supported"},
+		{http.StatusOK, catalog.Query{Query: " ", YearTo: 2020}, "query, title, author, isbn or issn is required"},
+		{http.StatusInternalServerError, catalog.Query{Query: "x"}, "unexpected status 500"},
+		{http.StatusOK, catalog.Query{Query: "x"}, "decode response"},
+	}
+	for _, c := range cases {
+		stub := newWorksStub(t, c.status, "not json")
+		_, err := NewCrossref(stub.URL).Search(context.Background(), c.query)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Search(%+v) error = %v, want %q", c.query, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/miku/unplugged/scratch/dr0/normalize"
+)
+
+// Bibliography returns the sources of a session as citations: normalized,
+// with DOIs taken from URLs and duplicates merged.
+func (s *Session) Bibliography() []normalize.Record {
+	records := make([]normalize.Record, len(s.Sources))
+	for i, src := range s.Sources {
+		r := src.Record
+		records[i] = normalize.Record{ID: r.ID, Title: r.Title, Author: r.Author, Year: r.Year, URL: r.URL, DOI: r.DOI, ISBN: r.ISBN}
+	}
+	records, _ = normalize.Dedupe(records)
+	return records
+}
+
+// bibFormats are the export formats, by name, with the file name used in
+// the session directory.
+var bibFormats = map[string]struct {
+	File  string
+	Write func(io.Writer, []normalize.Record) error
+}{
+	"bibtex":   {"references.bib", WriteBibTeX},
+	"csl-json": {"references.json", WriteCSLJSON},
+	"ris":      {"references.ris", WriteRIS},
+}
+
+// splitName splits a normalized author into family and given name. Names
+// without a comma, like organizations, have no given name.
+func splitName(author string) (family, given string) {
+	family, given, _ = strings.Cut(author, ",")
+	return strings.TrimSpace(family), strings.TrimSpace(given)
+}
+
+// citationKeys returns BibTeX keys like "jones2025openai", made unique with
+// a suffix of letters. Keys without a suffix are reserved first, so that a
+// suffixed key never takes the key of another record.
+func citationKeys(records []normalize.Record) []string {
+	keep := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, s)
+	}
+	var (
+		keys = make([]string, len(records))
+		used = make(map[string]bool)
+	)
+	for i, r := range records {
+		var key string
+		if len(r.Author) > 0 {
+			key = keep(normalize.AuthorKey(r.Author[0]))
+		}
+		if key == "" {
+			key = "anon"
+		}
+		key += keep(r.Year)
+		if words := strings.Fields(normalize.TitleKey(r.Title)); len(words) > 0 {
+			key += keep(words[0])
+		}
+		keys[i] = key
+		used[key] = true
+	}
+	first := make(map[string]bool)
+	for i, key := range keys {
+		if !first[key] {
+			first[key] = true
+			continue
+		}
+		// The second record gets "b", like the second edition of a year.
+		for n := 2; ; n++ {
+			if k := key + letterSuffix(n); !used[k] {
+				keys[i], used[k] = k, true
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// letterSuffix returns a, b, ..., z, aa, ab, ... for n from 1.
+func letterSuffix(n int) string {
+	var s []byte
+	for ; n > 0; n = (n - 1) / 26 {
+		s = append([]byte{byte('a' + (n-1)%26)}, s...)
+	}
+	return string(s)
+}
+
+// bibtexEscaper escapes characters with a special meaning in BibTeX.
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`,
+)
+
+// verbatimEscaper escapes the url and doi fields, which biblatex reads
+// verbatim, so that only braces are special.
+var verbatimEscaper = strings.NewReplacer("{", "%7B", "}", "%7D")
+
+// WriteBibTeX writes records as BibTeX entries, @book for records with an
+// ISBN and @misc otherwise, since the catalog does not tell more.
+func WriteBibTeX(w io.Writer, records []normalize.Record) error {
+	keys := citationKeys(records)
+	for i, r := range records {
+		kind := "misc"
+		if len(r.ISBN) > 0 {
+			kind = "book"
+		}
+		var fields [][2]string
+		if len(r.Author) > 0 {
+			authors := make([]string, len(r.Author))
+			for j, a := range r.Author {
+				if family, given := splitName(a); given == "" {
+					// Keeps organizations from being split into names.
+					authors[j] = "{" + bibtexEscaper.Replace(family) + "}"
+				} else {
+					authors[j] = bibtexEscaper.Replace(a)
+				}
+			}
+			fields = append(fields, [2]string{"author", strings.Join(authors, " and ")})
+		}
+		// Double braces keep the capitalization of the title.
+		fields = append(fields, [2]string{"title", "{" + bibtexEscaper.Replace(r.Title) + "}"})
+		if r.Year != "" {
+			fields = append(fields, [2]string{"year", bibtexEscaper.Replace(r.Year)})
+		}
+		if len(r.DOI) > 0 {
+			fields = append(fields, [2]string{"doi", verbatimEscaper.Replace(r.DOI[0])})
+		}
+		if len(r.ISBN) > 0 {
+			fields = append(fields, [2]string{"isbn", r.ISBN[0]})
+		}
+		if len(r.URL) > 0 {
+			fields = append(fields, [2]string{"url", verbatimEscaper.Replace(r.URL[0])})
+		}
+		if _, err := fmt.Fprintf(w, "@%s{%s,\n", kind, keys[i]); err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if _, err := fmt.Fprintf(w, "  %s = {%s},\n", f[0], f[1]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprint(w, "}\n\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cslName is a name in CSL-JSON, either split or as one literal.
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// cslItem is an item in CSL-JSON, as read by citeproc, Zotero and pandoc.
+type cslItem struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Title  string    `json:"title"`
+	Author []cslName `json:"author,omitempty"`
+	Issued *cslDate  `json:"issued,omitempty"`
+	DOI    string    `json:"DOI,omitempty"`
+	ISBN   string    `json:"ISBN,omitempty"`
+	URL    string    `json:"URL,omitempty"`
+}
+
+// WriteCSLJSON writes records as a CSL-JSON array, with the BibTeX keys as
+// IDs.
+func WriteCSLJSON(w io.Writer, records []normalize.Record) error {
+	keys := citationKeys(records)
+	items := make([]cslItem, len(records))
+	for i, r := range records {
+		item := cslItem{ID: keys[i], Type: "document", Title: r.Title}
+		if len(r.ISBN) > 0 {
+			item.Type = "book"
+			item.ISBN = r.ISBN[0]
+		}
+		for _, a := range r.Author {
+			if family, given := splitName(a); given == "" {
+				item.Author = append(item.Author, cslName{Literal: family})
+			} else {
+				item.Author = append(item.Author, cslName{Family: family, Given: given})
+			}
+		}
+		if year, err := strconv.Atoi(r.Year); err == nil {
+			item.Issued = &cslDate{DateParts: [][]int{{year}}}
+		}
+		if len(r.DOI) > 0 {
+			item.DOI = r.DOI[0]
+		}
+		if len(r.URL) > 0 {
+			item.URL = r.URL[0]
+		}
+		items[i] = item
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(items)
+}
+
+// WriteRIS writes records in the RIS format, BOOK for records with an ISBN
+// and GEN otherwise.
+func WriteRIS(w io.Writer, records []normalize.Record) error {
+	for _, r := range records {
+		var sb strings.Builder
+		tag := func(name, value string) {
+			if value = strings.TrimSpace(value); value != "" {
+				fmt.Fprintf(&sb, "%s  - %s\r\n", name, value)
+			}
+		}
+		if len(r.ISBN) > 0 {
+			tag("TY", "BOOK")
+		} else {
+			tag("TY", "GEN")
+		}
+		tag("TI", r.Title)
+		for _, a := range r.Author {
+			tag("AU", a)
+		}
+		tag("PY", r.Year)
+		for _, doi := range r.DOI {
+			tag("DO", doi)
+		}
+		for _, isbn := range r.ISBN {
+			tag("SN", isbn)
+		}
+		for _, u := range r.URL {
+			tag("UR", u)
+		}
+		tag("ID", r.ID)
+		sb.WriteString("ER  - \r\n\r\n")
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/miku/unplugged/scratch/dr0/normalize"
+)
+
+// bibRecords are normalized records with characters special to BibTeX, an
+// organization as author, a book and a record without author or year.
+var bibRecords = []normalize.Record{
+	{
+		ID:     "solr:1",
+		Title:  "OpenAI & the {deep} research_agents",
+		Author: []string{"Jones, Ann", "United States. Naval Ship Systems Command."},
+		Year:   "2025",
+		DOI:    []string{"10.1000/a_b"},
+		URL:    []string{"https://example.org/a_b?x={1}"},
+	},
+	{ID: "crossref:2", Title: "Ocean", Author: []string{"Shiri, Ali"}, Year: "1969", ISBN: []string{"9783161484100"}},
+	{ID: "3", Title: "Untitled report"},
+}
+
+func TestCitationKeys(t *testing.T) {
+	ocean := normalize.Record{Title: "Ocean", Author: []string{"Smith, Jo"}, Year: "2020"}
+	var cases = []struct {
+		about   string
+		records []normalize.Record
+		want    []string
+	}{
+		{"keys from author, year and title", bibRecords, []string{"jones2025openai", "shiri1969ocean", "anonuntitled"}},
+		{"collisions get letters", []normalize.Record{ocean, ocean, ocean}, []string{"smith2020ocean", "smith2020oceanb", "smith2020oceanc"}},
+		{
+			"a suffix does not take another record's key",
+			[]normalize.Record{ocean, ocean, {Title: "Oceanb", Author: []string{"Smith, Jo"}, Year: "2020"}},
+			[]string{"smith2020ocean", "smith2020oceanc", "smith2020oceanb"},
+		},
+	}
+	for _, c := range cases {
+		if got := citationKeys(c.records); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.about, got, c.want)
+		}
+	}
+	var many []normalize.Record
+	for range 60 {
+		many = append(many, ocean)
+	}
+	keys := citationKeys(many)
+	valid := regexp.MustCompile(`^smith2020ocean[a-z]*$`)
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if !valid.MatchString(k) || seen[k] {
+			t.Errorf("invalid or repeated key %q", k)
+		}
+		seen[k] = true
+	}
+	if keys[26] != "smith2020oceanaa" || keys[59] != "smith2020oceanbh" {
+		t.Errorf("keys 27 and 60 are %q and %q", keys[26], keys[59])
+	}
+}
+
+func TestWriteBibTeX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteBibTeX(&buf, bibRecords); err != nil {
+		t.Fatal(err)
+	}
+	want := `@misc{jones2025openai,
+  author = {Jones, Ann and {United States. Naval Ship Systems Command.}},
+  title = {{OpenAI \& the \{deep\} research\_agents}},
+  year = {2025},
+  doi = {10.1000/a_b},
+  url = {https://example.org/a_b?x=%7B1%7D},
+}
+
+@book{shiri1969ocean,
+  author = {Shiri, Ali},
+  title = {{Ocean}},
+  year = {1969},
+  isbn = {9783161484100},
+}
+
+@misc{anonuntitled,
+  title = {{Untitled report}},
+}
+
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteCSLJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCSLJSON(&buf, bibRecords); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]any{
+		{
+			"id":    "jones2025openai",
+			"type":  "document",
+			"title": "OpenAI & the {deep} research_agents",
+			"author": []any{
+				map[string]any{"family": "Jones", "given": "Ann"},
+				map[string]any{"literal": "United States. Naval Ship Systems Command."},
+			},
+			"issued": map[string]any{"date-parts": []any{[]any{2025.0}}},
+			"DOI":    "10.1000/a_b",
+			"URL":    "https://example.org/a_b?x={1}",
+		},
+		{
+			"id":     "shiri1969ocean",
+			"type":   "book",
+			"title":  "Ocean",
+			"author": []any{map[string]any{"family": "Shiri", "given": "Ali"}},
+			"issued": map[string]any{"date-parts": []any{[]any{1969.0}}},
+			"ISBN":   "9783161484100",
+		},
+		{"id": "anonuntitled", "type": "document", "title": "Untitled report"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWriteRIS(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteRIS(&buf, bibRecords); err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		"TY  - GEN",
+		"TI  - OpenAI & the {deep} research_agents",
+		"AU  - Jones, Ann",
+		"AU  - United States. Naval Ship Systems Command.",
+		"PY  - 2025",
+		"DO  - 10.1000/a_b",
+		"UR  - https://example.org/a_b?x={1}",
+		"ID  - solr:1",
+		"ER  - ",
+		"",
+		"TY  - BOOK",
+		"TI  - Ocean",
+		"AU  - Shiri, Ali",
+		"PY  - 1969",
+		"SN  - 9783161484100",
+		"ID  - crossref:2",
+		"ER  - ",
+		"",
+		"TY  - GEN",
+		"TI  - Untitled report",
+		"ID  - 3",
+		"ER  - ",
+		"",
+	}
+	want := strings.Join(lines, "\r\n") + "\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBibFormats(t *testing.T) {
+	for name, f := range bibFormats {
+		var buf bytes.Buffer
+		if err := f.Write(&buf, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if name == "csl-json" && strings.TrimSpace(buf.String()) != "[]" {
+			t.Errorf("%s: empty bibliography is %q, want []", name, buf.String())
+		}
+		if name != "csl-json" && buf.Len() != 0 {
+			t.Errorf("%s: empty bibliography is %q, want nothing", name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/miku/unplugged/scratch/catalog"
+	"github.com/miku/unplugged/scratch/dr0/normalize"
+	"github.com/miku/unplugged/scratch/llm"
+)
+
+// EvalCase is one relevance judgment task: a question, the records a
+// catalog search returned and the IDs of the relevant ones, judged by hand.
+// A dataset is a file with one case per line, see testdata/relevance.jsonl.
+type EvalCase struct {
+	Question   string           `json:"question"`
+	Candidates []catalog.Record `json:"candidates"`
+	Relevant   []string         `json:"relevant"`
+}
+
+// LoadEvalCases reads cases from JSON lines files.
+func LoadEvalCases(names ...string) ([]EvalCase, error) {
+	var cases []EvalCase
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var c EvalCase
+			if err := json.Unmarshal([]byte(line), &c); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+			}
+			cases = append(cases, c)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return cases, nil
+}
+
+// titlesPrompt is the prompt from the experiment in the README.
+func titlesPrompt(c EvalCase) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s and found following entries in a catalog.\n", strings.TrimRight(c.Question, "."))
+	for _, r := range c.Candidates {
+		b, _ := json.Marshal(struct {
+			Title  string   `json:"title"`
+			Author []string `json:"author"`
+			Year   any      `json:"year"`
+			URL    []string `json:"url"`
+		}{r.Title, r.Author, nilIfEmpty(r.Year), r.URL})
+		fmt.Fprintf(&sb, "%s\n", b)
+	}
+	sb.WriteString("which look suitable for the question?\nonly output the titles of the relevant entries, nothing more")
+	return sb.String()
+}
+
+func nilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+var (
+	thinkBlock = regexp.MustCompile(`(?s)<think>.*?</think>`)
+	listMarker = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s*`)
+)
+
+// minTitleMatch is the similarity above which an output line names a
+// candidate, allowing for small changes models make when copying titles.
+const minTitleMatch = 0.85
+
+// parseTitles returns the IDs of the candidates whose titles appear in a
+// response, one title per line. Candidates with the same title are all
+// chosen.
+func parseTitles(content string, candidates []catalog.Record) []string {
+	content = thinkBlock.ReplaceAllString(content, "")
+	var ids []string
+	for _, line := range strings.Split(content, "\n") {
+		line = listMarker.ReplaceAllString(line, "")
+		line = strings.Trim(strings.TrimSpace(line), `"'`+"`")
+		if line == "" {
+			continue
+		}
+		best := 0.0
+		for _, c := range candidates {
+			best = max(best, normalize.TitleSimilarity(line, c.Title))
+		}
+		if best < minTitleMatch {
+			continue
+		}
+		for _, c := range candidates {
+			if normalize.TitleSimilarity(line, c.Title) == best && !slices.Contains(ids, c.ID) {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// EvalResult is the outcome of one case for one model.
+type EvalResult struct {
+	Model     string
+	Case      int
+	Chosen    []string
+	Precision float64
+	Recall    float64
+	F1        float64
+	Latency   time.Duration
+	Err       error
+}
+
+// score compares chosen IDs to the gold set. An empty choice for an empty
+// gold set is perfect.
+func score(chosen, gold []string) (precision, recall, f1 float64) {
+	tp := 0
+	for _, id := range chosen {
+		if slices.Contains(gold, id) {
+			tp++
+		}
+	}
+	precision, recall = 1, 1
+	if len(chosen) > 0 {
+		precision = float64(tp) / float64(len(chosen))
+	} else if len(gold) > 0 {
+		precision = 0
+	}
+	if len(gold) > 0 {
+		recall = float64(tp) / float64(len(gold))
+	}
+	if precision+recall > 0 {
+		f1 = 2 * precision * recall / (precision + recall)
+	}
+	return precision, recall, f1
+}
+
+// runEvalCase judges one case, either with the free text prompt from the
+// README ("titles") or with the structured judgment dr0 uses ("judge").
+func runEvalCase(client *llm.Client, model, method string, c EvalCase) ([]string, time.Duration, error) {
+	started := time.Now()
+	switch method {
+	case "titles":
+		resp, err := client.Chat(llm.ChatRequest{
+			Model:    model,
+			Messages: []llm.Message{{Role: "user", Content: titlesPrompt(c)}},
+		})
+		if err != nil {
+			return nil, time.Since(started), err
+		}
+		return parseTitles(resp.Message.Content, c.Candidates), time.Since(started), nil
+	case "judge":
+		judgments, err := JudgeRelevance(client, model, c.Question, "", c.Candidates)
+		if err != nil {
+			return nil, time.Since(started), err
+		}
+		var ids []string
+		for _, j := range judgments {
+			if j.Relevant {
+				ids = append(ids, j.ID)
+			}
+		}
+		return ids, time.Since(started), nil
+	}
+	return nil, 0, fmt.Errorf("unknown eval method %q, use titles or judge", method)
+}
+
+// RunEval runs all cases for all models and writes a table with mean
+// precision, recall, F1 and latency per model. Failed cases count as zero.
+func RunEval(client *llm.Client, models []string, method string, cases []EvalCase, w io.Writer) ([]EvalResult, error) {
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no eval cases")
+	}
+	var results []EvalResult
+	for _, model := range models {
+		for i, c := range cases {
+			chosen, latency, err := runEvalCase(client, model, method, c)
+			r := EvalResult{Model: model, Case: i + 1, Chosen: chosen, Latency: latency, Err: err}
+			if err == nil {
+				r.Precision, r.Recall, r.F1 = score(chosen, c.Relevant)
+				log.Printf("%s case %d: P=%.2f R=%.2f F1=%.2f in %v", model, i+1, r.Precision, r.Recall, r.F1,
+					latency.Round(time.Millisecond))
+			} else {
+				log.Printf("%s case %d: %v", model, i+1, err)
+			}
+			results = append(results, r)
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "model\tcases\terrors\tprecision\trecall\tF1\tlatency\t\n")
+	for _, model := range models {
+		var n, errs int
+		var p, r, f float64
+		var latency time.Duration
+		for _, res := range results {
+			if res.Model != model {
+				continue
+			}
+			n++
+			if res.Err != nil {
+				errs++
+			}
+			p, r, f = p+res.Precision, r+res.Recall, f+res.F1
+			latency += res.Latency
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.3f\t%.3f\t%.3f\t%v\t\n", model, n, errs, p/float64(n), r/float64(n), f/float64(n),
+			(latency / time.Duration(n)).Round(time.Millisecond))
+	}
+	return results, tw.Flush()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miku/unplugged/scratch/catalog"
+	"github.com/miku/unplugged/scratch/llm"
+)
+
+var alvinCandidates = []catalog.Record{
+	{ID: "a", Title: "Recovery of deep research vehicle Alvin /"},
+	{ID: "b", Title: "Shallow water fishing"},
+	{ID: "c", Title: "Alvin and the deep sea"},
+	{ID: "d", Title: "Alvin and the deep sea"},
+}
+
+func TestParseTitles(t *testing.T) {
+	var cases = []struct {
+		about   string
+		content string
+		want    []string
+	}{
+		{"plain lines", "Recovery of deep research vehicle Alvin\nShallow water fishing", []string{"a", "b"}},
+		{
+			"list markers and quotes",
+			"1. \"Recovery of deep research vehicle Alvin\"\n2) `Shallow water fishing`\n- 'Alvin and the deep sea'\n* Nothing else\n• ",
+			[]string{"a", "b", "c", "d"},
+		},
+		{"think blocks are ignored", "<think>Maybe Shallow water fishing?\nNo.</think>\nRecovery of deep research vehicle Alvin", []string{"a"}},
+		{"near misses match", "Recovery of the deep-research vehicle Alvin.", []string{"a"}},
+		{"other lines do not", "Here are the relevant titles:\nDeep sea vehicles\nNone", nil},
+		{"duplicate titles are all chosen once", "Alvin and the deep sea\nAlvin and the Deep Sea", []string{"c", "d"}},
+		{"empty answer", "", nil},
+	}
+	for _, c := range cases {
+		if got := parseTitles(c.content, alvinCandidates); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.about, got, c.want)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	var cases = []struct {
+		about        string
+		chosen, gold []string
+		precision    float64
+		recall       float64
+		f1           float64
+	}{
+		{"both empty", nil, nil, 1, 1, 1},
+		{"nothing chosen", nil, []string{"a"}, 0, 0, 0},
+		{"chosen without gold", []string{"a"}, nil, 0, 1, 0},
+		{"perfect", []string{"a", "c"}, []string{"c", "a"}, 1, 1, 1},
+		{"half", []string{"a", "b"}, []string{"a", "c"}, 0.5, 0.5, 0.5},
+		{"precise but incomplete", []string{"a"}, []string{"a", "c", "d", "e"}, 1, 0.25, 0.4},
+	}
+	for _, c := range cases {
+		p, r, f := score(c.chosen, c.gold)
+		if p != c.precision || r != c.recall || f != c.f1 {
+			t.Errorf("%s: got P=%v R=%v F1=%v, want %v %v %v", c.about, p, r, f, c.precision, c.recall, c.f1)
+		}
+	}
+}
+
+func TestRunEval(t *testing.T) {
+	cases := []EvalCase{
+		{Question: "I am looking for books about the submarine Alvin.", Candidates: alvinCandidates[:3], Relevant: []string{"a", "c"}},
+		{Question: "I am looking for poems.", Candidates: []catalog.Record{{ID: "p", Title: "Collected letters"}}},
+	}
+	// Answers by model and case, an empty answer for a case is a failure.
+	answers := map[string][]string{
+		"good": {"1. Recovery of deep research vehicle Alvin\n2. Alvin and the deep sea", "None of them."},
+		"half": {"<think>Alvin and the deep sea, maybe</think>- Recovery of deep research vehicle Alvin", ""},
+	}
+	var prompts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req llm.ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prompt := req.Messages[0].Content
+		prompts = append(prompts, prompt)
+		i := 0
+		if strings.Contains(prompt, "poems") {
+			i = 1
+		}
+		answer := answers[req.Model][i]
+		if answer == "" {
+			http.Error(w, `{"error":"model runner crashed"}`, http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(llm.ChatResponse{Message: llm.Message{Role: "assistant", Content: answer}, Done: true})
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	results, err := RunEval(llm.NewClient(srv.URL, 5*time.Second), []string{"good", "half"}, "titles", cases, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 || results[3].Err == nil || !reflect.DeepEqual(results[2].Chosen, []string{"a"}) {
+		t.Errorf("got results %+v", results)
+	}
+	want := `I am looking for books about the submarine Alvin and found following entries in a catalog.
+{"title":"Recovery of deep research vehicle Alvin /","author":null,"year":null,"url":null}`
+	if !strings.HasPrefix(prompts[0], want) || !strings.HasSuffix(prompts[0], "only output the titles of the relevant entries, nothing more") {
+		t.Errorf("got prompt %q", prompts[0])
+	}
+	// The latency column is left out, it varies.
+	var table [][]string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := strings.Fields(line)
+		table = append(table, fields[:len(fields)-1])
+	}
+	wantTable := [][]string{
+		{"model", "cases", "errors", "precision", "recall", "F1"},
+		{"good", "2", "0", "1.000", "1.000", "1.000"},
+		{"half", "2", "1", "0.500", "0.250", "0.333"},
+	}
+	if !reflect.DeepEqual(table, wantTable) {
+		t.Errorf("got table\n%s", out.String())
+	}
+	results, err = RunEval(llm.NewClient(srv.URL, time.Second), []string{"good"}, "guess", cases, &out)
+	if err != nil || results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "unknown eval method") {
+		t.Errorf("unknown method: got %v and results %+v", err, results)
+	}
+	if _, err := RunEval(llm.NewClient(srv.URL, time.Second), []string{"good"}, "titles", nil, &out); err == nil {
+		t.Error("got no error for an empty dataset")
+	}
+}
+
+func TestLoadEvalCases(t *testing.T) {
+	cases, err := LoadEvalCases("testdata/relevance.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no cases in testdata/relevance.jsonl")
+	}
+	for i, c := range cases {
+		if c.Question == "" || len(c.Candidates) == 0 {
+			t.Errorf("case %d has no question or candidates", i+1)
+		}
+		for _, id := range c.Relevant {
+			if !slices.ContainsFunc(c.Candidates, func(r catalog.Record) bool { return r.ID == id }) {
+				t.Errorf("case %d: relevant %q is not a candidate", i+1, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miku/unplugged/scratch/catalog"
+)
+
+// CatalogBackend is a searchable catalog, like our Solr index or a works API.
+type CatalogBackend interface {
+	Name() string
+	Search(ctx context.Context, q catalog.Query) (*catalog.Result, error)
+}
+
+// rrfK is the constant of reciprocal rank fusion; it dampens the weight of
+// the first few ranks, 60 is the value from the original paper.
+const rrfK = 60
+
+// FederatedCatalog searches several backends at once and fuses their
+// results into one ranked list. Backends that fail or time out are logged
+// and left out; the search fails only if all of them fail.
+type FederatedCatalog struct {
+	Backends []CatalogBackend
+	Timeout  time.Duration // per backend, zero for none
+}
+
+func (f *FederatedCatalog) Name() string { return "federated" }
+
+// Search runs the query on all backends concurrently. Records found by
+// several backends are merged, see mergeDuplicates, and ranked by
+// reciprocal rank fusion: each record scores the sum of 1/(rrfK+rank) over
+// the backends that returned it, at its best rank in each. Merged
+// describes the duplicates merged into the records on the page. The page
+// has at most the requested number of rows; Found is the sum over the
+// backends and so may count a work more than once.
+func (f *FederatedCatalog) Search(ctx context.Context, q catalog.Query) (*catalog.Result, error) {
+	if len(f.Backends) == 0 {
+		return nil, fmt.Errorf("no catalog configured, set -catalog-url, -openalex-url or -crossref-url")
+	}
+	var (
+		wg      sync.WaitGroup
+		results = make([]*catalog.Result, len(f.Backends))
+		errs    = make([]error, len(f.Backends))
+	)
+	for i, backend := range f.Backends {
+		wg.Go(func() {
+			ctx := ctx
+			if f.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+				defer cancel()
+			}
+			started := time.Now()
+			results[i], errs[i] = backend.Search(ctx, q)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", backend.Name(), errs[i])
+				log.Printf("catalog %v", errs[i])
+				return
+			}
+			log.Printf("catalog %s: %d hits in %v", backend.Name(), results[i].Found, time.Since(started).Round(time.Millisecond))
+		})
+	}
+	wg.Wait()
+	_, rows := q.PageRows()
+	fused := &catalog.Result{Page: max(q.Page, 1), Records: []catalog.Record{}}
+	var queries []string
+	var ranked [][]catalog.Record
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		name := f.Backends[i].Name()
+		queries = append(queries, name+": "+result.Query)
+		fused.Found += result.Found
+		fused.Pages = max(fused.Pages, result.Pages)
+		ranked = append(ranked, withCatalog(result.Records, name))
+		for facet, counts := range result.Facets {
+			if fused.Facets == nil {
+				fused.Facets = make(map[string][]catalog.FacetCount)
+			}
+			fused.Facets[facet] = mergeFacetCounts(fused.Facets[facet], counts)
+		}
+	}
+	if len(ranked) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	fused.Query = strings.Join(queries, "; ")
+	fused.Records, fused.Merged = fuseRanks(ranked, rows)
+	return fused, nil
+}
+
+// withCatalog returns copies of records attributed to a backend.
+func withCatalog(records []catalog.Record, name string) []catalog.Record {
+	result := make([]catalog.Record, len(records))
+	for i, rec := range records {
+		rec.Catalogs = []string{name}
+		result[i] = rec
+	}
+	return result
+}
+
+// mergeFacetCounts adds counts of the same value.
+func mergeFacetCounts(a, b []catalog.FacetCount) []catalog.FacetCount {
+	result := slices.Clone(a)
+	for _, fc := range b {
+		i := slices.IndexFunc(result, func(x catalog.FacetCount) bool { return x.Value == fc.Value })
+		if i < 0 {
+			result = append(result, fc)
+		} else {
+			result[i].Count += fc.Count
+		}
+	}
+	slices.SortStableFunc(result, func(x, y catalog.FacetCount) int { return cmp.Compare(y.Count, x.Count) })
+	return result
+}
+
+// fuseRanks merges ranked lists of records into one with reciprocal rank
+// fusion and returns the best rows records, along with descriptions of the
+// duplicates merged into them; every merge is logged. A list that returns
+// a work more than once counts once, with its best rank. Ties keep the
+// order in which the lists take turns, so the first list wins.
+func fuseRanks(lists [][]catalog.Record, rows int) ([]catalog.Record, []string) {
+	var (
+		records []catalog.Record
+		ranks   []int
+		from    []int // index of the list
+	)
+	for rank := 1; ; rank++ {
+		added := false
+		for l, list := range lists {
+			if rank <= len(list) {
+				records = append(records, list[rank-1])
+				ranks = append(ranks, rank)
+				from = append(from, l)
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	merged, groups, merges := mergeDuplicates(records)
+	scores := make([]float64, len(merged))
+	for i, group := range groups {
+		if len(merges[i].Merged) > 0 {
+			log.Print(merges[i])
+		}
+		best := make([]int, len(lists)) // best rank per list, zero if absent
+		for _, j := range group {
+			if best[from[j]] == 0 || ranks[j] < best[from[j]] {
+				best[from[j]] = ranks[j]
+			}
+		}
+		for _, rank := range best {
+			if rank > 0 {
+				scores[i] += 1 / float64(rrfK+rank)
+			}
+		}
+	}
+	order := make([]int, len(merged))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(scores[b], scores[a]) })
+	var (
+		result       = make([]catalog.Record, 0, min(rows, len(order)))
+		descriptions []string
+	)
+	for _, i := range order[:min(rows, len(order))] {
+		result = append(result, merged[i])
+		if len(merges[i].Merged) > 0 {
+			descriptions = append(descriptions, describeMerge(merges[i]))
+		}
+	}
+	return result, descriptions
+}
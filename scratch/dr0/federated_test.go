@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miku/unplugged/scratch/catalog"
+)
+
+// fakeBackend returns fixed records or an error after a delay, or fails
+// when the context ends first.
+type fakeBackend struct {
+	name    string
+	records []catalog.Record
+	err     error
+	delay   time.Duration
+}
+
+func (b *fakeBackend) Name() string { return b.name }
+
+func (b *fakeBackend) Search(ctx context.Context, q catalog.Query) (*catalog.Result, error) {
+	select {
+	case <-time.After(b.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &catalog.Result{Query: q.Query, Found: len(b.records), Page: 1, Pages: 1, Records: b.records}, nil
+}
+
+// ids returns the record IDs and the catalogs of each record.
+func ids(records []catalog.Record) ([]string, [][]string) {
+	var keys []string
+	var catalogs [][]string
+	for _, r := range records {
+		keys = append(keys, r.ID)
+		catalogs = append(catalogs, r.Catalogs)
+	}
+	return keys, catalogs
+}
+
+func TestFederatedSearch(t *testing.T) {
+	f := &FederatedCatalog{Backends: []CatalogBackend{
+		&fakeBackend{name: "a", records: []catalog.Record{
+			{ID: "a1", Title: "Only in a"},
+			{ID: "a2", Title: "Found by both", DOI: []string{"10.1000/both"}},
+		}},
+		&fakeBackend{name: "b", records: []catalog.Record{
+			{ID: "b1", Title: "Only in b"},
+			{ID: "b2", Title: "Found by both, again", DOI: []string{"https://doi.org/10.1000/BOTH"}},
+		}},
+	}}
+	result, err := f.Search(context.Background(), catalog.Query{Query: "q"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The work found by both scores 2/62, more than 1/61 for the first
+	// ranks; a1 beats b1 on the tie, since a takes its turn first.
+	keys, catalogs := ids(result.Records)
+	if want := []string{"a2", "a1", "b1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("records = %v, want %v", keys, want)
+	}
+	if want := [][]string{{"a", "b"}, {"a"}, {"b"}}; !reflect.DeepEqual(catalogs, want) {
+		t.Errorf("catalogs = %v, want %v", catalogs, want)
+	}
+	if result.Query != "a: q; b: q" || result.Found != 4 {
+		t.Errorf("query, found = %q, %d, want %q, 4", result.Query, result.Found, "a: q; b: q")
+	}
+	if len(result.Merged) != 1 || !strings.Contains(result.Merged[0], "same DOI 10.1000/both") {
+		t.Errorf("merged = %q, want one merge by DOI", result.Merged)
+	}
+}
+
+func TestFederatedSearchRows(t *testing.T) {
+	var records []catalog.Record
+	for _, id := range []string{"r1", "r2", "r3", "r4"} {
+		records = append(records, catalog.Record{ID: id, Title: "Work " + id})
+	}
+	f := &FederatedCatalog{Backends: []CatalogBackend{&fakeBackend{name: "a", records: records}}}
+	result, err := f.Search(context.Background(), catalog.Query{Query: "q", Rows: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := ids(result.Records); !reflect.DeepEqual(keys, []string{"r1", "r2", "r3"}) {
+		t.Errorf("records = %v, want the first three", keys)
+	}
+}
+
+func TestFederatedSearchFailures(t *testing.T) {
+	fast := &fakeBackend{name: "fast", records: []catalog.Record{{ID: "f1", Title: "Fast"}}}
+	slow := &fakeBackend{name: "slow", records: []catalog.Record{{ID: "s1", Title: "Slow"}}, delay: 5 * time.Second}
+	broken := &fakeBackend{name: "broken", err: errors.New("unexpected status 500")}
+	var cases = []struct {
+		backends []CatalogBackend
+		want     []string
+		err      []string
+	}{
+		{[]CatalogBackend{slow, fast}, []string{"f1"}, nil},
+		{[]CatalogBackend{broken, fast}, []string{"f1"}, nil},
+		{[]CatalogBackend{broken, slow}, nil, []string{"broken: unexpected status 500", "slow: context deadline exceeded"}},
+		{nil, nil, []string{"no catalog configured"}},
+	}
+	for _, c := range cases {
+		f := &FederatedCatalog{Backends: c.backends, Timeout: 50 * time.Millisecond}
+		started := time.Now()
+		result, err := f.Search(context.Background(), catalog.Query{Query: "q"})
+		if elapsed := time.Since(started); elapsed > time.Second {
+			t.Errorf("search took %v, want the timeout to cut off slow backends", elapsed)
+		}
+		if c.err != nil {
+			if err == nil {
+				t.Errorf("backends %v: got no error, want %q", c.backends, c.err)
+				continue
+			}
+			for _, s := range c.err {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("error = %v, want it to contain %q", err, s)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys, _ := ids(result.Records); !reflect.DeepEqual(keys, c.want) {
+			t.Errorf("records = %v, want %v", keys, c.want)
+		}
+	}
+}
+
+func TestFuseRanks(t *testing.T) {
+	var cases = []struct {
+		about  string
+		lists  [][]catalog.Record
+		rows   int
+		want   []string
+		merged int
+	}{
+		{
+			about: "a work returned twice by one list counts with its best rank only",
+			lists: [][]catalog.Record{{
+				{ID: "p", Title: "Primary"},
+				{ID: "q", Title: "Query languages", DOI: []string{"10.1000/q"}},
+				{ID: "q2", Title: "Query languages", DOI: []string{"10.1000/q"}},
+			}},
+			rows:   10,
+			want:   []string{"p", "q"},
+			merged: 1,
+		},
+		{
+			about: "agreement across lists beats a single first rank, the first found is kept",
+			lists: [][]catalog.Record{
+				{{ID: "x", Title: "Xylophones"}, {ID: "y", Title: "Yodeling", URL: []string{"https://example.org/y"}}},
+				{{ID: "z", Title: "Zithers"}, {ID: "y2", Title: "Yodelling", URL: []string{"https://example.org/y/"}}},
+				{{ID: "y3", Title: "Yodeling, a history", URL: []string{"http://example.org/y"}}},
+			},
+			rows:   10,
+			want:   []string{"y3", "x", "z"},
+			merged: 1,
+		},
+		{
+			about: "merges of records cut from the page are not described",
+			lists: [][]catalog.Record{{
+				{ID: "p", Title: "Primary"},
+				{ID: "q", Title: "Query languages", DOI: []string{"10.1000/q"}},
+				{ID: "q2", Title: "Query languages", DOI: []string{"10.1000/q"}},
+			}},
+			rows:   1,
+			want:   []string{"p"},
+			merged: 0,
+		},
+	}
+	for _, c := range cases {
+		records, merged := fuseRanks(c.lists, c.rows)
+		if keys, _ := ids(records); !reflect.DeepEqual(keys, c.want) {
+			t.Errorf("%s: records = %v, want %v", c.about, keys, c.want)
+		}
+		if len(merged) != c.merged {
+			t.Errorf("%s: merged = %q, want %d", c.about, merged, c.merged)
+		}
+	}
+}
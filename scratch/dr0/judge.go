@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/miku/unplugged/scratch/catalog"
+	"github.com/miku/unplugged/scratch/llm"
+)
+
+// Judgment is the relevance of a catalog record for a research question.
+type Judgment struct {
+	ID       string `json:"id"`
+	Relevant bool   `json:"relevant"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+var judgeSchema = map[string]any{
+	"type":     "object",
+	"required": []string{"judgments"},
+	"properties": map[string]any{
+		"judgments": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []string{"n", "relevant", "reason"},
+				"properties": map[string]any{
+					"n":        map[string]any{"type": "integer"},
+					"relevant": map[string]any{"type": "boolean"},
+					"reason":   map[string]any{"type": "string"},
+				},
+			},
+		},
+	},
+}
+
+// JudgeRelevance asks the model which records are relevant to a question,
+// and, if not empty, the more specific task the search was made for. The
+// records are numbered in the prompt, since models copy short numbers more
+// reliably than catalog IDs. Records without a judgment are not relevant.
+func JudgeRelevance(client *llm.Client, model, question, task string, records []catalog.Record) ([]Judgment, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "I am researching: %s\n", question)
+	if task != "" {
+		fmt.Fprintf(&sb, "Current task: %s\n", task)
+	}
+	sb.WriteString("\nI found the following entries in a library catalog:\n\n")
+	for i, r := range records {
+		b, _ := json.Marshal(struct {
+			Title  string   `json:"title"`
+			Author []string `json:"author,omitempty"`
+			Year   string   `json:"year,omitempty"`
+		}{r.Title, r.Author, r.Year})
+		fmt.Fprintf(&sb, "%d. %s\n", i+1, b)
+	}
+	sb.WriteString("\nFor each entry, judge whether it is relevant for the research, " +
+		"that is, whether it is likely to help answer the question. " +
+		"Words like the ones in the question are not enough, the topic must match. " +
+		"Give a short reason for each judgment.")
+	var out struct {
+		Judgments []struct {
+			N        int    `json:"n"`
+			Relevant bool   `json:"relevant"`
+			Reason   string `json:"reason"`
+		} `json:"judgments"`
+	}
+	messages := []llm.Message{
+		{Role: "system", Content: "You are a careful research librarian. You judge the relevance of catalog records."},
+		{Role: "user", Content: sb.String()},
+	}
+	if err := client.ChatJSON(model, messages, judgeSchema, &out); err != nil {
+		return nil, fmt.Errorf("judge relevance: %w", err)
+	}
+	judgments := make([]Judgment, len(records))
+	for i, r := range records {
+		judgments[i] = Judgment{ID: r.ID}
+	}
+	for _, j := range out.Judgments {
+		if j.N < 1 || j.N > len(records) {
+			continue
+		}
+		judgments[j.N-1].Relevant = j.Relevant
+		judgments[j.N-1].Reason = j.Reason
+	}
+	return judgments, nil
+}
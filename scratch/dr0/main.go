@@ -1,7 +1,210 @@
 package main
 
-import "log"
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/miku/unplugged/scratch/catalog"
+	"github.com/miku/unplugged/scratch/llm"
+)
+
+var (
+	question       = flag.String("q", "", "research question, starts a new session unless -dir has one")
+	sessionDir     = flag.String("dir", "", "session directory with plan.md, sources.jsonl and report.md (default: research/<question>)")
+	catalogURL     = flag.String("catalog-url", os.Getenv("CATALOG_URL"), "Solr select endpoint of the library catalog")
+	openAlexURL    = flag.String("openalex-url", os.Getenv("OPENALEX_URL"), "base URL of an OpenAlex style API to search as well, e.g. https://api.openalex.org")
+	crossrefURL    = flag.String("crossref-url", os.Getenv("CROSSREF_URL"), "base URL of a Crossref style API to search as well, e.g. https://api.crossref.org?mailto=you@example.org")
+	catalogTimeout = flag.Duration("catalog-timeout", 20*time.Second, "timeout per catalog in a search")
+	rows           = flag.Int("rows", 10, "records per catalog search")
+	maxItems       = flag.Int("max-items", 10, "maximum number of tasks in the plan")
+	maxSteps       = flag.Int("max-steps", 8, "maximum number of model turns per task")
+	ask            = flag.Bool("ask", false, "allow the model to ask clarifying questions on the terminal")
+	reportOnly     = flag.Bool("report", false, "only write the report from the current state of the session")
+	timeout        = flag.Duration("T", 5*time.Minute, "timeout for requests")
+	evalModels     = flag.String("models", "", "eval: comma separated models to compare (default: OLLAMA_MODEL)")
+	evalMethod     = flag.String("method", "titles", "eval: titles (free text prompt from the README) or judge (structured, as used by the agent)")
+)
+
+var nonWord = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug returns a directory name for a question.
+func slug(s string) string {
+	s = strings.Trim(nonWord.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(s) > 60 {
+		s = strings.TrimRight(s[:60], "-")
+	}
+	return s
+}
 
 func main() {
-	log.Println("dr0")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: dr0 [flags] -q QUESTION\n       dr0 [flags] -dir SESSION\n       dr0 [flags] -dir SESSION export [bibtex|csl-json|ris]\n       dr0 [flags] eval DATASET.jsonl...\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.Arg(0) == "eval" {
+		runEval(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "export" {
+		runExport(flag.Args()[1:])
+		return
+	}
+	if *question == "" && flag.NArg() > 0 {
+		*question = strings.Join(flag.Args(), " ")
+	}
+	dir := *sessionDir
+	if dir == "" {
+		if *question == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+		dir = filepath.Join("research", slug(*question))
+	}
+	ollamaHost, model := ollamaEnv()
+	log.Printf("using %s from %s", model, ollamaHost)
+	session, err := OpenSession(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := &Researcher{
+		Client:   llm.NewClient(ollamaHost, *timeout),
+		Model:    model,
+		Catalog:  catalogs(),
+		Session:  session,
+		Rows:     *rows,
+		MaxItems: *maxItems,
+		MaxSteps: *maxSteps,
+		Ask:      *ask,
+	}
+	switch {
+	case session.Plan == nil && *question == "":
+		log.Fatalf("no plan in %s, start a session with -q", dir)
+	case session.Plan == nil:
+		log.Printf("new session in %s", dir)
+		if err := r.CreatePlan(*question); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		if *question != "" && *question != session.Plan.Question {
+			log.Printf("%s already has a plan, continuing with: %s", dir, session.Plan.Question)
+		}
+		log.Printf("resuming session in %s, %d sources so far", dir, len(session.Sources))
+	}
+	log.Printf("plan:\n%s", session.Plan.Markdown())
+	var report string
+	if *reportOnly {
+		report, err = r.Report()
+	} else {
+		report, err = r.Run()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(report)
+}
+
+// catalogs returns the configured catalogs, searched together.
+func catalogs() *FederatedCatalog {
+	f := &FederatedCatalog{Timeout: *catalogTimeout}
+	if *catalogURL != "" {
+		f.Backends = append(f.Backends, catalog.NewSolr(*catalogURL))
+	}
+	if *openAlexURL != "" {
+		f.Backends = append(f.Backends, NewOpenAlex(*openAlexURL))
+	}
+	if *crossrefURL != "" {
+		f.Backends = append(f.Backends, NewCrossref(*crossrefURL))
+	}
+	return f
+}
+
+// ollamaEnv returns host and model from OLLAMA_HOST and OLLAMA_MODEL. The
+// host is parsed like in scratch/one, but dr0 talks to a single host, so
+// only the first of a list is used.
+func ollamaEnv() (host, model string) {
+	host = "http://localhost:11434"
+	if hosts := llm.SplitHosts(os.Getenv("OLLAMA_HOST")); len(hosts) > 0 {
+		host = hosts[0]
+		if len(hosts) > 1 {
+			log.Printf("OLLAMA_HOST lists %d hosts, using %s", len(hosts), host)
+		}
+	}
+	model = os.Getenv("OLLAMA_MODEL")
+	if model == "" {
+		model = "qwen3:14b"
+	}
+	return host, model
+}
+
+// runExport writes the sources of a session as a bibliography: a single
+// format to stdout, or all formats to files in the session directory.
+func runExport(formats []string) {
+	dir := *sessionDir
+	if dir == "" && *question != "" {
+		dir = filepath.Join("research", slug(*question))
+	}
+	if dir == "" {
+		log.Fatal("export needs a session, use -dir or -q")
+	}
+	session, err := OpenSession(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	records := session.Bibliography()
+	if len(formats) == 1 {
+		f, ok := bibFormats[formats[0]]
+		if !ok {
+			log.Fatalf("unknown format %q, use bibtex, csl-json or ris", formats[0])
+		}
+		if err := f.Write(os.Stdout, records); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(formats) == 0 {
+		formats = []string{"bibtex", "csl-json", "ris"}
+	}
+	for _, name := range formats {
+		f, ok := bibFormats[name]
+		if !ok {
+			log.Fatalf("unknown format %q, use bibtex, csl-json or ris", name)
+		}
+		var buf bytes.Buffer
+		if err := f.Write(&buf, records); err != nil {
+			log.Fatal(err)
+		}
+		filename := filepath.Join(dir, f.File)
+		if err := writeFileAtomic(filename, buf.Bytes()); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %d of %d sources to %s", len(records), len(session.Sources), filename)
+	}
+}
+
+// runEval compares the relevance judgments of models on eval datasets.
+func runEval(datasets []string) {
+	if len(datasets) == 0 {
+		datasets = []string{"testdata/relevance.jsonl"}
+	}
+	cases, err := LoadEvalCases(datasets...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	host, model := ollamaEnv()
+	models := []string{model}
+	if *evalModels != "" {
+		models = strings.Split(*evalModels, ",")
+	}
+	log.Printf("eval of %d cases with %s from %s, method %s", len(cases), strings.Join(models, ", "), host, *evalMethod)
+	if _, err := RunEval(llm.NewClient(host, *timeout), models, *evalMethod, cases, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
 }
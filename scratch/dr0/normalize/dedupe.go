@@ -0,0 +1,252 @@
+package normalize
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Merge reports records that were merged into one.
+type Merge struct {
+	Kept    string   // ID of the record kept
+	Merged  []string // IDs of the records merged into it
+	Title   string
+	Reasons []string // why the records are duplicates
+	Changes []string // what the kept record got from the others
+}
+
+func (m Merge) String() string {
+	s := fmt.Sprintf("merged %s into %s (%q): %s", strings.Join(m.Merged, ", "), m.Kept, m.Title,
+		strings.Join(m.Reasons, "; "))
+	if len(m.Changes) > 0 {
+		s += "; " + strings.Join(m.Changes, "; ")
+	}
+	return s
+}
+
+// Normalize returns the records with display titles, authors as "Last,
+// First", and DOIs and ISBNs in canonical form, including DOIs found in
+// URLs.
+func Normalize(records []Record) []Record {
+	result := make([]Record, len(records))
+	for i, r := range records {
+		n := Record{ID: r.ID, Title: Title(r.Title), Year: strings.TrimSpace(r.Year)}
+		for _, a := range r.Author {
+			if a = Author(a); a != "" {
+				n.Author = appendUnique(n.Author, a, strings.ToLower)
+			}
+		}
+		for _, u := range r.URL {
+			if u = strings.TrimSpace(u); u != "" {
+				n.URL = appendUnique(n.URL, u, URL)
+			}
+			if doi := DOI(u); doi != "" {
+				n.DOI = appendUnique(n.DOI, doi, nil)
+			}
+		}
+		for _, d := range r.DOI {
+			if doi := DOI(d); doi != "" {
+				n.DOI = appendUnique(n.DOI, doi, nil)
+			}
+		}
+		for _, s := range r.ISBN {
+			if isbn := ISBN(s); isbn != "" {
+				n.ISBN = appendUnique(n.ISBN, isbn, nil)
+			}
+		}
+		result[i] = n
+	}
+	return result
+}
+
+// appendUnique appends s unless a value with the same key is present. A nil
+// key compares values as they are.
+func appendUnique(values []string, s string, key func(string) string) []string {
+	if key == nil {
+		key = func(s string) string { return s }
+	}
+	for _, v := range values {
+		if key(v) == key(s) {
+			return values
+		}
+	}
+	return append(values, s)
+}
+
+// titleSimilarity is the fraction of equal characters of two title keys,
+// from their edit distance.
+func titleSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+// TitleSimilarity compares two titles by their keys, from 0 for different
+// to 1 for the same title.
+func TitleSimilarity(a, b string) float64 {
+	return titleSimilarity(TitleKey(a), TitleKey(b))
+}
+
+// minTitleSimilarity is the similarity above which titles of records with
+// the same authors and year are considered the same.
+const minTitleSimilarity = 0.9
+
+// duplicate returns why two normalized records describe the same work, or
+// the empty string if they do not.
+func duplicate(a, b Record) string {
+	for _, d := range a.DOI {
+		for _, e := range b.DOI {
+			if d == e {
+				return "same DOI " + d
+			}
+		}
+	}
+	for _, s := range a.ISBN {
+		for _, t := range b.ISBN {
+			if s == t {
+				return "same ISBN " + s
+			}
+		}
+	}
+	for _, u := range a.URL {
+		for _, v := range b.URL {
+			if URL(u) == URL(v) {
+				return "same URL " + URL(u)
+			}
+		}
+	}
+	ka, kb := TitleKey(a.Title), TitleKey(b.Title)
+	if ka == "" || kb == "" {
+		return ""
+	}
+	// Short titles like "Poems" only match exactly.
+	sim := titleSimilarity(ka, kb)
+	if ka != kb && (len(ka) < 20 || sim < minTitleSimilarity) {
+		return ""
+	}
+	if a.Year != "" && b.Year != "" && a.Year != b.Year {
+		return ""
+	}
+	if len(a.Author) > 0 && len(b.Author) > 0 && !sharesAuthor(a, b) {
+		return ""
+	}
+	if ka == kb {
+		return "same title and author"
+	}
+	return fmt.Sprintf("similar title (%.0f%%) and same author", sim*100)
+}
+
+func sharesAuthor(a, b Record) bool {
+	for _, x := range a.Author {
+		for _, y := range b.Author {
+			if AuthorKey(x) == AuthorKey(y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Dedupe normalizes records and merges the ones describing the same work,
+// found by DOI, ISBN or URL, or by a similar title with the same authors
+// and year. Each group is merged into its first record, which gets the
+// authors, URLs and identifiers of the others, and a year if it has none.
+// The order of the records is kept.
+func Dedupe(records []Record) ([]Record, []Merge) {
+	records = Normalize(records)
+	// Union find over the record indices, the root is the first record.
+	parent := make([]int, len(records))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	reasons := make(map[int][]string)
+	for i := range records {
+		for j := i + 1; j < len(records); j++ {
+			reason := duplicate(records[i], records[j])
+			if reason == "" {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			if ri != rj {
+				root, other := min(ri, rj), max(ri, rj)
+				parent[other] = root
+				reasons[root] = append(reasons[root], reasons[other]...)
+				delete(reasons, other)
+			}
+			root := find(i)
+			reasons[root] = appendUnique(reasons[root], reason, nil)
+		}
+	}
+	var (
+		result []Record
+		merges []Merge
+		index  = make(map[int]int) // root to position in result
+		merged = make(map[int]int) // root to position in merges
+	)
+	for i, r := range records {
+		root := find(i)
+		if root == i {
+			index[i] = len(result)
+			result = append(result, r)
+			continue
+		}
+		kept := &result[index[root]]
+		k, ok := merged[root]
+		if !ok {
+			k = len(merges)
+			merged[root] = k
+			merges = append(merges, Merge{Kept: kept.ID, Title: kept.Title, Reasons: reasons[root]})
+		}
+		m := &merges[k]
+		m.Merged = append(m.Merged, r.ID)
+		switch {
+		case kept.Year == "" && r.Year != "":
+			kept.Year = r.Year
+			m.Changes = append(m.Changes, fmt.Sprintf("year %s from %s", r.Year, r.ID))
+		case r.Year != "" && r.Year != kept.Year:
+			m.Changes = append(m.Changes, fmt.Sprintf("kept year %s, %s has %s", kept.Year, r.ID, r.Year))
+		}
+		n := len(kept.URL)
+		for _, u := range r.URL {
+			kept.URL = appendUnique(kept.URL, u, URL)
+		}
+		if added := len(kept.URL) - n; added > 0 {
+			m.Changes = append(m.Changes, fmt.Sprintf("%d URL(s) from %s", added, r.ID))
+		}
+		for _, a := range r.Author {
+			kept.Author = appendUnique(kept.Author, a, strings.ToLower)
+		}
+		for _, d := range r.DOI {
+			kept.DOI = appendUnique(kept.DOI, d, nil)
+		}
+		for _, s := range r.ISBN {
+			kept.ISBN = appendUnique(kept.ISBN, s, nil)
+		}
+	}
+	return result, merges
+}
@@ -0,0 +1,164 @@
+package normalize
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fullRecords reads the records of the saved Solr response in full.json.
+func fullRecords(t *testing.T) []Record {
+	t.Helper()
+	b, err := os.ReadFile("../full.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct {
+		Response struct {
+			Docs []struct {
+				ID              string   `json:"id"`
+				Title           string   `json:"title"`
+				Author          []string `json:"author"`
+				PublishDateSort string   `json:"publishDateSort"`
+				URL             []string `json:"url"`
+				DOI             []string `json:"doi_str_mv"`
+				ISBN            []string `json:"isbn"`
+			} `json:"docs"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	for _, d := range data.Response.Docs {
+		records = append(records, Record{ID: d.ID, Title: d.Title, Author: d.Author,
+			Year: d.PublishDateSort, URL: d.URL, DOI: d.DOI, ISBN: d.ISBN})
+	}
+	return records
+}
+
+func TestDedupeFull(t *testing.T) {
+	records := fullRecords(t)
+	var alvin []Record
+	for _, r := range records {
+		if strings.Contains(r.Title, "Alvin") {
+			alvin = append(alvin, r)
+		}
+	}
+	if len(alvin) != 2 {
+		t.Fatalf("got %d Alvin records in full.json, want 2", len(alvin))
+	}
+	result, merges := Dedupe(records)
+	if len(result) != len(records)-1 {
+		t.Errorf("got %d records, want %d", len(result), len(records)-1)
+	}
+	if len(merges) != 1 {
+		t.Fatalf("got merges %v, want one", merges)
+	}
+	m := merges[0]
+	if m.Kept != alvin[0].ID || !reflect.DeepEqual(m.Merged, []string{alvin[1].ID}) {
+		t.Errorf("merged %v into %s, want %s into %s", m.Merged, m.Kept, alvin[1].ID, alvin[0].ID)
+	}
+	if want := []string{"same DOI 10.5962/bhl.title.39120"}; !reflect.DeepEqual(m.Reasons, want) {
+		t.Errorf("reasons %q, want %q", m.Reasons, want)
+	}
+	var kept Record
+	for _, r := range result {
+		if r.ID == alvin[0].ID {
+			kept = r
+		}
+	}
+	// The first record has no year and gets the one of the second.
+	if kept.Year != "1969" || len(kept.URL) != 2 || !reflect.DeepEqual(kept.DOI, []string{"10.5962/bhl.title.39120"}) {
+		t.Errorf("kept record %+v", kept)
+	}
+	if want := "year 1969 from " + alvin[1].ID; len(m.Changes) == 0 || m.Changes[0] != want {
+		t.Errorf("changes %q, want %q first", m.Changes, want)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	var cases = []struct {
+		about   string
+		records []Record
+		kept    []string
+		reason  string
+	}{
+		{
+			"same ISBN in different forms",
+			[]Record{{ID: "a", Title: "Ocean", ISBN: []string{"3-16-148410-X"}}, {ID: "b", Title: "Oceans", ISBN: []string{"978-3-16-148410-0"}}},
+			[]string{"a"},
+			"same ISBN 9783161484100",
+		},
+		{
+			"same URL with another scheme",
+			[]Record{{ID: "a", Title: "X", URL: []string{"http://www.example.org/x/"}}, {ID: "b", Title: "Y", URL: []string{"https://example.org/x"}}},
+			[]string{"a"},
+			"same URL example.org/x",
+		},
+		{
+			"similar title, same author and year",
+			[]Record{
+				{ID: "a", Title: "Deep research agents: a survey", Author: []string{"Ali Shiri"}, Year: "2025"},
+				{ID: "b", Title: "Deep research agent: a survey /", Author: []string{"Shiri, Ali"}, Year: "2025"},
+			},
+			[]string{"a"},
+			"similar title (97%) and same author",
+		},
+		{
+			"similar title, other year",
+			[]Record{
+				{ID: "a", Title: "Deep research agents: a survey", Author: []string{"Ali Shiri"}, Year: "2024"},
+				{ID: "b", Title: "Deep research agents: a survey", Author: []string{"Ali Shiri"}, Year: "2025"},
+			},
+			[]string{"a", "b"},
+			"",
+		},
+		{
+			"same title, other author",
+			[]Record{
+				{ID: "a", Title: "Deep research agents: a survey", Author: []string{"Ali Shiri"}},
+				{ID: "b", Title: "Deep research agents: a survey", Author: []string{"Jane Doe"}},
+			},
+			[]string{"a", "b"},
+			"",
+		},
+		{
+			"short titles only match exactly",
+			[]Record{{ID: "a", Title: "Poems"}, {ID: "b", Title: "Poem"}, {ID: "c", Title: "The Poems"}},
+			[]string{"a", "b"},
+			"same title and author",
+		},
+		{
+			"duplicates are merged transitively into the first",
+			[]Record{
+				{ID: "a", Title: "A", DOI: []string{"10.1000/a"}},
+				{ID: "b", Title: "B", DOI: []string{"doi:10.1000/A"}, URL: []string{"https://example.org/b"}},
+				{ID: "c", Title: "C", URL: []string{"https://example.org/b/"}},
+			},
+			[]string{"a"},
+			"same DOI 10.1000/a",
+		},
+	}
+	for _, c := range cases {
+		result, merges := Dedupe(c.records)
+		var kept []string
+		for _, r := range result {
+			kept = append(kept, r.ID)
+		}
+		if !reflect.DeepEqual(kept, c.kept) {
+			t.Errorf("%s: kept %v, want %v", c.about, kept, c.kept)
+		}
+		if c.reason == "" {
+			if len(merges) > 0 {
+				t.Errorf("%s: got merges %v, want none", c.about, merges)
+			}
+			continue
+		}
+		if len(merges) != 1 || len(merges[0].Reasons) == 0 || merges[0].Reasons[0] != c.reason {
+			t.Errorf("%s: got merges %v, want one for %q", c.about, merges, c.reason)
+		}
+	}
+}
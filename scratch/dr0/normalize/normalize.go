@@ -0,0 +1,178 @@
+// Package normalize cleans up catalog records before they are shown to a
+// model: titles and author names are brought into one form, and records
+// describing the same work are merged.
+package normalize
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Record is a catalog record, as far as normalization is concerned.
+type Record struct {
+	ID     string
+	Title  string
+	Author []string
+	Year   string
+	URL    []string
+	DOI    []string
+	ISBN   []string
+}
+
+// quoteReplacer folds typographic quotes and dashes to ASCII.
+var quoteReplacer = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "‛", "'",
+	"“", `"`, "”", `"`, "„", `"`, "«", `"`, "»", `"`,
+	"–", "-", "—", "-", "‐", "-",
+)
+
+// Title returns a title for display: whitespace collapsed, typographic
+// quotes folded and trailing cataloging punctuation like " /" removed.
+func Title(s string) string {
+	s = strings.Join(strings.Fields(quoteReplacer.Replace(s)), " ")
+	return strings.TrimRight(s, " /:;,=")
+}
+
+// TitleKey returns the form used to compare titles: lower case letters and
+// digits only, separated by single spaces, with leading articles removed.
+func TitleKey(s string) string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(quoteReplacer.Replace(s)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		w = strings.ReplaceAll(w, "'", "")
+		if w != "" {
+			words = append(words, w)
+		}
+	}
+	if len(words) > 1 {
+		switch words[0] {
+		case "a", "an", "the", "der", "die", "das", "le", "la", "les":
+			words = words[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// corporateWords mark names of organizations, which are not inverted.
+var corporateWords = map[string]bool{
+	"association": true, "bureau": true, "command": true, "committee": true, "company": true,
+	"council": true, "department": true, "foundation": true, "institute": true, "library": true,
+	"ministry": true, "office": true, "organization": true, "society": true, "states": true,
+	"university": true, "verein": true, "gesellschaft": true, "institut": true, "universität": true,
+}
+
+var nameParticles = map[string]bool{"van": true, "von": true, "de": true, "der": true, "da": true, "di": true, "du": true, "la": true, "le": true}
+
+// Author returns a personal name as "Last, First", e.g. "E. Shaghasemi" as
+// "Shaghasemi, E.". Names with a comma, single words and names of
+// organizations are only cleaned up.
+func Author(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.TrimRight(s, ",;")
+	if s == "" || strings.Contains(s, ",") {
+		return s
+	}
+	words := strings.Fields(s)
+	if len(words) < 2 || len(words) > 4 {
+		return s
+	}
+	for _, w := range words {
+		if corporateWords[strings.ToLower(strings.Trim(w, "."))] {
+			return s
+		}
+	}
+	// The last name starts with particles like "van" or "de".
+	i := len(words) - 1
+	for i > 1 && nameParticles[strings.ToLower(words[i-1])] {
+		i--
+	}
+	return strings.Join(words[i:], " ") + ", " + strings.Join(words[:i], " ")
+}
+
+// AuthorKey returns the lower case last name of a normalized author, used
+// to compare authors across records.
+func AuthorKey(s string) string {
+	last, _, _ := strings.Cut(Author(s), ",")
+	return strings.ToLower(strings.TrimSpace(last))
+}
+
+var doiPattern = regexp.MustCompile(`10\.\d{4,9}/\S+`)
+
+// DOI extracts a DOI from a string like "https://doi.org/10.1038/x" or
+// "doi:10.1038/x", in lower case, since DOIs are case insensitive. It
+// returns the empty string if there is none.
+func DOI(s string) string {
+	doi := doiPattern.FindString(s)
+	return strings.ToLower(strings.TrimRight(doi, ".,;)"))
+}
+
+// URL returns a URL in a form for comparison: without scheme, "www." and
+// trailing slash, and DOI resolvers mapped to doi.org.
+func URL(s string) string {
+	s = strings.TrimSpace(s)
+	if doi := DOI(s); doi != "" && strings.Contains(s, "doi.org/") {
+		return "doi.org/" + doi
+	}
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+	s = strings.TrimPrefix(s, "www.")
+	return strings.TrimRight(s, "/")
+}
+
+var isbnPrefix = regexp.MustCompile(`(?i)^\s*isbn(-1[03])?:?`)
+
+// ISBN returns an ISBN as 13 digits, converting ISBN-10, or the empty
+// string if s is not a valid ISBN.
+func ISBN(s string) string {
+	s = isbnPrefix.ReplaceAllString(s, "")
+	var digits []byte
+scan:
+	for _, r := range strings.ToUpper(s) {
+		switch {
+		case r >= '0' && r <= '9', r == 'X' && len(digits) == 9:
+			digits = append(digits, byte(r))
+		case r == '-' || r == ' ':
+		default:
+			if len(digits) == 10 || len(digits) == 13 {
+				// Trailing text, e.g. "3-16-148410-X (pbk.)".
+				break scan
+			}
+			return ""
+		}
+	}
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i, d := range digits {
+			v := int(d - '0')
+			if d == 'X' {
+				v = 10
+			}
+			sum += (10 - i) * v
+		}
+		if sum%11 != 0 {
+			return ""
+		}
+		isbn := append([]byte("978"), digits[:9]...)
+		return string(append(isbn, isbn13Check(isbn)))
+	case 13:
+		if strings.ContainsRune(string(digits), 'X') || isbn13Check(digits[:12]) != digits[12] {
+			return ""
+		}
+		return string(digits)
+	}
+	return ""
+}
+
+func isbn13Check(first12 []byte) byte {
+	sum := 0
+	for i, d := range first12 {
+		w := 1
+		if i%2 == 1 {
+			w = 3
+		}
+		sum += w * int(d-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
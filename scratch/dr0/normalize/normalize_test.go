@@ -0,0 +1,135 @@
+package normalize
+
+import "testing"
+
+func TestTitle(t *testing.T) {
+	var cases = []struct {
+		in, want string
+	}{
+		{"Recovery of deep research vehicle Alvin /", "Recovery of deep research vehicle Alvin"},
+		{"  Deep   research:", "Deep research"},
+		{"„Tiefsee“ – Forschung ;", `"Tiefsee" - Forschung`},
+	}
+	for _, c := range cases {
+		if got := Title(c.in); got != c.want {
+			t.Errorf("Title(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestTitleKey(t *testing.T) {
+	var cases = []struct {
+		in, want string
+	}{
+		{"Recovery of deep research vehicle Alvin /", "recovery of deep research vehicle alvin"},
+		{"The Deep Research Agent", "deep research agent"},
+		{"Die Tiefsee: eine Einführung", "tiefsee eine einführung"},
+		{"The", "the"},
+		{"Ocean’s Edge — 2nd ed.", "oceans edge 2nd ed"},
+		{"  ", ""},
+	}
+	for _, c := range cases {
+		if got := TitleKey(c.in); got != c.want {
+			t.Errorf("TitleKey(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	var cases = []struct {
+		a, b     string
+		min, max float64
+	}{
+		{"Recovery of deep research vehicle Alvin", "The recovery of deep research vehicle Alvin /", 1, 1},
+		{"Deep research agents: a survey", "Deep research agent: a survey", 0.95, 0.99},
+		{"Deep research agents", "Shallow water fishing", 0, 0.5},
+		{"", "", 1, 1},
+	}
+	for _, c := range cases {
+		got := TitleSimilarity(c.a, c.b)
+		if got < c.min || got > c.max {
+			t.Errorf("TitleSimilarity(%q, %q) = %.2f, want between %.2f and %.2f", c.a, c.b, got, c.min, c.max)
+		}
+		if back := TitleSimilarity(c.b, c.a); back != got {
+			t.Errorf("TitleSimilarity is not symmetric for %q and %q: %.2f and %.2f", c.a, c.b, got, back)
+		}
+	}
+}
+
+func TestAuthor(t *testing.T) {
+	var cases = []struct {
+		in, want, key string
+	}{
+		{"Ali Shiri", "Shiri, Ali", "shiri"},
+		{"Shiri, Ali", "Shiri, Ali", "shiri"},
+		{"E. Shaghasemi", "Shaghasemi, E.", "shaghasemi"},
+		{"Ludwig van Beethoven", "van Beethoven, Ludwig", "van beethoven"},
+		{"Beethoven, Ludwig van,", "Beethoven, Ludwig van", "beethoven"},
+		{"United States. Naval Ship Systems Command.", "United States. Naval Ship Systems Command.", "united states. naval ship systems command."},
+		{"MBLWHOI Library", "MBLWHOI Library", "mblwhoi library"},
+		{"Plato", "Plato", "plato"},
+	}
+	for _, c := range cases {
+		if got := Author(c.in); got != c.want {
+			t.Errorf("Author(%q) = %q, want %q", c.in, got, c.want)
+		}
+		if got := AuthorKey(c.in); got != c.key {
+			t.Errorf("AuthorKey(%q) = %q, want %q", c.in, got, c.key)
+		}
+	}
+	if AuthorKey("Ali Shiri") != AuthorKey("Shiri, A.") {
+		t.Errorf("AuthorKey differs for First Last and Last, First")
+	}
+}
+
+func TestDOI(t *testing.T) {
+	var cases = []struct {
+		in, want string
+	}{
+		{"https://doi.org/10.5962/bhl.title.39120", "10.5962/bhl.title.39120"},
+		{"http://dx.doi.org/10.1038/NATURE12373", "10.1038/nature12373"},
+		{"doi:10.1000/xyz123.", "10.1000/xyz123"},
+		{"10.1000/a_b", "10.1000/a_b"},
+		{"(see 10.1000/abc)", "10.1000/abc"},
+		{"https://example.org/paper", ""},
+		{"10.12/short", ""},
+	}
+	for _, c := range cases {
+		if got := DOI(c.in); got != c.want {
+			t.Errorf("DOI(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	var cases = []struct {
+		in, want string
+	}{
+		{"https://www.biodiversitylibrary.org/item/86720/", "biodiversitylibrary.org/item/86720"},
+		{"http://example.org", "example.org"},
+		{"https://dx.doi.org/10.5962/BHL.title.39120", "doi.org/10.5962/bhl.title.39120"},
+	}
+	for _, c := range cases {
+		if got := URL(c.in); got != c.want {
+			t.Errorf("URL(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestISBN(t *testing.T) {
+	var cases = []struct {
+		in, want string
+	}{
+		{"978-3-16-148410-0", "9783161484100"},
+		{"ISBN 3-16-148410-X", "9783161484100"},
+		{"ISBN-10: 0-306-40615-2 (pbk.)", "9780306406157"},
+		{"978-3-16-148410-1", ""},
+		{"0-306-40615-3", ""},
+		{"12345", ""},
+	}
+	for _, c := range cases {
+		if got := ISBN(c.in); got != c.want {
+			t.Errorf("ISBN(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
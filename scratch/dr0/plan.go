@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/miku/unplugged/scratch/catalog"
+)
+
+// Plan is the research plan, a todo list kept as markdown, so it can be read
+// and edited by hand between runs:
+//
+//	# What are deep research tools used for in science?
+//
+//	- [x] Find overviews of deep research tools
+//	  > Two recent articles discuss ...
+//	  - query: title:"deep research", page 1 (36 hits, 3 relevant)
+//	- [ ] Find evaluations in biomedicine
+type Plan struct {
+	Question string
+	Items    []*PlanItem
+}
+
+type PlanItem struct {
+	Text    string
+	Done    bool
+	Summary string
+	Queries []string // queries run for this item, with hit counts
+}
+
+// Next returns the first open item, or nil if all are done.
+func (p *Plan) Next() *PlanItem {
+	for _, item := range p.Items {
+		if !item.Done {
+			return item
+		}
+	}
+	return nil
+}
+
+// Add appends an open item, unless an item with the same text exists.
+func (p *Plan) Add(text string) bool {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return false
+	}
+	for _, item := range p.Items {
+		if strings.EqualFold(item.Text, text) {
+			return false
+		}
+	}
+	p.Items = append(p.Items, &PlanItem{Text: text})
+	return true
+}
+
+func (p *Plan) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n\n", p.Question)
+	for _, item := range p.Items {
+		check := " "
+		if item.Done {
+			check = "x"
+		}
+		fmt.Fprintf(&sb, "- [%s] %s\n", check, item.Text)
+		for _, line := range strings.Split(strings.TrimSpace(item.Summary), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				fmt.Fprintf(&sb, "  > %s\n", line)
+			}
+		}
+		for _, q := range item.Queries {
+			fmt.Fprintf(&sb, "  - query: %s\n", q)
+		}
+	}
+	return sb.String()
+}
+
+// ParsePlan reads a plan written by Markdown. Lines it does not know are
+// ignored.
+func ParsePlan(s string) (*Plan, error) {
+	p := &Plan{}
+	var item *PlanItem
+	for _, line := range strings.Split(s, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "# ") && p.Question == "":
+			p.Question = strings.TrimSpace(line[2:])
+		case strings.HasPrefix(line, "- [ ] "), strings.HasPrefix(line, "- [x] "), strings.HasPrefix(line, "- [X] "):
+			item = &PlanItem{Text: strings.TrimSpace(line[6:]), Done: line[3] != ' '}
+			p.Items = append(p.Items, item)
+		case item != nil && strings.HasPrefix(trimmed, ">"):
+			if item.Summary != "" {
+				item.Summary += "\n"
+			}
+			item.Summary += strings.TrimSpace(trimmed[1:])
+		case item != nil && strings.HasPrefix(trimmed, "- query: "):
+			item.Queries = append(item.Queries, strings.TrimPrefix(trimmed, "- query: "))
+		}
+	}
+	if p.Question == "" {
+		return nil, fmt.Errorf("plan has no question, expected a '# ' heading")
+	}
+	return p, nil
+}
+
+// Source is a catalog record judged relevant for a plan item.
+type Source struct {
+	catalog.Record
+	Item   string `json:"item"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Session is the state of a research run in a directory: plan.md,
+// sources.jsonl with one relevant record per line and, at the end,
+// report.md.
+type Session struct {
+	Dir     string
+	Plan    *Plan
+	Sources []Source
+}
+
+// OpenSession loads the session in dir. Plan is nil for a new session.
+func OpenSession(dir string) (*Session, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Session{Dir: dir}
+	b, err := os.ReadFile(filepath.Join(dir, "plan.md"))
+	switch {
+	case os.IsNotExist(err):
+		return s, nil
+	case err != nil:
+		return nil, err
+	}
+	if s.Plan, err = ParsePlan(string(b)); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, "sources.jsonl"))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var src Source
+		if err := json.Unmarshal(scanner.Bytes(), &src); err != nil {
+			// A line cut off by an interrupted run.
+			continue
+		}
+		s.Sources = append(s.Sources, src)
+	}
+	return s, scanner.Err()
+}
+
+// SavePlan writes plan.md, replacing the file only once it is written
+// completely.
+func (s *Session) SavePlan() error {
+	return writeFileAtomic(filepath.Join(s.Dir, "plan.md"), []byte(s.Plan.Markdown()))
+}
+
+// recordKey identifies a record by its ID, or its first URL or title for
+// catalogs that return no IDs.
+func recordKey(r catalog.Record) string {
+	switch {
+	case r.ID != "":
+		return r.ID
+	case len(r.URL) > 0:
+		return r.URL[0]
+	}
+	return r.Title
+}
+
+// AddSource appends a source, unless the record is already known. It
+// reports whether the source was added.
+func (s *Session) AddSource(src Source) (bool, error) {
+	for _, known := range s.Sources {
+		if recordKey(known.Record) == recordKey(src.Record) {
+			return false, nil
+		}
+	}
+	b, err := json.Marshal(src)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(filepath.Join(s.Dir, "sources.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return false, err
+	}
+	s.Sources = append(s.Sources, src)
+	return true, nil
+}
+
+// SourceNumber returns the citation number of a record, starting at 1, or
+// 0 if it is not a source.
+func (s *Session) SourceNumber(r catalog.Record) int {
+	for i, src := range s.Sources {
+		if recordKey(src.Record) == recordKey(r) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miku/unplugged/scratch/catalog"
+	"github.com/miku/unplugged/scratch/dr0/normalize"
+	"github.com/miku/unplugged/scratch/llm"
+)
+
+// Researcher runs the research loop: create a plan, work on the first open
+// item with catalog searches until the model finishes it, repeat, and
+// finally write a report. The session is saved after every step, so an
+// interrupted run continues where it stopped.
+type Researcher struct {
+	Client   *llm.Client
+	Model    string
+	Catalog  CatalogBackend
+	Session  *Session
+	Rows     int  // records per search
+	MaxItems int  // limit of plan items, including added ones
+	MaxSteps int  // model turns per item
+	Ask      bool // allow questions to the user
+
+	seen map[string]catalog.Record // search results of this run, by record key
+}
+
+// lookup returns a record by its key from the search results of this run
+// or from the sources, which survive a resume.
+func (r *Researcher) lookup(id string) (catalog.Record, bool) {
+	if rec, ok := r.seen[id]; ok {
+		return rec, true
+	}
+	for _, src := range r.Session.Sources {
+		if recordKey(src.Record) == id {
+			return src.Record, true
+		}
+	}
+	return catalog.Record{}, false
+}
+
+var planSchema = map[string]any{
+	"type":     "object",
+	"required": []string{"items"},
+	"properties": map[string]any{
+		"items": map[string]any{
+			"type":  "array",
+			"items": map[string]any{"type": "string"},
+		},
+	},
+}
+
+// CreatePlan asks the model for the initial todo list.
+func (r *Researcher) CreatePlan(question string) error {
+	prompt := fmt.Sprintf("Create a research plan for the following question, as a todo list of 3 to %d "+
+		"concrete tasks. Each task should be answerable by searching a library catalog, e.g. "+
+		"'Find overviews of ...', 'Find studies about ... in medicine', 'Find works by ...'.\n\nQuestion: %s",
+		min(r.MaxItems, 7), question)
+	var out struct {
+		Items []string `json:"items"`
+	}
+	messages := []llm.Message{
+		{Role: "system", Content: "You are a research librarian planning a literature search."},
+		{Role: "user", Content: prompt},
+	}
+	if err := r.Client.ChatJSON(r.Model, messages, planSchema, &out); err != nil {
+		return fmt.Errorf("create plan: %w", err)
+	}
+	plan := &Plan{Question: question}
+	for _, item := range out.Items {
+		if len(plan.Items) < r.MaxItems {
+			plan.Add(item)
+		}
+	}
+	if len(plan.Items) == 0 {
+		return fmt.Errorf("create plan: model returned no tasks")
+	}
+	r.Session.Plan = plan
+	return r.Session.SavePlan()
+}
+
+// Run works on open items until all are done, then writes the report.
+func (r *Researcher) Run() (string, error) {
+	for {
+		item := r.Session.Plan.Next()
+		if item == nil {
+			break
+		}
+		log.Printf("working on: %s", item.Text)
+		if err := r.work(item); err != nil {
+			return "", err
+		}
+		if err := r.Session.SavePlan(); err != nil {
+			return "", err
+		}
+	}
+	return r.Report()
+}
+
+// errItemDone ends the loop of an item.
+var errItemDone = errors.New("item done")
+
+// tools returns the tools for working on an item.
+func (r *Researcher) tools(item *PlanItem) *llm.ToolRegistry {
+	plan := r.Session.Plan
+	registry := llm.NewToolRegistry()
+	registry.Register(
+		"search_library_catalog",
+		"Search the library catalog. Each result is judged for relevance; relevant records are cited automatically and kept as sources for the report.",
+		map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query":     map[string]any{"type": "string", "description": "free text, use double quotes for phrases"},
+				"title":     map[string]any{"type": "string", "description": "words or phrase in the title"},
+				"author":    map[string]any{"type": "string", "description": "author name, e.g. 'Jones, Nicola'"},
+				"isbn":      map[string]any{"type": "string"},
+				"issn":      map[string]any{"type": "string"},
+				"year_from": map[string]any{"type": "integer", "description": "earliest publication year"},
+				"year_to":   map[string]any{"type": "integer", "description": "latest publication year"},
+				"page":      map[string]any{"type": "integer", "description": "page of results, starting at 1"},
+			},
+		},
+		func(args map[string]any) (any, error) {
+			return r.search(item, args)
+		},
+	)
+	registry.Register(
+		"cite",
+		"Cite a search result that was judged not relevant but should be a source, e.g. an overview the task needs. "+
+			"Only results of searches since the run started can be cited; search again for records found before a resume",
+		map[string]any{
+			"type":     "object",
+			"required": []string{"id", "reason"},
+			"properties": map[string]any{
+				"id":     map[string]any{"type": "string", "description": "id of the record from the search results"},
+				"reason": map[string]any{"type": "string", "description": "why the record matters for the task"},
+			},
+		},
+		func(args map[string]any) (any, error) {
+			id, _ := args["id"].(string)
+			reason, _ := args["reason"].(string)
+			rec, ok := r.lookup(id)
+			if !ok {
+				return nil, fmt.Errorf("unknown record %q, cite a record from the search results by its id", id)
+			}
+			added, err := r.Session.AddSource(Source{Record: rec, Item: item.Text, Reason: reason})
+			if err != nil {
+				return nil, err
+			}
+			if !added {
+				return map[string]any{"result": "already cited", "source": r.Session.SourceNumber(rec)}, nil
+			}
+			log.Printf("cited: %s", rec.Title)
+			return map[string]any{"result": "cited", "source": r.Session.SourceNumber(rec)}, nil
+		},
+	)
+	registry.Register(
+		"add_todo",
+		"Add a follow-up task to the research plan, e.g. a promising author or a narrower topic found in the results",
+		map[string]any{
+			"type":     "object",
+			"required": []string{"task"},
+			"properties": map[string]any{
+				"task": map[string]any{"type": "string", "description": "the task, like the existing plan items"},
+			},
+		},
+		func(args map[string]any) (any, error) {
+			task, _ := args["task"].(string)
+			if len(plan.Items) >= r.MaxItems {
+				return nil, fmt.Errorf("the plan has the maximum of %d tasks, finish the current one instead", r.MaxItems)
+			}
+			if !plan.Add(task) {
+				return map[string]any{"result": "task already in the plan"}, nil
+			}
+			return map[string]any{"result": "added", "plan": plan.Markdown()}, r.Session.SavePlan()
+		},
+	)
+	if r.Ask {
+		registry.Register(
+			"ask_user",
+			"Ask the user for a clarification, only if the task cannot be done otherwise",
+			map[string]any{
+				"type":     "object",
+				"required": []string{"question"},
+				"properties": map[string]any{
+					"question": map[string]any{"type": "string"},
+				},
+			},
+			func(args map[string]any) (any, error) {
+				question, _ := args["question"].(string)
+				fmt.Fprintf(os.Stderr, "\n%s\n> ", question)
+				answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err != nil && answer == "" {
+					return map[string]any{"answer": "no answer, continue with your best judgment"}, nil
+				}
+				return map[string]any{"answer": strings.TrimSpace(answer)}, nil
+			},
+		)
+	}
+	registry.Register(
+		"finish_task",
+		"Mark the current task as done, with a summary of what was found and which sources matter most",
+		map[string]any{
+			"type":     "object",
+			"required": []string{"summary"},
+			"properties": map[string]any{
+				"summary": map[string]any{"type": "string", "description": "a few sentences, cite sources as [n]"},
+			},
+		},
+		func(args map[string]any) (any, error) {
+			summary, _ := args["summary"].(string)
+			item.Done, item.Summary = true, summary
+			return nil, errItemDone
+		},
+	)
+	return registry
+}
+
+// search runs a catalog search for an item, judges the results and keeps
+// the relevant ones.
+func (r *Researcher) search(item *PlanItem, args map[string]any) (any, error) {
+	str := func(key string) string {
+		s, _ := args[key].(string)
+		return s
+	}
+	num := func(key string) int {
+		f, _ := args[key].(float64)
+		return int(f)
+	}
+	result, err := r.Catalog.Search(context.Background(), catalog.Query{
+		Query:    str("query"),
+		Title:    str("title"),
+		Author:   str("author"),
+		ISBN:     str("isbn"),
+		ISSN:     str("issn"),
+		YearFrom: num("year_from"),
+		YearTo:   num("year_to"),
+		Page:     num("page"),
+		Rows:     r.Rows,
+	})
+	if err != nil {
+		return nil, err
+	}
+	judgments, err := JudgeRelevance(r.Client, r.Model, r.Session.Plan.Question, item.Text, result.Records)
+	if err != nil {
+		return nil, err
+	}
+	type hit struct {
+		ID       string   `json:"id"`
+		Source   int      `json:"source,omitempty"` // citation number, if relevant
+		Title    string   `json:"title"`
+		Author   []string `json:"author,omitempty"`
+		Year     string   `json:"year,omitempty"`
+		Relevant bool     `json:"relevant"`
+		Reason   string   `json:"reason,omitempty"`
+		Catalogs []string `json:"catalogs,omitempty"`
+	}
+	var hits []hit
+	relevant := 0
+	for i, rec := range result.Records {
+		j := judgments[i]
+		if r.seen == nil {
+			r.seen = make(map[string]catalog.Record)
+		}
+		r.seen[recordKey(rec)] = rec
+		if j.Relevant {
+			relevant++
+			if _, err := r.Session.AddSource(Source{Record: rec, Item: item.Text, Reason: j.Reason}); err != nil {
+				return nil, err
+			}
+		}
+		hits = append(hits, hit{
+			ID:       recordKey(rec),
+			Source:   r.Session.SourceNumber(rec),
+			Title:    rec.Title,
+			Author:   rec.Author,
+			Year:     rec.Year,
+			Relevant: j.Relevant,
+			Reason:   j.Reason,
+			Catalogs: rec.Catalogs,
+		})
+	}
+	item.Queries = append(item.Queries, fmt.Sprintf("%s, page %d (%d hits, %d relevant)",
+		result.Query, result.Page, result.Found, relevant))
+	if err := r.Session.SavePlan(); err != nil {
+		return nil, err
+	}
+	log.Printf("search %s: %d hits, %d of %d relevant", result.Query, result.Found, relevant, len(hits))
+	out := map[string]any{
+		"query": result.Query,
+		"found": result.Found,
+		"page":  result.Page,
+		"pages": result.Pages,
+		"hits":  hits,
+	}
+	if len(result.Merged) > 0 {
+		out["merged_duplicates"] = result.Merged
+	}
+	return out, nil
+}
+
+// describeMerge is a short description of a merge for the model.
+func describeMerge(m normalize.Merge) string {
+	return fmt.Sprintf("%d duplicate(s) of %q: %s", len(m.Merged), m.Title, strings.Join(m.Reasons, "; "))
+}
+
+// mergeDuplicates normalizes records and merges duplicates with
+// normalize.Dedupe. For each resulting record it also returns the indices
+// of the records merged into it, the kept one first, and the merge, which
+// is empty for records without duplicates; it keeps the catalogs of all of
+// them.
+func mergeDuplicates(records []catalog.Record) ([]catalog.Record, [][]int, []normalize.Merge) {
+	in := make([]normalize.Record, len(records))
+	for i, r := range records {
+		// Positions as IDs, since IDs may be empty or repeat across catalogs.
+		in[i] = normalize.Record{ID: strconv.Itoa(i), Title: r.Title, Author: r.Author, Year: r.Year, URL: r.URL, DOI: r.DOI, ISBN: r.ISBN}
+	}
+	out, merges := normalize.Dedupe(in)
+	index := func(id string) int {
+		i, _ := strconv.Atoi(id)
+		return i
+	}
+	groups := make(map[int][]int)           // kept to merged positions
+	byKept := make(map[int]normalize.Merge) // kept position to merge
+	for _, m := range merges {
+		kept := index(m.Kept)
+		m.Kept = recordKey(records[kept])
+		for j, id := range m.Merged {
+			groups[kept] = append(groups[kept], index(id))
+			m.Merged[j] = recordKey(records[index(id)])
+		}
+		byKept[kept] = m
+	}
+	result := make([]catalog.Record, len(out))
+	members := make([][]int, len(out))
+	merged := make([]normalize.Merge, len(out))
+	for i, r := range out {
+		kept := index(r.ID)
+		members[i] = append([]int{kept}, groups[kept]...)
+		merged[i] = byKept[kept]
+		rec := catalog.Record{ID: records[kept].ID, Title: r.Title, Author: r.Author, Year: r.Year, URL: r.URL, DOI: r.DOI, ISBN: r.ISBN}
+		for _, j := range members[i] {
+			for _, c := range records[j].Catalogs {
+				if !slices.Contains(rec.Catalogs, c) {
+					rec.Catalogs = append(rec.Catalogs, c)
+				}
+			}
+		}
+		result[i] = rec
+	}
+	return result, members, merged
+}
+
+// work runs the tool loop for one item until the model finishes it.
+func (r *Researcher) work(item *PlanItem) error {
+	registry := r.tools(item)
+	system := "You are a research assistant working through a research plan with a library catalog. " +
+		"Work on the current task only: search the catalog, try different queries if the results are poor, " +
+		"and call finish_task with a summary when the task is answered or the catalog has nothing more to offer. " +
+		"Cite relevant results by their source number as [n]."
+	messages := []llm.Message{
+		{Role: "system", Content: system},
+		{Role: "user", Content: fmt.Sprintf("Research plan:\n\n%s\nCurrent task: %s",
+			r.Session.Plan.Markdown(), item.Text)},
+	}
+	for step := 0; step < r.MaxSteps; step++ {
+		resp, err := r.Client.Chat(llm.ChatRequest{Model: r.Model, Messages: messages, Tools: registry.GetTools()})
+		if err != nil {
+			return fmt.Errorf("chat error: %w", err)
+		}
+		messages = append(messages, resp.Message)
+		if len(resp.Message.ToolCalls) == 0 {
+			// An answer without a tool call is taken as the summary.
+			item.Done, item.Summary = true, resp.Message.Content
+			return nil
+		}
+		for _, tc := range resp.Message.ToolCalls {
+			argsJSON, _ := json.Marshal(tc.Function.Arguments)
+			log.Printf("calling tool: %s %s", tc.Function.Name, argsJSON)
+			result, err := registry.Execute(tc.Function.Name, tc.Function.Arguments)
+			if errors.Is(err, errItemDone) {
+				return nil
+			}
+			if err != nil {
+				result = llm.ErrorResult(err)
+			}
+			messages = append(messages, llm.Message{Role: "tool", Content: result})
+		}
+	}
+	log.Printf("task not finished after %d steps, moving on", r.MaxSteps)
+	item.Done = true
+	item.Summary = fmt.Sprintf("(stopped after %d steps) %s", r.MaxSteps, messages[len(messages)-1].Content)
+	return nil
+}
+
+// Report writes report.md from the plan summaries and the sources. The
+// model writes the text; the list of sources is appended here, so citations
+// always point to real records.
+func (r *Researcher) Report() (string, error) {
+	s := r.Session
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Research question: %s\n\nResearch plan with findings:\n\n%s\nSources:\n\n", s.Plan.Question, s.Plan.Markdown())
+	for i, src := range s.Sources {
+		fmt.Fprintf(&sb, "[%d] %s\n", i+1, formatSource(src))
+	}
+	sb.WriteString("\nWrite a research report in markdown answering the question, based only on the findings and sources above. " +
+		"Cite sources as [n]. Start with a short answer, then the details. Do not add a list of sources, it is appended automatically.")
+	messages := []llm.Message{
+		{Role: "system", Content: "You are a research assistant writing a concise, well structured literature report."},
+		{Role: "user", Content: sb.String()},
+	}
+	resp, err := r.Client.Chat(llm.ChatRequest{Model: r.Model, Messages: messages})
+	if err != nil {
+		return "", fmt.Errorf("write report: %w", err)
+	}
+	var report strings.Builder
+	report.WriteString(strings.TrimSpace(resp.Message.Content))
+	report.WriteString("\n\n## Sources\n\n")
+	if len(s.Sources) == 0 {
+		report.WriteString("No relevant sources found.\n")
+	}
+	for i, src := range s.Sources {
+		fmt.Fprintf(&report, "%d. %s\n", i+1, formatSource(src))
+	}
+	if err := writeFileAtomic(filepath.Join(s.Dir, "report.md"), []byte(report.String())); err != nil {
+		return "", err
+	}
+	return report.String(), nil
+}
+
+// formatSource returns a source as "Author; Author (Year): Title. URL".
+func formatSource(src Source) string {
+	var sb strings.Builder
+	if len(src.Author) > 0 {
+		sb.WriteString(strings.Join(src.Author, "; "))
+		sb.WriteString(" ")
+	}
+	if src.Year != "" {
+		fmt.Fprintf(&sb, "(%s)", src.Year)
+	}
+	if sb.Len() > 0 {
+		sb.WriteString(": ")
+	}
+	sb.WriteString(src.Title)
+	if len(src.URL) > 0 {
+		if !strings.ContainsAny(src.Title[max(len(src.Title)-1, 0):], ".?!") {
+			sb.WriteString(".")
+		}
+		sb.WriteString(" ")
+		sb.WriteString(src.URL[0])
+	}
+	return sb.String()
+}
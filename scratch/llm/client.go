@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client talks to a single ollama host.
+type Client struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// SplitHosts parses a comma separated list of hosts, as given in OLLAMA_HOST.
+// Like the ollama CLI, a host without scheme gets http and the default port
+// 11434, so "chiba" is "http://chiba:11434".
+func SplitHosts(s string) []string {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		h = strings.TrimRight(strings.TrimSpace(h), "/")
+		if h == "" {
+			continue
+		}
+		if !strings.Contains(h, "://") {
+			hostport, path, _ := strings.Cut(h, "/")
+			host, port, err := net.SplitHostPort(hostport)
+			if err != nil {
+				host, port = strings.Trim(hostport, "[]"), "11434"
+			}
+			if host == "" {
+				host = "127.0.0.1"
+			}
+			h = "http://" + net.JoinHostPort(host, port)
+			if path != "" {
+				h += "/" + path
+			}
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// CheckStatus returns an error with the body of a response that is not
+// 200 OK.
+func CheckStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+}
+
+// DecodeChatResponse decodes a response of a chat request without
+// streaming.
+func DecodeChatResponse(r io.Reader) (*ChatResponse, error) {
+	var chatResp ChatResponse
+	if err := json.NewDecoder(r).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &chatResp, nil
+}
+
+// DecodeChatStream decodes a streamed response of a chat request, one JSON
+// object per line, into a single response: the message parts of all chunks
+// joined, with the counts and durations of the final chunk.
+func DecodeChatStream(r io.Reader) (*ChatResponse, error) {
+	var (
+		dec    = json.NewDecoder(r)
+		result ChatResponse
+	)
+	for {
+		var chunk struct {
+			ChatResponse
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&chunk); err == io.EOF {
+			return nil, fmt.Errorf("decode response: stream ended before done")
+		} else if err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("stream error: %s", chunk.Error)
+		}
+		msg := result.Message
+		msg.Role = cmp.Or(msg.Role, chunk.Message.Role)
+		msg.Content += chunk.Message.Content
+		msg.Thinking += chunk.Message.Thinking
+		msg.ToolCalls = append(msg.ToolCalls, chunk.Message.ToolCalls...)
+		if chunk.Done {
+			result = chunk.ChatResponse
+		}
+		result.Message = msg
+		if chunk.Done {
+			return &result, nil
+		}
+	}
+}
+
+func (c *Client) Chat(req ChatRequest) (*ChatResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	resp, err := c.client.Post(c.baseURL+"/api/chat", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("post request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := CheckStatus(resp); err != nil {
+		return nil, err
+	}
+	chatResp, err := DecodeChatResponse(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("chat: %d prompt tokens, %d generated, %v", chatResp.PromptEvalCount, chatResp.EvalCount,
+		chatResp.TotalDuration.Round(time.Millisecond))
+	return chatResp, nil
+}
+
+// ChatJSON asks for a response matching a JSON schema and decodes it into v.
+// A response that does not decode is retried once, with the error.
+func (c *Client) ChatJSON(model string, messages []Message, schema map[string]any, v any) error {
+	format, err := json.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("marshal schema: %w", err)
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := c.Chat(ChatRequest{Model: model, Messages: messages, Format: format})
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal([]byte(resp.Message.Content), v)
+		if err == nil {
+			return nil
+		}
+		if attempt > 0 {
+			return fmt.Errorf("decode structured response: %w", err)
+		}
+		messages = append(messages, resp.Message, Message{
+			Role:    "user",
+			Content: fmt.Sprintf("The response is not valid JSON (%v). Respond with JSON matching the schema only.", err),
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package llm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitHosts(t *testing.T) {
+	var cases = []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"http://localhost:11434", []string{"http://localhost:11434"}},
+		{"chiba", []string{"http://chiba:11434"}},
+		{"chiba:8080", []string{"http://chiba:8080"}},
+		{":11434", []string{"http://127.0.0.1:11434"}},
+		{"[::1]", []string{"http://[::1]:11434"}},
+		{"chiba/ollama/", []string{"http://chiba:11434/ollama"}},
+		{" https://a.example.org/ , b:1234,,", []string{"https://a.example.org", "http://b:1234"}},
+	}
+	for _, c := range cases {
+		if got := SplitHosts(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("SplitHosts(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
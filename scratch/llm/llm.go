@@ -0,0 +1,100 @@
+// Package llm has the ollama chat types, a tool registry and a minimal
+// client, shared by the programs in scratch. scratch/one builds its client
+// with multiple hosts, cassettes and metrics on the same types.
+package llm
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"`
+	Images    []string   `json:"images,omitempty"` // base64 encoded
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+type ToolCall struct {
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+	// RawArguments holds arguments that were not sent as a JSON object,
+	// until they are repaired.
+	RawArguments string `json:"-"`
+}
+
+// UnmarshalJSON accepts arguments that are not a JSON object, e.g. a string
+// containing JSON, and keeps them in RawArguments for repair.
+func (fc *FunctionCall) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*fc = FunctionCall{Name: v.Name}
+	if len(v.Arguments) == 0 || string(v.Arguments) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(v.Arguments, &fc.Arguments); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(v.Arguments, &fc.RawArguments); err != nil {
+		fc.RawArguments = string(v.Arguments)
+	}
+	return nil
+}
+
+// MarshalJSON writes unrepaired arguments as they were received, so
+// cassettes and transcripts keep the original call.
+func (fc FunctionCall) MarshalJSON() ([]byte, error) {
+	if fc.Arguments == nil && fc.RawArguments != "" {
+		return json.Marshal(struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		}{fc.Name, fc.RawArguments})
+	}
+	type plain FunctionCall
+	return json.Marshal(plain(fc))
+}
+
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+// ChatRequest, cf. https://github.com/ollama/ollama/blob/47e272c35a9d9b5780826a4965f3115908187a7b/openai/openai.go#L98-L117
+type ChatRequest struct {
+	Model           string          `json:"model"`
+	Messages        []Message       `json:"messages"`
+	Tools           []Tool          `json:"tools,omitempty"`
+	Format          json.RawMessage `json:"format,omitempty"` // "json" or a JSON schema
+	Stream          bool            `json:"stream"`
+	Options         map[string]any  `json:"options,omitempty"`
+	KeepAlive       string          `json:"keep_alive,omitempty"`
+	Think           any             `json:"think,omitempty"` // bool or "low", "medium", "high"
+	DebugRenderOnly bool            `json:"_debug_render_only"`
+}
+
+type ChatResponse struct {
+	Message            Message       `json:"message"`
+	Done               bool          `json:"done"`
+	TotalDuration      time.Duration `json:"total_duration,omitempty"`
+	LoadDuration       time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int           `json:"eval_count,omitempty"`
+	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+}
@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ToolHandler executes a tool call. The result is marshaled to JSON.
+type ToolHandler func(args map[string]any) (any, error)
+
+// ToolRegistry keeps the tools offered to the model and their handlers.
+type ToolRegistry struct {
+	definitions []Tool
+	handlers    map[string]ToolHandler
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		definitions: []Tool{},
+		handlers:    make(map[string]ToolHandler),
+	}
+}
+
+func (r *ToolRegistry) Register(name, description string, parameters map[string]any, handler ToolHandler) {
+	tool := Tool{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+	r.definitions = append(r.definitions, tool)
+	r.handlers[name] = handler
+}
+
+func (r *ToolRegistry) GetTools() []Tool {
+	return r.definitions
+}
+
+// Handler returns the handler of a tool.
+func (r *ToolRegistry) Handler(name string) (ToolHandler, bool) {
+	handler, ok := r.handlers[name]
+	return handler, ok
+}
+
+// Execute runs a tool and returns its result as JSON.
+func (r *ToolRegistry) Execute(name string, args map[string]any) (string, error) {
+	handler, ok := r.handlers[name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool: %s", name)
+	}
+	v, err := handler(args)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshal result: %w", err)
+	}
+	return string(b), nil
+}
+
+// ErrorResult is the tool message content for a failed call.
+func ErrorResult(err error) string {
+	b, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(b)
+}
@@ -0,0 +1,967 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The calculate tool evaluates arithmetic expressions with its own parser,
+// nothing is ever executed. Numbers are exact rationals as long as possible,
+// so 0.1 + 0.2 is 0.3 and 2^100 has all its digits; roots, logarithms and
+// trigonometry switch to floating point and the result is reported as not
+// exact.
+
+// Limits keep evaluation cheap whatever the model sends.
+const (
+	calcMaxLength = 1000 // characters of an expression
+	calcMaxDepth  = 100  // nesting of the parse tree
+	calcMaxBits   = 4096 // size of exact numbers, about 1200 digits
+	calcMaxFact   = 400
+)
+
+type calcToken struct {
+	kind string // "num", "ident", "op", "end"
+	text string
+	pos  int
+}
+
+// lexCalc splits an expression into tokens. Unicode operators are mapped to
+// their ASCII forms, ** to ^.
+func lexCalc(s string) ([]calcToken, error) {
+	var tokens []calcToken
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1]):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.' || rs[j] == '_') {
+				j++
+			}
+			// Exponent, only if digits follow; "2e" stays a number followed
+			// by an identifier and is rejected by the parser.
+			if j < len(rs) && (rs[j] == 'e' || rs[j] == 'E') {
+				k := j + 1
+				if k < len(rs) && (rs[k] == '+' || rs[k] == '-') {
+					k++
+				}
+				if k < len(rs) && unicode.IsDigit(rs[k]) {
+					for k < len(rs) && unicode.IsDigit(rs[k]) {
+						k++
+					}
+					j = k
+				}
+			}
+			tokens = append(tokens, calcToken{"num", strings.ReplaceAll(string(rs[i:j]), "_", ""), i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, calcToken{"ident", strings.ToLower(string(rs[i:j])), i})
+			i = j
+		case r == '*' && i+1 < len(rs) && rs[i+1] == '*':
+			tokens = append(tokens, calcToken{"op", "^", i})
+			i += 2
+		case strings.ContainsRune("+-*/^(),!%°", r):
+			tokens = append(tokens, calcToken{"op", string(r), i})
+			i++
+		case r == '×' || r == '·':
+			tokens = append(tokens, calcToken{"op", "*", i})
+			i++
+		case r == '÷':
+			tokens = append(tokens, calcToken{"op", "/", i})
+			i++
+		case r == '−':
+			tokens = append(tokens, calcToken{"op", "-", i})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+		}
+	}
+	return append(tokens, calcToken{"end", "", len(rs)}), nil
+}
+
+// calcNode is a node of the parse tree.
+type calcNode struct {
+	op   string // "num", "name", "call", "neg", "!", "%", "°" or a binary operator
+	text string // number literal or name
+	args []*calcNode
+}
+
+type calcParser struct {
+	tokens []calcToken
+	i      int
+	depth  int
+}
+
+func (p *calcParser) peek() calcToken { return p.tokens[p.i] }
+
+func (p *calcParser) next() calcToken {
+	t := p.tokens[p.i]
+	if t.kind != "end" {
+		p.i++
+	}
+	return t
+}
+
+func (p *calcParser) is(texts ...string) bool {
+	t := p.peek()
+	return (t.kind == "op" || t.kind == "ident") && slices.Contains(texts, t.text)
+}
+
+func (p *calcParser) errorf(format string, args ...any) error {
+	t := p.peek()
+	where := fmt.Sprintf("at position %d", t.pos)
+	if t.kind == "end" {
+		where = "at the end"
+	}
+	return fmt.Errorf(format+" "+where, args...)
+}
+
+// parseCalc parses an expression:
+//
+//	expr    = term {("+" | "-") term}
+//	term    = unary {("*" | "/" | "mod" | "of") unary}
+//	unary   = ("-" | "+") unary | power
+//	power   = postfix ["^" unary]
+//	postfix = primary {"!" | "%" | "°"}
+//	primary = number | name | name "(" [expr {"," expr}] ")" | "(" expr ")"
+func parseCalc(s string) (*calcNode, error) {
+	if len(s) > calcMaxLength {
+		return nil, fmt.Errorf("expression longer than %d characters", calcMaxLength)
+	}
+	tokens, err := lexCalc(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &calcParser{tokens: tokens}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != "end" {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+	return n, nil
+}
+
+func (p *calcParser) expr() (*calcNode, error) {
+	if p.depth++; p.depth > calcMaxDepth {
+		return nil, fmt.Errorf("expression nested too deeply")
+	}
+	defer func() { p.depth-- }()
+	x, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("+", "-") {
+		op := p.next().text
+		y, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		x = &calcNode{op: op, args: []*calcNode{x, y}}
+	}
+	return x, nil
+}
+
+func (p *calcParser) term() (*calcNode, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("*", "/", "mod", "of") {
+		op := p.next().text
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = &calcNode{op: op, args: []*calcNode{x, y}}
+	}
+	return x, nil
+}
+
+func (p *calcParser) unary() (*calcNode, error) {
+	if p.is("-", "+") {
+		op := p.next().text
+		x, err := p.unary()
+		if err != nil || op == "+" {
+			return x, err
+		}
+		return &calcNode{op: "neg", args: []*calcNode{x}}, nil
+	}
+	return p.power()
+}
+
+func (p *calcParser) power() (*calcNode, error) {
+	x, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	if p.is("^") {
+		p.next()
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &calcNode{op: "^", args: []*calcNode{x, y}}, nil
+	}
+	return x, nil
+}
+
+func (p *calcParser) postfix() (*calcNode, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("!", "%", "°") {
+		x = &calcNode{op: p.next().text, args: []*calcNode{x}}
+	}
+	return x, nil
+}
+
+func (p *calcParser) primary() (*calcNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == "num":
+		p.next()
+		return &calcNode{op: "num", text: t.text}, nil
+	case t.kind == "ident" && t.text != "mod" && t.text != "of":
+		p.next()
+		if !p.is("(") {
+			return &calcNode{op: "name", text: t.text}, nil
+		}
+		p.next()
+		call := &calcNode{op: "call", text: t.text}
+		for !p.is(")") {
+			if len(call.args) > 0 {
+				if !p.is(",") {
+					return nil, p.errorf("expected \",\" or \")\"")
+				}
+				p.next()
+			}
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		p.next()
+		return call, nil
+	case p.is("("):
+		p.next()
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, p.errorf("expected \")\"")
+		}
+		p.next()
+		return x, nil
+	case t.kind == "end":
+		return nil, p.errorf("incomplete expression")
+	}
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+func calcPrecedence(n *calcNode) int {
+	switch n.op {
+	case "+", "-":
+		return 1
+	case "*", "/", "mod", "of":
+		return 2
+	case "neg":
+		return 3
+	case "^":
+		return 4
+	case "!", "%", "°":
+		return 5
+	}
+	return 6
+}
+
+// String returns the expression in normalized form, with single spaces
+// around binary operators and only the necessary parentheses.
+func (n *calcNode) String() string {
+	wrap := func(child *calcNode, min int) string {
+		if calcPrecedence(child) < min {
+			return "(" + child.String() + ")"
+		}
+		return child.String()
+	}
+	prec := calcPrecedence(n)
+	switch n.op {
+	case "num", "name":
+		return n.text
+	case "call":
+		var args []string
+		for _, a := range n.args {
+			args = append(args, a.String())
+		}
+		return n.text + "(" + strings.Join(args, ", ") + ")"
+	case "neg":
+		return "-" + wrap(n.args[0], prec)
+	case "!", "%", "°":
+		return wrap(n.args[0], prec) + n.op
+	case "^":
+		return wrap(n.args[0], prec+1) + "^" + wrap(n.args[1], prec)
+	}
+	// Left associative: the right operand needs parentheses at equal
+	// precedence, as in 1 - (2 - 3).
+	return wrap(n.args[0], prec) + " " + n.op + " " + wrap(n.args[1], prec+1)
+}
+
+// calcValue is an exact rational or, if rat is nil, a float.
+type calcValue struct {
+	rat *big.Rat
+	f   float64
+}
+
+func exactValue(r *big.Rat) calcValue { return calcValue{rat: r} }
+
+func floatValue(f float64) calcValue { return calcValue{f: f} }
+
+func (v calcValue) float() float64 {
+	if v.rat != nil {
+		f, _ := v.rat.Float64()
+		return f
+	}
+	return v.f
+}
+
+func (v calcValue) isInt() bool {
+	if v.rat != nil {
+		return v.rat.IsInt()
+	}
+	return v.f == math.Trunc(v.f)
+}
+
+// checked rejects exact values that grew too large and floats that are not
+// finite.
+func checked(v calcValue) (calcValue, error) {
+	if v.rat != nil {
+		if v.rat.Num().BitLen()+v.rat.Denom().BitLen() > calcMaxBits {
+			f, _ := v.rat.Float64()
+			if math.IsInf(f, 0) {
+				return v, fmt.Errorf("result too large")
+			}
+			return floatValue(f), nil
+		}
+		return v, nil
+	}
+	if math.IsNaN(v.f) {
+		return v, fmt.Errorf("result is not a number")
+	}
+	if math.IsInf(v.f, 0) {
+		return v, fmt.Errorf("result too large")
+	}
+	return v, nil
+}
+
+// arith applies an operation exactly if both values are exact and in
+// floating point otherwise.
+func arith(x, y calcValue, exact func(a, b *big.Rat) *big.Rat, approx func(a, b float64) float64) (calcValue, error) {
+	if x.rat != nil && y.rat != nil {
+		return checked(exactValue(exact(x.rat, y.rat)))
+	}
+	return checked(floatValue(approx(x.float(), y.float())))
+}
+
+var (
+	ratZero    = new(big.Rat)
+	ratOne     = big.NewRat(1, 1)
+	ratHundred = big.NewRat(100, 1)
+)
+
+// pow raises x to y, exactly for integer exponents of exact values.
+func pow(x, y calcValue) (calcValue, error) {
+	if x.rat != nil && y.rat != nil && y.rat.IsInt() {
+		e := y.rat.Num()
+		if !e.IsInt64() || abs64(e.Int64())*int64(max(x.rat.Num().BitLen(), x.rat.Denom().BitLen())) > calcMaxBits {
+			return checked(floatValue(math.Pow(x.float(), y.float())))
+		}
+		n := e.Int64()
+		if x.rat.Sign() == 0 && n < 0 {
+			return calcValue{}, fmt.Errorf("division by zero")
+		}
+		num := new(big.Int).Exp(x.rat.Num(), big.NewInt(abs64(n)), nil)
+		den := new(big.Int).Exp(x.rat.Denom(), big.NewInt(abs64(n)), nil)
+		if n < 0 {
+			num, den = den, num
+		}
+		return checked(exactValue(new(big.Rat).SetFrac(num, den)))
+	}
+	return checked(floatValue(math.Pow(x.float(), y.float())))
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// exactRoot returns the n-th root of an exact value if it is rational.
+func exactRoot(v calcValue, n int64) (calcValue, bool) {
+	if v.rat == nil || v.rat.Sign() < 0 {
+		return v, false
+	}
+	root := func(x *big.Int) (*big.Int, bool) {
+		f, _ := new(big.Float).SetInt(x).Float64()
+		r := big.NewInt(int64(math.Round(math.Pow(f, 1/float64(n)))))
+		if n == 2 {
+			r = new(big.Int).Sqrt(x)
+		}
+		return r, new(big.Int).Exp(r, big.NewInt(n), nil).Cmp(x) == 0
+	}
+	num, ok1 := root(v.rat.Num())
+	den, ok2 := root(v.rat.Denom())
+	if !ok1 || !ok2 {
+		return v, false
+	}
+	return exactValue(new(big.Rat).SetFrac(num, den)), true
+}
+
+type calcFunc struct {
+	min, max int // number of arguments, max -1 for any
+	f        func(args []calcValue) (calcValue, error)
+}
+
+func float1(f func(float64) float64) calcFunc {
+	return calcFunc{1, 1, func(a []calcValue) (calcValue, error) { return checked(floatValue(f(a[0].float()))) }}
+}
+
+// sortedValues returns the values sorted, exactly if all are exact.
+func sortedValues(a []calcValue) []calcValue {
+	s := slices.Clone(a)
+	slices.SortFunc(s, func(x, y calcValue) int {
+		if x.rat != nil && y.rat != nil {
+			return x.rat.Cmp(y.rat)
+		}
+		return cmpFloat(x.float(), y.float())
+	})
+	return s
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func sumValues(a []calcValue) (calcValue, error) {
+	sum := exactValue(new(big.Rat))
+	for _, v := range a {
+		var err error
+		if sum, err = arith(sum, v, func(x, y *big.Rat) *big.Rat { return new(big.Rat).Add(x, y) },
+			func(x, y float64) float64 { return x + y }); err != nil {
+			return sum, err
+		}
+	}
+	return sum, nil
+}
+
+func meanValues(a []calcValue) (calcValue, error) {
+	sum, err := sumValues(a)
+	if err != nil {
+		return sum, err
+	}
+	return arith(sum, exactValue(big.NewRat(int64(len(a)), 1)),
+		func(x, y *big.Rat) *big.Rat { return new(big.Rat).Quo(x, y) },
+		func(x, y float64) float64 { return x / y })
+}
+
+// variance is the sample variance, or the population variance with
+// population set.
+func variance(a []calcValue, population bool) (calcValue, error) {
+	if len(a) < 2 && !population {
+		return calcValue{}, fmt.Errorf("sample variance needs at least two values")
+	}
+	mean, err := meanValues(a)
+	if err != nil {
+		return mean, err
+	}
+	var squares []calcValue
+	for _, v := range a {
+		d, err := arith(v, mean, func(x, y *big.Rat) *big.Rat { return new(big.Rat).Sub(x, y) },
+			func(x, y float64) float64 { return x - y })
+		if err != nil {
+			return d, err
+		}
+		sq, err := pow(d, exactValue(big.NewRat(2, 1)))
+		if err != nil {
+			return sq, err
+		}
+		squares = append(squares, sq)
+	}
+	sum, err := sumValues(squares)
+	if err != nil {
+		return sum, err
+	}
+	n := int64(len(a))
+	if !population {
+		n--
+	}
+	return arith(sum, exactValue(big.NewRat(n, 1)), func(x, y *big.Rat) *big.Rat { return new(big.Rat).Quo(x, y) },
+		func(x, y float64) float64 { return x / y })
+}
+
+func sqrtValue(v calcValue) (calcValue, error) {
+	if v.float() < 0 {
+		return v, fmt.Errorf("square root of a negative number")
+	}
+	if r, ok := exactRoot(v, 2); ok {
+		return r, nil
+	}
+	return checked(floatValue(math.Sqrt(v.float())))
+}
+
+// roundValue rounds half away from zero to the given number of decimals.
+func roundValue(v calcValue, decimals int) calcValue {
+	if v.rat == nil {
+		p := math.Pow(10, float64(decimals))
+		return floatValue(math.Round(v.f*p) / p)
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	x := new(big.Rat).Mul(v.rat, new(big.Rat).SetInt(scale))
+	half := big.NewRat(1, 2)
+	if x.Sign() < 0 {
+		half.Neg(half)
+	}
+	x.Add(x, half)
+	q := new(big.Int).Quo(x.Num(), x.Denom()) // truncates toward zero
+	return exactValue(new(big.Rat).SetFrac(q, scale))
+}
+
+func intArgs(a []calcValue) ([]*big.Int, error) {
+	var ints []*big.Int
+	for _, v := range a {
+		if v.rat == nil || !v.rat.IsInt() {
+			return nil, fmt.Errorf("arguments must be integers")
+		}
+		ints = append(ints, v.rat.Num())
+	}
+	return ints, nil
+}
+
+var calcFuncs = map[string]calcFunc{
+	"sqrt": {1, 1, func(a []calcValue) (calcValue, error) { return sqrtValue(a[0]) }},
+	"cbrt": {1, 1, func(a []calcValue) (calcValue, error) {
+		if r, ok := exactRoot(a[0], 3); ok {
+			return r, nil
+		}
+		return checked(floatValue(math.Cbrt(a[0].float())))
+	}},
+	"root": {2, 2, func(a []calcValue) (calcValue, error) {
+		if !a[1].isInt() || a[1].float() < 1 {
+			return calcValue{}, fmt.Errorf("root degree must be a positive integer")
+		}
+		if r, ok := exactRoot(a[0], int64(a[1].float())); ok {
+			return r, nil
+		}
+		return checked(floatValue(math.Pow(a[0].float(), 1/a[1].float())))
+	}},
+	"abs": {1, 1, func(a []calcValue) (calcValue, error) {
+		if a[0].rat != nil {
+			return exactValue(new(big.Rat).Abs(a[0].rat)), nil
+		}
+		return floatValue(math.Abs(a[0].f)), nil
+	}},
+	"floor": {1, 1, func(a []calcValue) (calcValue, error) {
+		if a[0].rat != nil {
+			q, _ := new(big.Int).DivMod(a[0].rat.Num(), a[0].rat.Denom(), new(big.Int))
+			return exactValue(new(big.Rat).SetInt(q)), nil
+		}
+		return floatValue(math.Floor(a[0].f)), nil
+	}},
+	"ceil": {1, 1, func(a []calcValue) (calcValue, error) {
+		if a[0].rat != nil {
+			neg := new(big.Rat).Neg(a[0].rat)
+			q, _ := new(big.Int).DivMod(neg.Num(), neg.Denom(), new(big.Int))
+			return exactValue(new(big.Rat).SetInt(q.Neg(q))), nil
+		}
+		return floatValue(math.Ceil(a[0].f)), nil
+	}},
+	"round": {1, 2, func(a []calcValue) (calcValue, error) {
+		decimals := 0
+		if len(a) == 2 {
+			if !a[1].isInt() || a[1].float() < 0 || a[1].float() > 100 {
+				return calcValue{}, fmt.Errorf("decimals must be an integer between 0 and 100")
+			}
+			decimals = int(a[1].float())
+		}
+		return roundValue(a[0], decimals), nil
+	}},
+	"sin":   float1(math.Sin),
+	"cos":   float1(math.Cos),
+	"tan":   float1(math.Tan),
+	"asin":  float1(math.Asin),
+	"acos":  float1(math.Acos),
+	"atan":  float1(math.Atan),
+	"sinh":  float1(math.Sinh),
+	"cosh":  float1(math.Cosh),
+	"tanh":  float1(math.Tanh),
+	"exp":   float1(math.Exp),
+	"ln":    float1(math.Log),
+	"log2":  float1(math.Log2),
+	"log10": float1(math.Log10),
+	"log": {1, 2, func(a []calcValue) (calcValue, error) {
+		if len(a) == 2 {
+			return checked(floatValue(math.Log(a[0].float()) / math.Log(a[1].float())))
+		}
+		return checked(floatValue(math.Log10(a[0].float())))
+	}},
+	"deg":  float1(func(x float64) float64 { return x * 180 / math.Pi }),
+	"rad":  float1(func(x float64) float64 { return x * math.Pi / 180 }),
+	"fact": {1, 1, func(a []calcValue) (calcValue, error) { return factorial(a[0]) }},
+	"min":  {1, -1, func(a []calcValue) (calcValue, error) { return sortedValues(a)[0], nil }},
+	"max":  {1, -1, func(a []calcValue) (calcValue, error) { return sortedValues(a)[len(a)-1], nil }},
+	"sum":  {1, -1, sumValues},
+	"mean": {1, -1, meanValues},
+	"avg":  {1, -1, meanValues},
+	"median": {1, -1, func(a []calcValue) (calcValue, error) {
+		s := sortedValues(a)
+		if len(s)%2 == 1 {
+			return s[len(s)/2], nil
+		}
+		return meanValues(s[len(s)/2-1 : len(s)/2+1])
+	}},
+	"var":  {1, -1, func(a []calcValue) (calcValue, error) { return variance(a, false) }},
+	"pvar": {1, -1, func(a []calcValue) (calcValue, error) { return variance(a, true) }},
+	"stdev": {1, -1, func(a []calcValue) (calcValue, error) {
+		v, err := variance(a, false)
+		if err != nil {
+			return v, err
+		}
+		return sqrtValue(v)
+	}},
+	"pstdev": {1, -1, func(a []calcValue) (calcValue, error) {
+		v, err := variance(a, true)
+		if err != nil {
+			return v, err
+		}
+		return sqrtValue(v)
+	}},
+	"gcd": {2, -1, func(a []calcValue) (calcValue, error) {
+		ints, err := intArgs(a)
+		if err != nil {
+			return calcValue{}, err
+		}
+		g := new(big.Int).Abs(ints[0])
+		for _, n := range ints[1:] {
+			g.GCD(nil, nil, g, new(big.Int).Abs(n))
+		}
+		return exactValue(new(big.Rat).SetInt(g)), nil
+	}},
+	"lcm": {2, -1, func(a []calcValue) (calcValue, error) {
+		ints, err := intArgs(a)
+		if err != nil {
+			return calcValue{}, err
+		}
+		l := new(big.Int).Abs(ints[0])
+		for _, n := range ints[1:] {
+			n = new(big.Int).Abs(n)
+			if l.Sign() == 0 || n.Sign() == 0 {
+				l.SetInt64(0)
+				continue
+			}
+			g := new(big.Int).GCD(nil, nil, l, n)
+			l.Mul(l, n).Quo(l, g)
+		}
+		return checked(exactValue(new(big.Rat).SetInt(l)))
+	}},
+}
+
+var calcConstants = map[string]float64{"pi": math.Pi, "e": math.E, "tau": 2 * math.Pi, "phi": math.Phi}
+
+func factorial(v calcValue) (calcValue, error) {
+	if !v.isInt() || v.float() < 0 {
+		return v, fmt.Errorf("factorial needs a non-negative integer")
+	}
+	if v.float() > calcMaxFact {
+		return v, fmt.Errorf("factorial larger than %d!", calcMaxFact)
+	}
+	f := new(big.Int).MulRange(1, int64(v.float()))
+	return checked(exactValue(new(big.Rat).SetInt(f)))
+}
+
+// eval evaluates a parse tree.
+func (n *calcNode) eval() (calcValue, error) {
+	switch n.op {
+	case "num":
+		r, ok := new(big.Rat).SetString(n.text)
+		if !ok {
+			return calcValue{}, fmt.Errorf("invalid number %q", n.text)
+		}
+		return checked(exactValue(r))
+	case "name":
+		if c, ok := calcConstants[n.text]; ok {
+			return floatValue(c), nil
+		}
+		return calcValue{}, fmt.Errorf("unknown name %q", n.text)
+	case "call":
+		fn, ok := calcFuncs[n.text]
+		if !ok {
+			return calcValue{}, fmt.Errorf("unknown function %q", n.text)
+		}
+		if len(n.args) < fn.min || fn.max >= 0 && len(n.args) > fn.max {
+			return calcValue{}, fmt.Errorf("wrong number of arguments for %s", n.text)
+		}
+		var args []calcValue
+		for _, a := range n.args {
+			v, err := a.eval()
+			if err != nil {
+				return v, err
+			}
+			args = append(args, v)
+		}
+		return fn.f(args)
+	}
+	var args []calcValue
+	for _, a := range n.args {
+		v, err := a.eval()
+		if err != nil {
+			return v, err
+		}
+		args = append(args, v)
+	}
+	switch n.op {
+	case "neg":
+		return arith(args[0], exactValue(ratZero), func(x, _ *big.Rat) *big.Rat { return new(big.Rat).Neg(x) },
+			func(x, _ float64) float64 { return -x })
+	case "!":
+		return factorial(args[0])
+	case "%":
+		return arith(args[0], exactValue(ratHundred), func(x, y *big.Rat) *big.Rat { return new(big.Rat).Quo(x, y) },
+			func(x, y float64) float64 { return x / y })
+	case "°":
+		return checked(floatValue(args[0].float() * math.Pi / 180))
+	case "+", "-":
+		y := args[1]
+		if n.args[1].op == "%" {
+			// 200 + 10% is 200 * (1 + 10%).
+			factor, err := arith(exactValue(ratOne), y, func(a, b *big.Rat) *big.Rat {
+				if n.op == "-" {
+					return new(big.Rat).Sub(a, b)
+				}
+				return new(big.Rat).Add(a, b)
+			}, func(a, b float64) float64 {
+				if n.op == "-" {
+					return a - b
+				}
+				return a + b
+			})
+			if err != nil {
+				return factor, err
+			}
+			return arith(args[0], factor, func(a, b *big.Rat) *big.Rat { return new(big.Rat).Mul(a, b) },
+				func(a, b float64) float64 { return a * b })
+		}
+		if n.op == "-" {
+			return arith(args[0], y, func(a, b *big.Rat) *big.Rat { return new(big.Rat).Sub(a, b) },
+				func(a, b float64) float64 { return a - b })
+		}
+		return arith(args[0], y, func(a, b *big.Rat) *big.Rat { return new(big.Rat).Add(a, b) },
+			func(a, b float64) float64 { return a + b })
+	case "*", "of":
+		if n.op == "of" && n.args[0].op != "%" {
+			return calcValue{}, fmt.Errorf("\"of\" needs a percentage on the left, e.g. 15%% of 200")
+		}
+		return arith(args[0], args[1], func(a, b *big.Rat) *big.Rat { return new(big.Rat).Mul(a, b) },
+			func(a, b float64) float64 { return a * b })
+	case "/", "mod":
+		if args[1].float() == 0 && (args[1].rat == nil || args[1].rat.Sign() == 0) {
+			return calcValue{}, fmt.Errorf("division by zero")
+		}
+		if n.op == "/" {
+			return arith(args[0], args[1], func(a, b *big.Rat) *big.Rat { return new(big.Rat).Quo(a, b) },
+				func(a, b float64) float64 { return a / b })
+		}
+		return arith(args[0], args[1], func(a, b *big.Rat) *big.Rat {
+			// a - b * floor(a / b), the sign follows the divisor.
+			q := new(big.Rat).Quo(a, b)
+			f, _ := new(big.Int).DivMod(q.Num(), q.Denom(), new(big.Int))
+			return new(big.Rat).Sub(a, new(big.Rat).Mul(b, new(big.Rat).SetInt(f)))
+		}, func(a, b float64) float64 { return a - b*math.Floor(a/b) })
+	case "^":
+		return pow(args[0], args[1])
+	}
+	return calcValue{}, fmt.Errorf("unknown operator %q", n.op)
+}
+
+// calcUnit converts to a base unit of its dimension: base = value * factor
+// + offset.
+type calcUnit struct {
+	name      string
+	dimension string
+	factor    *big.Rat
+	offset    *big.Rat
+}
+
+func ratOf(s string) *big.Rat {
+	r, _ := new(big.Rat).SetString(s)
+	return r
+}
+
+var calcUnits = map[string]calcUnit{}
+
+func init() {
+	add := func(dimension, name, factor, offset string, aliases ...string) {
+		u := calcUnit{name: name, dimension: dimension, factor: ratOf(factor), offset: ratOf(offset)}
+		for _, a := range append(aliases, strings.ToLower(name)) {
+			calcUnits[a] = u
+		}
+	}
+	// Temperatures in kelvin.
+	add("temperature", "°C", "1", "273.15", "c", "celsius", "degc")
+	add("temperature", "°F", "5/9", "45967/180", "f", "fahrenheit", "degf")
+	add("temperature", "K", "1", "0", "k", "kelvin")
+	// Speeds in m/s.
+	add("speed", "m/s", "1", "0", "mps")
+	add("speed", "km/h", "5/18", "0", "kmh", "kph")
+	add("speed", "mph", "1397/3125", "0")
+	add("speed", "kn", "463/900", "0", "knot", "knots", "kt")
+	add("speed", "ft/s", "0.3048", "0", "fps")
+	// Lengths in m.
+	add("length", "mm", "0.001", "0")
+	add("length", "cm", "0.01", "0")
+	add("length", "m", "1", "0", "meter", "meters", "metre", "metres")
+	add("length", "km", "1000", "0", "kilometer", "kilometers", "kilometre", "kilometres")
+	add("length", "inch", "0.0254", "0", "inches")
+	add("length", "ft", "0.3048", "0", "foot", "feet")
+	add("length", "yd", "0.9144", "0", "yard", "yards")
+	add("length", "mi", "1609.344", "0", "mile", "miles")
+	// Data sizes in bytes, decimal and binary prefixes.
+	add("data", "bit", "1/8", "0", "bits")
+	add("data", "B", "1", "0", "byte", "bytes")
+	for i, p := range []string{"k", "m", "g", "t", "p"} {
+		dec := new(big.Int).Exp(big.NewInt(1000), big.NewInt(int64(i+1)), nil).String()
+		bin := new(big.Int).Exp(big.NewInt(1024), big.NewInt(int64(i+1)), nil).String()
+		add("data", strings.ToUpper(p)+"B", dec, "0")
+		add("data", strings.ToUpper(p)+"iB", bin, "0")
+	}
+}
+
+func lookupUnit(s string) (calcUnit, bool) {
+	u, ok := calcUnits[strings.ToLower(strings.TrimPrefix(strings.ReplaceAll(s, " ", ""), "°"))]
+	return u, ok
+}
+
+var conversionPattern = regexp.MustCompile(`(?i)^(.*?)\s*(°?\s?[a-z][a-z/]*)\s+(?:to|in|as|into)\s+(°?\s?[a-z][a-z/]*)\s*$`)
+
+// convert converts a value between units of the same dimension.
+func convert(v calcValue, from, to calcUnit) (calcValue, error) {
+	if from.dimension != to.dimension {
+		return v, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from.name, from.dimension, to.name, to.dimension)
+	}
+	base, err := arith(v, exactValue(from.factor), func(a, b *big.Rat) *big.Rat { return new(big.Rat).Mul(a, b) },
+		func(a, b float64) float64 { return a * b })
+	if err != nil {
+		return base, err
+	}
+	base, err = arith(base, exactValue(new(big.Rat).Sub(from.offset, to.offset)),
+		func(a, b *big.Rat) *big.Rat { return new(big.Rat).Add(a, b) },
+		func(a, b float64) float64 { return a + b })
+	if err != nil {
+		return base, err
+	}
+	return arith(base, exactValue(to.factor), func(a, b *big.Rat) *big.Rat { return new(big.Rat).Quo(a, b) },
+		func(a, b float64) float64 { return a / b })
+}
+
+// formatValue returns a decimal representation of a value, and for exact
+// values with an infinite decimal expansion the fraction.
+func formatValue(v calcValue) (decimal, fraction string) {
+	if v.rat == nil {
+		return strconv.FormatFloat(v.f, 'g', 15, 64), ""
+	}
+	if v.rat.IsInt() {
+		return v.rat.Num().String(), ""
+	}
+	// Finite expansion if the denominator only has factors 2 and 5.
+	d := new(big.Int).Set(v.rat.Denom())
+	digits := 0
+	for _, p := range []int64{2, 5} {
+		n := 0
+		for new(big.Int).Mod(d, big.NewInt(p)).Sign() == 0 {
+			d.Quo(d, big.NewInt(p))
+			n++
+		}
+		digits = max(digits, n)
+	}
+	if d.Cmp(big.NewInt(1)) == 0 {
+		return v.rat.FloatString(digits), ""
+	}
+	s := strings.TrimRight(v.rat.FloatString(15), "0")
+	return s, v.rat.String()
+}
+
+// calculate is the handler of the calculate tool.
+func calculate(args map[string]any) (any, error) {
+	expression, _ := args["expression"].(string)
+	if strings.TrimSpace(expression) == "" {
+		return nil, fmt.Errorf("expression is required")
+	}
+	var from, to calcUnit
+	converting := false
+	if m := conversionPattern.FindStringSubmatch(expression); m != nil {
+		f, ok1 := lookupUnit(m[2])
+		t, ok2 := lookupUnit(m[3])
+		switch {
+		case ok1 && ok2:
+			expression, from, to, converting = m[1], f, t, true
+		case ok1 || ok2:
+			unknown := m[3]
+			if !ok1 {
+				unknown = m[2]
+			}
+			return nil, fmt.Errorf("unknown unit %q, known are temperatures (C, F, K), speeds (km/h, m/s, mph, kn), lengths (m, km, mi, ft, ...) and data sizes (B, kB, MB, KiB, MiB, ...)", unknown)
+		}
+	}
+	tree, err := parseCalc(expression)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", expression, err)
+	}
+	v, err := tree.eval()
+	if err != nil {
+		return nil, fmt.Errorf("cannot evaluate %s: %w", tree, err)
+	}
+	normalized := tree.String()
+	if converting {
+		if v, err = convert(v, from, to); err != nil {
+			return nil, err
+		}
+		if calcPrecedence(tree) < 6 {
+			normalized = "(" + normalized + ")"
+		}
+		normalized = fmt.Sprintf("%s %s to %s", normalized, from.name, to.name)
+	}
+	decimal, fraction := formatValue(v)
+	result := map[string]any{
+		"expression": normalized,
+		"result":     decimal,
+		"exact":      v.rat != nil,
+	}
+	if fraction != "" {
+		result["fraction"] = fraction
+	}
+	if converting {
+		result["unit"] = to.name
+	}
+	return result, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	var cases = []struct {
+		expression string
+		result     string
+		fraction   string
+		exact      bool
+		normalized string
+	}{
+		// Precedence and associativity.
+		{"1 + 2 * 3", "7", "", true, "1 + 2 * 3"},
+		{"(1 + 2) * 3", "9", "", true, "(1 + 2) * 3"},
+		{"10 - 4 - 3", "3", "", true, "10 - 4 - 3"},
+		{"10 - (4 - 3)", "9", "", true, "10 - (4 - 3)"},
+		{"2 ^ 3 ^ 2", "512", "", true, "2^3^2"},
+		{"(2^3)^2", "64", "", true, "(2^3)^2"},
+		{"-2^2", "-4", "", true, "-2^2"},
+		{"2^-1", "0.5", "", true, "2^(-1)"},
+		{"3!^2", "36", "", true, "3!^2"},
+		{"7 mod 3", "1", "", true, "7 mod 3"},
+		{"-7 mod 3", "2", "", true, "-7 mod 3"},
+		{"2 ** 10", "1024", "", true, "2^10"},
+		{"6 × 7 ÷ 2 − 1", "20", "", true, "6 * 7 / 2 - 1"},
+		// Exact rationals.
+		{"0.1 + 0.2", "0.3", "", true, "0.1 + 0.2"},
+		{"1/3", "0.333333333333333", "1/3", true, "1 / 3"},
+		{"1/3 * 3", "1", "", true, "1 / 3 * 3"},
+		{"2^100", "1267650600228229401496703205376", "", true, "2^100"},
+		{"1_000_000 / 8", "125000", "", true, "1000000 / 8"},
+		{"1.5e3 + 1", "1501", "", true, "1.5e3 + 1"},
+		{"sqrt(16/9)", "1.333333333333333", "4/3", true, "sqrt(16 / 9)"},
+		{"cbrt(27)", "3", "", true, "cbrt(27)"},
+		{"mean(1, 2, 4)", "2.333333333333333", "7/3", true, "mean(1, 2, 4)"},
+		{"round(2.5)", "3", "", true, "round(2.5)"},
+		{"round(-2.345, 2)", "-2.35", "", true, "round(-2.345, 2)"},
+		{"gcd(12, 18)", "6", "", true, "gcd(12, 18)"},
+		// Percentages.
+		{"15% of 200", "30", "", true, "15% of 200"},
+		{"200 + 10%", "220", "", true, "200 + 10%"},
+		{"200 - 10%", "180", "", true, "200 - 10%"},
+		// Floating point.
+		{"sqrt(2)", "1.4142135623731", "", false, "sqrt(2)"},
+		{"sin(90°)", "1", "", false, "sin(90°)"},
+		{"2 * pi", "6.28318530717959", "", false, "2 * pi"},
+		{"log(8, 2)", "3", "", false, "log(8, 2)"},
+	}
+	for _, c := range cases {
+		t.Run(c.expression, func(t *testing.T) {
+			v, err := calculate(map[string]any{"expression": c.expression})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := v.(map[string]any)
+			if result["result"] != c.result || result["exact"] != c.exact || result["expression"] != c.normalized {
+				t.Errorf("got %v (exact %v) for %v, want %s (exact %v) for %s",
+					result["result"], result["exact"], result["expression"], c.result, c.exact, c.normalized)
+			}
+			if fraction, _ := result["fraction"].(string); fraction != c.fraction {
+				t.Errorf("got fraction %q, want %q", fraction, c.fraction)
+			}
+		})
+	}
+}
+
+func TestCalculateConversions(t *testing.T) {
+	var cases = []struct {
+		expression, result, unit string
+		exact                    bool
+	}{
+		{"100 C to F", "212", "°F", true},
+		{"-40 °F in °C", "-40", "°C", true},
+		{"0 K to celsius", "-273.15", "°C", true},
+		{"100 km/h to m/s", "27.777777777777778", "m/s", true},
+		{"60 mph in km/h", "96.56064", "km/h", true},
+		{"1 mile to km", "1.609344", "km", true},
+		{"12 inches to cm", "30.48", "cm", true},
+		{"1 GiB to MB", "1073.741824", "MB", true},
+		{"8 bits to bytes", "1", "B", true},
+		{"(20 + 5) C to K", "298.15", "K", true},
+		{"sqrt(2) m to cm", "141.42135623731", "cm", false},
+	}
+	for _, c := range cases {
+		v, err := calculate(map[string]any{"expression": c.expression})
+		if err != nil {
+			t.Errorf("%s: %v", c.expression, err)
+			continue
+		}
+		result := v.(map[string]any)
+		if result["result"] != c.result || result["unit"] != c.unit || result["exact"] != c.exact {
+			t.Errorf("%s: got %v %v (exact %v), want %s %s (exact %v)",
+				c.expression, result["result"], result["unit"], result["exact"], c.result, c.unit, c.exact)
+		}
+	}
+}
+
+func TestCalculateErrors(t *testing.T) {
+	var cases = []struct {
+		expression, err string
+	}{
+		{"", "expression is required"},
+		{"2e", `unexpected "e" at position 1`},
+		{"2 pi", `unexpected "pi" at position 2`},
+		{"1 +", "incomplete expression at the end"},
+		{"(1 + 2", `expected ")" at the end`},
+		{"1 / 0", "division by zero"},
+		{"0^-1", "division by zero"},
+		{"5 mod 0", "division by zero"},
+		{"sqrt(-1)", "square root of a negative number"},
+		{"foo(1)", `unknown function "foo"`},
+		{"x + 1", `unknown name "x"`},
+		{"sqrt(1, 2)", "wrong number of arguments for sqrt"},
+		{"2 of 3", `"of" needs a percentage`},
+		{"1 $ 2", `unexpected character '$' at position 2`},
+		{"5 C to km", "cannot convert °C (temperature) to km (length)"},
+		{"5 C to parsec", `unknown unit "parsec"`},
+		// Limits.
+		{strings.Repeat("1+", 500) + "1", "expression longer than 1000 characters"},
+		{strings.Repeat("(", 101) + "1" + strings.Repeat(")", 101), "expression nested too deeply"},
+		{"401!", "factorial larger than 400!"},
+		{"fact(-1)", "factorial needs a non-negative integer"},
+		{"2.5!", "factorial needs a non-negative integer"},
+		{"2^3000", "result too large"},
+		{"10^1300", "result too large"},
+		{"exp(1000)", "result too large"},
+	}
+	for _, c := range cases {
+		v, err := calculate(map[string]any{"expression": c.expression})
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%.40s: got %v, %v, want error %q", c.expression, v, err, c.err)
+		}
+	}
+}
+
+// TestCalculateExactLimit checks that exact results fall back to floating
+// point when they grow too large.
+func TestCalculateExactLimit(t *testing.T) {
+	var cases = []struct {
+		expression string
+		exact      bool
+	}{
+		{"400!", true},
+		{"2^2000", true},
+		{"3^1300 / 5^800", true},
+		// More than calcMaxBits in numerator and denominator.
+		{"3^1300 / 5^900", false},
+	}
+	for _, c := range cases {
+		v, err := calculate(map[string]any{"expression": c.expression})
+		if err != nil {
+			t.Errorf("%s: %v", c.expression, err)
+			continue
+		}
+		if exact := v.(map[string]any)["exact"]; exact != c.exact {
+			t.Errorf("%s: exact %v, want %v", c.expression, exact, c.exact)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// ErrNotRecorded is returned in replay mode for requests or tool calls that
+// are not found in the cassette. It aborts the agent loop.
+var ErrNotRecorded = errors.New("not found in cassette")
+
+// CassetteEntry is a recorded chat exchange or tool execution, stored as
+// one JSON line in a cassette file.
+type CassetteEntry struct {
+	Kind     string         `json:"kind"` // "chat" or "tool"
+	Key      string         `json:"key"`
+	Request  *ChatRequest   `json:"request,omitempty"`
+	Response *ChatResponse  `json:"response,omitempty"`
+	Tool     string         `json:"tool,omitempty"`
+	Args     map[string]any `json:"args,omitempty"`
+	Result   string         `json:"result,omitempty"`
+	Images   []string       `json:"images,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// Cassette records chat responses and tool results of a session, or replays
+// them without contacting a server or running any tool. Identical requests
+// are served in recorded order. A nil cassette passes everything through.
+type Cassette struct {
+	mu      sync.Mutex
+	replay  bool
+	entries map[string][]CassetteEntry
+	f       *os.File
+	enc     *json.Encoder
+}
+
+// OpenCassette opens a cassette for recording (truncating the file) or for
+// replay.
+func OpenCassette(filename string, replay bool) (*Cassette, error) {
+	if !replay {
+		f, err := os.Create(filename)
+		if err != nil {
+			return nil, fmt.Errorf("create cassette: %w", err)
+		}
+		return &Cassette{f: f, enc: json.NewEncoder(f)}, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open cassette: %w", err)
+	}
+	defer f.Close()
+	c := &Cassette{replay: true, entries: make(map[string][]CassetteEntry)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 1<<20), 256<<20)
+	for i := 1; scanner.Scan(); i++ {
+		var entry CassetteEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("cassette %s line %d: %w", filename, i, err)
+		}
+		c.entries[entry.Key] = append(c.entries[entry.Key], entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read cassette: %w", err)
+	}
+	return c, nil
+}
+
+// contextBlock matches the environment context prepended to the first user
+// message, see environmentContext. It holds the time and working directory
+// of a run, so a replay would never match a recording.
+var contextBlock = regexp.MustCompile(`(?s)^<context>\n.*?\n</context>\n\n`)
+
+// chatKey hashes a request, ignoring fields that do not influence the answer
+// and the environment context.
+func chatKey(req ChatRequest) string {
+	req.KeepAlive = ""
+	req.DebugRenderOnly = false
+	req.Messages = slices.Clone(req.Messages)
+	for i, m := range req.Messages {
+		if m.Role == "user" {
+			req.Messages[i].Content = contextBlock.ReplaceAllString(m.Content, "")
+		}
+	}
+	b, _ := json.Marshal(req)
+	h := sha256.Sum256(append([]byte("chat\x00"), b...))
+	return hex.EncodeToString(h[:])
+}
+
+// toolKey hashes a tool invocation.
+func toolKey(name string, args map[string]any) string {
+	b, _ := json.Marshal(args)
+	h := sha256.Sum256(append([]byte("tool\x00"+name+"\x00"), b...))
+	return hex.EncodeToString(h[:])
+}
+
+// take removes and returns the next recorded entry for a key.
+func (c *Cassette) take(key string) (CassetteEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queue := c.entries[key]
+	if len(queue) == 0 {
+		return CassetteEntry{}, false
+	}
+	c.entries[key] = queue[1:]
+	return queue[0], true
+}
+
+func (c *Cassette) record(entry CassetteEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(entry); err != nil {
+		return fmt.Errorf("write cassette: %w", err)
+	}
+	return nil
+}
+
+// Chat replays the response for a request, or calls do and records the
+// response.
+func (c *Cassette) Chat(req ChatRequest, do func(ChatRequest) (*ChatResponse, error)) (*ChatResponse, error) {
+	if c == nil {
+		return do(req)
+	}
+	key := chatKey(req)
+	if c.replay {
+		entry, ok := c.take(key)
+		if !ok || entry.Response == nil {
+			return nil, fmt.Errorf("chat request %s (%s, %d messages): %w",
+				key[:12], req.Model, len(req.Messages), ErrNotRecorded)
+		}
+		return entry.Response, nil
+	}
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp, c.record(CassetteEntry{Kind: "chat", Key: key, Request: &req, Response: resp})
+}
+
+// Tool replays the result of a tool invocation, or calls do and records its
+// result, attached images and error.
+func (c *Cassette) Tool(name string, args map[string]any, do func() (string, []string, error)) (string, []string, error) {
+	if c == nil {
+		return do()
+	}
+	key := toolKey(name, args)
+	if c.replay {
+		entry, ok := c.take(key)
+		if !ok {
+			return "", nil, fmt.Errorf("tool call %s %s: %w", name, key[:12], ErrNotRecorded)
+		}
+		if entry.Error != "" {
+			return entry.Result, entry.Images, errors.New(entry.Error)
+		}
+		return entry.Result, entry.Images, nil
+	}
+	result, images, err := do()
+	entry := CassetteEntry{Kind: "tool", Key: key, Tool: name, Args: args, Result: result, Images: images}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if rerr := c.record(entry); rerr != nil {
+		return "", nil, rerr
+	}
+	return result, images, err
+}
+
+// Close closes a recording and reports replay entries that were not used.
+func (c *Cassette) Close() error {
+	if c == nil {
+		return nil
+	}
+	if c.replay {
+		var unused int
+		for _, queue := range c.entries {
+			unused += len(queue)
+		}
+		if unused > 0 {
+			log.Printf("cassette: %d recorded entries were not replayed", unused)
+		}
+		return nil
+	}
+	return c.f.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miku/unplugged/scratch/one/ollamatest"
+)
+
+// setClock pins the clock for the duration of a test.
+func setClock(t *testing.T, now time.Time) {
+	t.Helper()
+	saved := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = saved })
+}
+
+// runCassette runs the agent loop for a question against host, recording
+// to or replaying from a cassette.
+func runCassette(t *testing.T, host, filename string, replay bool, question string) ([]Message, error) {
+	t.Helper()
+	cassette, err := OpenCassette(filename, replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cassette.Close()
+	client := NewLlmClient(host)
+	client.retries = 0
+	registry := NewToolRegistry()
+	registerTools(registry)
+	client.cassette, registry.cassette = cassette, cassette
+	base := ChatRequest{Model: "qwen3-vl:latest"}
+	return converse(client, registry, base, initialMessages(question, nil), nil)
+}
+
+func TestCassetteRoundTrip(t *testing.T) {
+	srv := ollamatest.NewServer(
+		ollamatest.Turn{ToolCalls: []ollamatest.ToolCall{
+			{Name: "calculate", Arguments: map[string]any{"expression": "2^64 / 3"}},
+		}},
+		ollamatest.Turn{Content: "2^64 / 3 is 6148914691236517205 1/3"},
+	)
+	defer srv.Close()
+	filename := filepath.Join(t.TempDir(), "session.jsonl")
+	question := "what is 2^64 / 3?"
+
+	setClock(t, time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC))
+	recorded, err := runCassette(t, srv.URL, filename, false, question)
+	if err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	srv.AssertDone(t)
+	srv.AssertMessageCount(t, 1, 4)
+	srv.AssertMessage(t, 1, -1, "tool", "6148914691236517205")
+
+	// The replay runs later, in another directory and without a server; the
+	// environment context must not keep it from matching.
+	setClock(t, time.Date(2026, 3, 2, 17, 45, 0, 0, time.UTC))
+	t.Chdir(t.TempDir())
+	srv.Close()
+	replayed, err := runCassette(t, srv.URL, filename, true, question)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(replayed) != len(recorded) {
+		t.Fatalf("replay has %d messages, recording %d", len(replayed), len(recorded))
+	}
+	// The user message carries the environment context and differs.
+	if !reflect.DeepEqual(replayed[2:], recorded[2:]) {
+		t.Errorf("replay differs from recording:\n got %+v\nwant %+v", replayed[2:], recorded[2:])
+	}
+	if got := replayed[len(replayed)-1].Content; got != "2^64 / 3 is 6148914691236517205 1/3" {
+		t.Errorf("replayed answer %q", got)
+	}
+
+	if _, err := runCassette(t, srv.URL, filename, true, "what is 2^65 / 3?"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("replay of another question: got %v, want ErrNotRecorded", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+
+	"github.com/miku/unplugged/scratch/catalog"
+)
+
+// searchCatalog is the handler of the search_library_catalog tool.
+func searchCatalog(c *catalog.Solr) ToolHandler {
+	return func(args map[string]any) (any, error) {
+		str := func(key string) string {
+			s, _ := args[key].(string)
+			return s
+		}
+		num := func(key string) int {
+			f, _ := args[key].(float64)
+			return int(f)
+		}
+		q := catalog.Query{
+			Query:    str("query"),
+			Title:    str("title"),
+			Author:   str("author"),
+			ISBN:     str("isbn"),
+			ISSN:     str("issn"),
+			YearFrom: num("year_from"),
+			YearTo:   num("year_to"),
+			Page:     num("page"),
+			Rows:     num("rows"),
+		}
+		q.OpenOnly, _ = args["open_access"].(bool)
+		if facets, ok := args["facets"].([]any); ok {
+			for _, f := range facets {
+				if s, ok := f.(string); ok {
+					q.Facets = append(q.Facets, s)
+				}
+			}
+		}
+		return c.Search(context.Background(), q)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Compactor shrinks the output of a family of commands, keeping what the
+// model needs to know, e.g. only the failures of a test run.
+type Compactor struct {
+	Name string
+	// Match reports whether the compactor applies to a command.
+	Match func(command string) bool
+	// Compact receives output already cleaned of ANSI codes, progress bars
+	// and repeated lines.
+	Compact func(output string, exitCode int) string
+}
+
+// compactors are tried in order, the first match wins. Commands without a
+// matching compactor only get the generic cleanup.
+var compactors = []Compactor{
+	{Name: "go test", Match: commandHas("go test"), Compact: compactGoTest},
+	{Name: "install", Match: commandHas("apt install", "apt-get install", "pip install", "npm install",
+		"npm ci", "go mod download", "go get", "cargo build", "yarn install"), Compact: compactInstall},
+	// Structure matters for these, generic cleanup only.
+	{Name: "structured", Match: commandHas("git log", "ls -l", "git diff", "git show"), Compact: keepOutput},
+}
+
+var commandSeparator = regexp.MustCompile(`&&|\|\||;|\|`)
+
+// commandHas matches commands that contain one of the given commands, also
+// as part of a pipeline or after cd.
+func commandHas(prefixes ...string) func(string) bool {
+	return func(command string) bool {
+		for _, part := range commandSeparator.Split(command, -1) {
+			part = strings.TrimSpace(part)
+			for _, p := range prefixes {
+				if part == p || strings.HasPrefix(part, p+" ") {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func keepOutput(output string, exitCode int) string { return output }
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07]*\x07`)
+
+// cleanOutput strips ANSI escape codes, resolves carriage return progress
+// updates to their final state and collapses runs of identical lines.
+func cleanOutput(output string) string {
+	output = ansiPattern.ReplaceAllString(output, "")
+	var (
+		lines  []string
+		last   string
+		repeat int
+	)
+	flush := func() {
+		if repeat > 0 {
+			lines = append(lines, fmt.Sprintf("... (previous line repeated %d more times)", repeat))
+			repeat = 0
+		}
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if i := strings.LastIndex(line, "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		if len(lines) > 0 && line == last {
+			repeat++
+			continue
+		}
+		flush()
+		lines = append(lines, line)
+		last = line
+	}
+	flush()
+	return strings.Join(lines, "\n")
+}
+
+// compactGoTest keeps failing tests with their output, build errors and
+// panics, and replaces passing tests and packages by a count.
+func compactGoTest(output string, exitCode int) string {
+	var (
+		kept    []string
+		pending []string // output not yet attributed to a test or package result
+		passed  int
+		pkgs    int
+		inFail  bool // in the output following a failed test
+	)
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "=== RUN"):
+			pending, inFail = nil, false
+		case strings.HasPrefix(line, "=== "):
+		case strings.HasPrefix(trimmed, "--- PASS"), strings.HasPrefix(trimmed, "--- SKIP"):
+			if strings.HasPrefix(trimmed, "--- PASS") {
+				passed++
+			}
+			pending, inFail = nil, false
+		case strings.HasPrefix(trimmed, "--- FAIL"):
+			kept = append(append(kept, pending...), line)
+			pending, inFail = nil, true
+		case strings.HasPrefix(line, "ok ") || strings.HasPrefix(line, "ok\t"):
+			pkgs++
+			pending, inFail = nil, false
+		case line == "PASS", strings.HasPrefix(line, "? "), strings.HasPrefix(line, "?\t"):
+			pending = nil
+		case line == "FAIL" || strings.HasPrefix(line, "FAIL\t") || strings.HasPrefix(line, "FAIL "):
+			// Build errors and panics precede the package result.
+			kept = append(append(kept, pending...), line)
+			pending, inFail = nil, false
+		case trimmed == "":
+		case inFail:
+			kept = append(kept, line)
+		default:
+			pending = append(pending, line)
+		}
+	}
+	kept = append(kept, pending...)
+	summary := fmt.Sprintf("[compacted go test output: %d passing test(s), %d passing package(s) omitted]", passed, pkgs)
+	if len(kept) == 0 {
+		return summary
+	}
+	return strings.Join(kept, "\n") + "\n" + summary
+}
+
+var installNoise = regexp.MustCompile(`(?i)^\s*(get:\d|hit:\d|ign:\d|downloading|download|collecting|fetched|using cached|` +
+	`reading package lists|building dependency tree|reading state information|unpacking|preparing to unpack|` +
+	`selecting previously unselected|setting up|processing triggers|requirement already satisfied|` +
+	`go: downloading|go: finding|compiling|added \d+ packages? in|npm (http|timing|sill|verb)|\(reading database)`)
+
+// compactInstall drops per package download and unpack progress, keeping
+// warnings, errors and summaries.
+func compactInstall(output string, exitCode int) string {
+	var (
+		kept    []string
+		dropped int
+	)
+	for _, line := range strings.Split(output, "\n") {
+		if installNoise.MatchString(line) || strings.TrimSpace(line) == "" {
+			dropped++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n") + fmt.Sprintf("\n[compacted: %d progress line(s) omitted]", dropped)
+}
+
+// compactCommandOutput applies the generic cleanup and the compactor for the
+// command, if any. It returns the compacted output and the compactor name.
+func compactCommandOutput(command, output string, exitCode int) (string, string) {
+	if output == "" {
+		return output, ""
+	}
+	cleaned := cleanOutput(output)
+	for _, c := range compactors {
+		if c.Match(command) {
+			return c.Compact(cleaned, exitCode), c.Name
+		}
+	}
+	return cleaned, "generic"
+}
+
+// compactRunOutput replaces stdout and stderr of a run_command result by
+// their compacted form and keeps the raw output in the result store, so the
+// model can still read it. Without a store, e.g. with -offload=0, the
+// output is left as it is, since nothing would be left of what compaction
+// drops.
+func compactRunOutput(registry *ToolRegistry, fields map[string]any, command, stdout, stderr string, exitCode int) {
+	if registry.results == nil {
+		return
+	}
+	compactedOut, name := compactCommandOutput(command, stdout, exitCode)
+	compactedErr, errName := compactCommandOutput(command, stderr, exitCode)
+	name = cmp.Or(name, errName)
+	raw := len(stdout) + len(stderr)
+	compacted := len(compactedOut) + len(compactedErr)
+	if compacted >= raw {
+		return
+	}
+	handle, err := registry.results.Put("--- stdout ---\n" + stdout + "\n--- stderr ---\n" + stderr)
+	if err != nil {
+		log.Printf("keep raw output, not compacting: %v", err)
+		return
+	}
+	fields["stdout"] = compactedOut
+	fields["stderr"] = compactedErr
+	fields["compactor"] = name
+	fields["raw_bytes"] = raw
+	fields["compacted_bytes"] = compacted
+	fields["raw_handle"] = handle
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// compactCases are recorded outputs in testdata/compact, with the command
+// that produced them.
+var compactCases = []struct {
+	file      string
+	command   string
+	exitCode  int
+	compactor string
+}{
+	{"progress.txt", "make build", 0, "generic"},
+	{"go-test-fail.txt", "cd scratch && go test -v ./...", 1, "go test"},
+	{"go-test-pass.txt", "go test ./...", 0, "go test"},
+	{"apt-install.txt", "apt-get install -y jq", 0, "install"},
+	{"git-log.txt", "git log --color -2", 0, "structured"},
+	{"ls-l.txt", "ls -l", 0, "structured"},
+}
+
+func TestCompactGolden(t *testing.T) {
+	for _, c := range compactCases {
+		t.Run(c.file, func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("testdata/compact", c.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, name := compactCommandOutput(c.command, string(raw), c.exitCode)
+			if name != c.compactor {
+				t.Errorf("compactor %q, want %q", name, c.compactor)
+			}
+			golden := filepath.Join("testdata/compact", strings.TrimSuffix(c.file, ".txt")+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("compacted output differs from %s:\n%s", golden, got)
+			}
+			if strings.ContainsAny(got, "\x1b\r") {
+				t.Errorf("compacted output has escape codes or carriage returns: %q", got)
+			}
+		})
+	}
+}
+
+func TestCompactRunOutputSizes(t *testing.T) {
+	store, err := NewResultStore("", 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	registry := NewToolRegistry()
+	store.Register(registry)
+	raw, err := os.ReadFile("testdata/compact/go-test-fail.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := "go: downloading github.com/joho/godotenv v1.5.1\n"
+	fields := map[string]any{}
+	compactRunOutput(registry, fields, "go test -v ./...", string(raw), stderr, 1)
+	stdout, _ := fields["stdout"].(string)
+	compactedErr, _ := fields["stderr"].(string)
+	if got, want := fields["raw_bytes"], len(raw)+len(stderr); got != want {
+		t.Errorf("raw_bytes %v, want %d", got, want)
+	}
+	if got, want := fields["compacted_bytes"], len(stdout)+len(compactedErr); got != want {
+		t.Errorf("compacted_bytes %v, want %d", got, want)
+	}
+	if fields["compacted_bytes"].(int) >= fields["raw_bytes"].(int) {
+		t.Errorf("compacted %v bytes, not less than raw %v", fields["compacted_bytes"], fields["raw_bytes"])
+	}
+	handle, _ := fields["raw_handle"].(string)
+	kept, err := store.Get(handle)
+	if err != nil {
+		t.Fatalf("raw output not kept: %v", err)
+	}
+	if !strings.Contains(kept, string(raw)) || !strings.Contains(kept, stderr) {
+		t.Errorf("kept raw output is incomplete: %q", kept)
+	}
+
+	// Output that does not shrink is left alone.
+	fields = map[string]any{"stdout": "ok"}
+	compactRunOutput(registry, fields, "echo ok", "ok", "", 0)
+	if _, ok := fields["compactor"]; ok || fields["stdout"] != "ok" {
+		t.Errorf("uncompactable output was changed: %v", fields)
+	}
+
+	// Without a result store, as with -offload=0, the raw output stays.
+	fields = map[string]any{"stdout": string(raw), "stderr": stderr}
+	compactRunOutput(NewToolRegistry(), fields, "go test -v ./...", string(raw), stderr, 1)
+	if _, ok := fields["compactor"]; ok || fields["stdout"] != string(raw) || fields["stderr"] != stderr {
+		t.Errorf("output was compacted without a store to keep it: %v", fields["compactor"])
+	}
+}
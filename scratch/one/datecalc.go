@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dates are calendar dates without a timezone, represented as midnight UTC,
+// so that day arithmetic is not affected by daylight saving time.
+
+func civilDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func today() time.Time {
+	return civilDate(clock().In(time.Local))
+}
+
+// monthNames maps lower case English and German month names and their
+// abbreviations to the names understood by time.Parse.
+var monthNames = map[string]string{}
+
+func init() {
+	for m := time.January; m <= time.December; m++ {
+		monthNames[strings.ToLower(m.String())] = m.String()
+		monthNames[strings.ToLower(m.String()[:3])] = m.String()
+	}
+	for name, m := range map[string]time.Month{
+		"januar": time.January, "jän": time.January, "februar": time.February, "märz": time.March,
+		"maerz": time.March, "mär": time.March, "mai": time.May, "juni": time.June, "juli": time.July,
+		"okt": time.October, "oktober": time.October, "dez": time.December, "dezember": time.December,
+		"sept": time.September,
+	} {
+		monthNames[name] = m.String()
+	}
+}
+
+var (
+	dateWord    = regexp.MustCompile(`\p{L}+\.?`)
+	dateOrdinal = regexp.MustCompile(`\b(\d{1,2})(st|nd|rd|th|\.)([\s,])`)
+	dateWeekday = regexp.MustCompile(`(?i)^(monday|tuesday|wednesday|thursday|friday|saturday|sunday|montag|dienstag|mittwoch|donnerstag|freitag|samstag|sonntag),?\s+`)
+	relativeDay = regexp.MustCompile(`(?i)^(next|last|this)\s+(\p{L}+)$`)
+	dateLayouts = []string{
+		"2006-01-02", "2006/01/02", "20060102", "02.01.2006", "2.1.2006", "2.1.06", "01/02/2006", "1/2/2006",
+		"January 2, 2006", "January 2 2006", "2 January 2006", "2 January, 2006", "January 2006",
+		time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04",
+	}
+	weekdayNames = map[string]time.Weekday{
+		"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+		"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+		"sonntag": time.Sunday, "montag": time.Monday, "dienstag": time.Tuesday, "mittwoch": time.Wednesday,
+		"donnerstag": time.Thursday, "freitag": time.Friday, "samstag": time.Saturday,
+	}
+)
+
+// parseDate parses ISO, German and US style dates, dates with English or
+// German month names and relative days like "tomorrow" or "next friday".
+// Slashes are read as month/day/year, dots as day.month.year.
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "", "today", "now", "heute":
+		return today(), nil
+	case "tomorrow", "morgen":
+		return today().AddDate(0, 0, 1), nil
+	case "yesterday", "gestern":
+		return today().AddDate(0, 0, -1), nil
+	}
+	if m := relativeDay.FindStringSubmatch(s); m != nil {
+		if wd, ok := weekdayNames[strings.ToLower(m[2])]; ok {
+			t := today()
+			diff := (int(wd) - int(t.Weekday()) + 7) % 7
+			switch strings.ToLower(m[1]) {
+			case "next":
+				if diff == 0 {
+					diff = 7
+				}
+			case "last":
+				diff -= 7
+				if diff == 0 {
+					diff = -7
+				}
+			}
+			return t.AddDate(0, 0, diff), nil
+		}
+	}
+	norm := dateWeekday.ReplaceAllString(strings.Replace(s, " of ", " ", 1), "")
+	norm = dateOrdinal.ReplaceAllString(norm+" ", "$1$3")
+	norm = dateWord.ReplaceAllStringFunc(strings.TrimSpace(norm), func(w string) string {
+		if name, ok := monthNames[strings.TrimSuffix(strings.ToLower(w), ".")]; ok {
+			return name
+		}
+		return w
+	})
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, norm); err == nil {
+			return civilDate(t), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse date %q, use e.g. '2026-04-15', '15.04.2026' or 'April 15, 2026'", s)
+}
+
+// span is a calendar duration. Months and years depend on the date they
+// are added to.
+type span struct {
+	Years, Months, Days int
+	Clock               time.Duration
+}
+
+var spanPart = regexp.MustCompile(`(?i)([+-]?\d+)\s*(years?|yrs?|y|jahr(?:e|en)?|months?|mo|monate?n?|weeks?|w|wochen?|days?|d|tag(?:e|en)?|hours?|hrs?|h|stunden?|minutes?|mins?|m|seconds?|secs?|s)\b`)
+
+var unitThenNumber = regexp.MustCompile(`(\p{L})(\d)`)
+
+// parseSpan parses durations like "90 days", "2 weeks 3 days", "-1 month"
+// or "36h". A single leading minus negates all parts.
+func parseSpan(s string) (span, error) {
+	var sp span
+	s = strings.TrimSpace(s)
+	sign := 1
+	if rest, ok := strings.CutPrefix(s, "-"); ok && !strings.ContainsAny(rest, "+-") {
+		sign, s = -1, rest
+	}
+	s = unitThenNumber.ReplaceAllString(s, "$1 $2") // 2h30m
+	matches := spanPart.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return sp, fmt.Errorf("cannot parse duration %q, use e.g. '90 days', '2 weeks 3 days' or '1 month'", s)
+	}
+	for _, m := range matches {
+		n, _ := strconv.Atoi(s[m[2]:m[3]])
+		n *= sign
+		switch unit := strings.ToLower(s[m[4]:m[5]]); {
+		case strings.HasPrefix(unit, "y") || strings.HasPrefix(unit, "j"):
+			sp.Years += n
+		case strings.HasPrefix(unit, "mo"):
+			sp.Months += n
+		case strings.HasPrefix(unit, "w"):
+			sp.Days += 7 * n
+		case strings.HasPrefix(unit, "d") || strings.HasPrefix(unit, "t"):
+			sp.Days += n
+		case strings.HasPrefix(unit, "h") || strings.HasPrefix(unit, "st"):
+			sp.Clock += time.Duration(n) * time.Hour
+		case strings.HasPrefix(unit, "m"):
+			sp.Clock += time.Duration(n) * time.Minute
+		default:
+			sp.Clock += time.Duration(n) * time.Second
+		}
+	}
+	rest := spanPart.ReplaceAllString(s, "")
+	if strings.Trim(rest, " ,and") != "" {
+		return sp, fmt.Errorf("cannot parse %q in duration %q", strings.TrimSpace(rest), s)
+	}
+	return sp, nil
+}
+
+// addSpan adds a span to t. Adding months keeps the day of month, clamped
+// to the last day of the target month, so Jan 31 + 1 month is Feb 28.
+func addSpan(t time.Time, sp span) (time.Time, bool) {
+	months := sp.Years*12 + sp.Months
+	clamped := false
+	if months != 0 {
+		first := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, months, 0)
+		last := first.AddDate(0, 1, -1).Day()
+		day := t.Day()
+		if day > last {
+			day, clamped = last, true
+		}
+		t = time.Date(first.Year(), first.Month(), day, t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	}
+	return t.AddDate(0, 0, sp.Days).Add(sp.Clock), clamped
+}
+
+// calendarDiff splits the time between two dates into years, months and
+// days, e.g. "1 year, 2 months, 3 days".
+func calendarDiff(from, to time.Time) (years, months, days int) {
+	if to.Before(from) {
+		y, m, d := calendarDiff(to, from)
+		return -y, -m, -d
+	}
+	months = (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+	for months > 0 {
+		if t, _ := addSpan(from, span{Months: months}); !t.After(to) {
+			break
+		}
+		months--
+	}
+	t, _ := addSpan(from, span{Months: months})
+	return months / 12, months % 12, int(to.Sub(t).Hours() / 24)
+}
+
+// easter returns Easter Sunday of a year (anonymous Gregorian algorithm).
+func easter(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// holidayRegions compute public holidays of a year by region.
+var holidayRegions = map[string]func(year int) map[time.Time]string{
+	"none": func(int) map[time.Time]string { return nil },
+	"DE":   germanHolidays,
+	"DE-ST": func(year int) map[time.Time]string {
+		h := germanHolidays(year)
+		h[time.Date(year, 1, 6, 0, 0, 0, 0, time.UTC)] = "Heilige Drei Könige"
+		h[time.Date(year, 10, 31, 0, 0, 0, 0, time.UTC)] = "Reformationstag"
+		return h
+	},
+}
+
+// germanHolidays are the public holidays in all German states.
+func germanHolidays(year int) map[time.Time]string {
+	e := easter(year)
+	date := func(month time.Month, day int) time.Time { return time.Date(year, month, day, 0, 0, 0, 0, time.UTC) }
+	return map[time.Time]string{
+		date(1, 1):          "Neujahr",
+		e.AddDate(0, 0, -2): "Karfreitag",
+		e.AddDate(0, 0, 1):  "Ostermontag",
+		date(5, 1):          "Tag der Arbeit",
+		e.AddDate(0, 0, 39): "Christi Himmelfahrt",
+		e.AddDate(0, 0, 50): "Pfingstmontag",
+		date(10, 3):         "Tag der Deutschen Einheit",
+		date(12, 25):        "1. Weihnachtstag",
+		date(12, 26):        "2. Weihnachtstag",
+	}
+}
+
+// HolidayCalendar knows the public holidays used for business days: those
+// of a region and additional dates, e.g. from a file.
+type HolidayCalendar struct {
+	Region string
+	region func(year int) map[time.Time]string
+	extra  map[time.Time]string
+	years  map[int]map[time.Time]string
+}
+
+// NewHolidayCalendar creates a calendar for a region like DE-ST, or reads
+// one "YYYY-MM-DD name" per line from a file, in addition to the weekends.
+func NewHolidayCalendar(spec string) (*HolidayCalendar, error) {
+	if f, ok := holidayRegions[spec]; ok {
+		return &HolidayCalendar{Region: spec, region: f, extra: map[time.Time]string{}}, nil
+	}
+	file, err := os.Open(spec)
+	if err != nil {
+		return nil, fmt.Errorf("holiday calendar %q is neither a region (DE, DE-ST, none) nor a readable file: %w", spec, err)
+	}
+	defer file.Close()
+	c := &HolidayCalendar{Region: spec, region: holidayRegions["none"], extra: map[time.Time]string{}}
+	scanner := bufio.NewScanner(file)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		day, name, _ := strings.Cut(line, " ")
+		t, err := time.Parse(time.DateOnly, day)
+		if err != nil {
+			return nil, fmt.Errorf("holiday file %s line %d: %w", spec, i, err)
+		}
+		c.extra[t] = cmp.Or(strings.TrimSpace(name), "holiday")
+	}
+	return c, scanner.Err()
+}
+
+// Holiday returns the name of the holiday on a date, if any.
+func (c *HolidayCalendar) Holiday(t time.Time) (string, bool) {
+	if name, ok := c.extra[t]; ok {
+		return name, true
+	}
+	if c.years == nil {
+		c.years = make(map[int]map[time.Time]string)
+	}
+	h, ok := c.years[t.Year()]
+	if !ok {
+		h = c.region(t.Year())
+		c.years[t.Year()] = h
+	}
+	name, ok := h[t]
+	return name, ok
+}
+
+// with returns a copy of the calendar with additional holidays.
+func (c *HolidayCalendar) with(dates []time.Time) *HolidayCalendar {
+	if len(dates) == 0 {
+		return c
+	}
+	cc := *c
+	cc.extra = make(map[time.Time]string)
+	for t, name := range c.extra {
+		cc.extra[t] = name
+	}
+	for _, t := range dates {
+		cc.extra[t] = "additional holiday"
+	}
+	return &cc
+}
+
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+type skippedHoliday struct {
+	Date string `json:"date"`
+	Name string `json:"name"`
+}
+
+// businessDays counts the business days after from up to and including to,
+// negative if to is before from, and lists the holidays skipped on
+// weekdays.
+func (c *HolidayCalendar) businessDays(from, to time.Time) (int, []skippedHoliday) {
+	sign := 1
+	if to.Before(from) {
+		from, to, sign = to, from, -1
+	}
+	var (
+		n       int
+		skipped []skippedHoliday
+	)
+	for t := from.AddDate(0, 0, 1); !t.After(to); t = t.AddDate(0, 0, 1) {
+		if isWeekend(t) {
+			continue
+		}
+		if name, ok := c.Holiday(t); ok {
+			skipped = append(skipped, skippedHoliday{Date: t.Format(time.DateOnly), Name: name})
+			continue
+		}
+		n++
+	}
+	return sign * n, skipped
+}
+
+// maxBusinessDays bounds the days of business_days, about 40 years, so a
+// huge count cannot keep addBusinessDays stepping for minutes. The span
+// between two dates is bounded by the same number of business days, in
+// calendar days.
+const (
+	maxBusinessDays = 10000
+	maxBusinessSpan = maxBusinessDays * 7 / 5
+)
+
+// addBusinessDays moves n business days from t, skipping weekends and
+// holidays.
+func (c *HolidayCalendar) addBusinessDays(t time.Time, n int) (time.Time, []skippedHoliday) {
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	var skipped []skippedHoliday
+	for n > 0 {
+		t = t.AddDate(0, 0, step)
+		if isWeekend(t) {
+			continue
+		}
+		if name, ok := c.Holiday(t); ok {
+			skipped = append(skipped, skippedHoliday{Date: t.Format(time.DateOnly), Name: name})
+			continue
+		}
+		n--
+	}
+	return t, skipped
+}
+
+func isoWeek(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// describeDate returns the fields reported for every date.
+func (c *HolidayCalendar) describeDate(t time.Time) map[string]any {
+	d := map[string]any{
+		"date":     t.Format(time.DateOnly),
+		"weekday":  t.Weekday().String(),
+		"iso_week": isoWeek(t),
+	}
+	if name, ok := c.Holiday(t); ok {
+		d["holiday"] = name
+	}
+	return d
+}
+
+func plural(n int, unit string) string {
+	if n == 1 || n == -1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// dateCalc returns the handler of the date_calc tool.
+func dateCalc(cal *HolidayCalendar) ToolHandler {
+	return func(args map[string]any) (any, error) {
+		op, _ := args["operation"].(string)
+		s, _ := args["date"].(string)
+		date, err := parseDate(s)
+		if err != nil {
+			return nil, err
+		}
+		var extra []time.Time
+		if hs, ok := args["holidays"].([]any); ok {
+			for _, h := range hs {
+				hs, _ := h.(string)
+				t, err := parseDate(hs)
+				if err != nil {
+					return nil, fmt.Errorf("holidays: %w", err)
+				}
+				extra = append(extra, t)
+			}
+		}
+		cal := cal.with(extra)
+		date2 := func() (time.Time, error) {
+			s, _ := args["date2"].(string)
+			if s == "" {
+				return time.Time{}, fmt.Errorf("operation %s needs date2", op)
+			}
+			return parseDate(s)
+		}
+		switch op {
+		case "add", "subtract":
+			d, _ := args["duration"].(string)
+			sp, err := parseSpan(d)
+			if err != nil {
+				return nil, err
+			}
+			if op == "subtract" {
+				sp = span{Years: -sp.Years, Months: -sp.Months, Days: -sp.Days, Clock: -sp.Clock}
+			}
+			t, clamped := addSpan(date, sp)
+			result := cal.describeDate(civilDate(t))
+			result["from"] = date.Format(time.DateOnly)
+			result["duration"] = d
+			if sp.Clock != 0 {
+				result["datetime"] = t.Format("2006-01-02 15:04:05")
+			}
+			if clamped {
+				result["note"] = "the day did not exist in the target month and was clamped to its last day"
+			}
+			return result, nil
+		case "diff":
+			to, err := date2()
+			if err != nil {
+				return nil, err
+			}
+			days := int(to.Sub(date).Hours() / 24)
+			y, m, d := calendarDiff(date, to)
+			business, _ := cal.businessDays(date, to)
+			return map[string]any{
+				"from":          cal.describeDate(date),
+				"to":            cal.describeDate(to),
+				"days":          days,
+				"weeks":         fmt.Sprintf("%s and %s", plural(days/7, "week"), plural(days%7, "day")),
+				"calendar":      fmt.Sprintf("%s, %s, %s", plural(y, "year"), plural(m, "month"), plural(d, "day")),
+				"business_days": business,
+			}, nil
+		case "weekday":
+			result := cal.describeDate(date)
+			result["day_of_year"] = date.YearDay()
+			result["business_day"] = !isWeekend(date) && result["holiday"] == nil
+			return result, nil
+		case "iso_week":
+			year, week := date.ISOWeek()
+			monday := date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+			return map[string]any{
+				"date":     date.Format(time.DateOnly),
+				"iso_year": year,
+				"iso_week": week,
+				"monday":   monday.Format(time.DateOnly),
+				"sunday":   monday.AddDate(0, 0, 6).Format(time.DateOnly),
+			}, nil
+		case "business_days":
+			result := map[string]any{"from": cal.describeDate(date), "holiday_calendar": cal.Region}
+			var skipped []skippedHoliday
+			if n, ok := args["days"].(float64); ok {
+				if math.Abs(n) > maxBusinessDays {
+					return nil, fmt.Errorf("days must be between -%d and %d", maxBusinessDays, maxBusinessDays)
+				}
+				var t time.Time
+				t, skipped = cal.addBusinessDays(date, int(n))
+				result["days"] = int(n)
+				result["to"] = cal.describeDate(t)
+			} else {
+				to, err := date2()
+				if err != nil {
+					return nil, fmt.Errorf("operation business_days needs date2 or days")
+				}
+				if to.After(date.AddDate(0, 0, maxBusinessSpan)) || to.Before(date.AddDate(0, 0, -maxBusinessSpan)) {
+					return nil, fmt.Errorf("date and date2 must be at most %d days apart", maxBusinessSpan)
+				}
+				var n int
+				n, skipped = cal.businessDays(date, to)
+				result["to"] = cal.describeDate(to)
+				result["business_days"] = n
+			}
+			result["holidays_skipped"] = skipped
+			return result, nil
+		}
+		return nil, fmt.Errorf("unknown operation %q, use add, subtract, diff, weekday, iso_week or business_days", op)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseSpan(t *testing.T) {
+	var cases = []struct {
+		s    string
+		want span
+		err  string
+	}{
+		{"90 days", span{Days: 90}, ""},
+		{"2 weeks 3 days", span{Days: 17}, ""},
+		{"1 year, 2 months and 3 days", span{Years: 1, Months: 2, Days: 3}, ""},
+		{"-1 month", span{Months: -1}, ""},
+		{"-1 year 2 months", span{Years: -1, Months: -2}, ""},
+		{"+1 day -2 hours", span{Days: 1, Clock: -2 * time.Hour}, ""},
+		{"36h", span{Clock: 36 * time.Hour}, ""},
+		{"2h30m", span{Clock: 2*time.Hour + 30*time.Minute}, ""},
+		{"3 Tage", span{Days: 3}, ""},
+		{"1 Tag", span{Days: 1}, ""},
+		{"2 Wochen", span{Days: 14}, ""},
+		{"1 Jahr 6 Monate", span{Years: 1, Months: 6}, ""},
+		{"soon", span{}, "cannot parse duration"},
+		{"2 days later", span{}, `cannot parse "later"`},
+	}
+	for _, c := range cases {
+		got, err := parseSpan(c.s)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("parseSpan(%q): got %+v, %v, want error %q", c.s, got, err, c.err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("parseSpan(%q): got %+v, %v, want %+v", c.s, got, err, c.want)
+		}
+	}
+}
+
+func TestCalendarDiff(t *testing.T) {
+	var cases = []struct {
+		from, to            time.Time
+		years, months, days int
+	}{
+		{date(2026, 1, 15), date(2026, 1, 15), 0, 0, 0},
+		{date(2026, 1, 15), date(2027, 3, 20), 1, 2, 5},
+		{date(2027, 3, 20), date(2026, 1, 15), -1, -2, -5},
+		{date(2026, 1, 20), date(2026, 2, 10), 0, 0, 21},
+		{date(2026, 1, 31), date(2026, 2, 28), 0, 1, 0},
+		{date(2026, 3, 31), date(2026, 4, 30), 0, 1, 0},
+		{date(2024, 2, 29), date(2025, 2, 28), 1, 0, 0},
+		{date(2024, 2, 29), date(2028, 2, 29), 4, 0, 0},
+		{date(2025, 12, 31), date(2026, 1, 1), 0, 0, 1},
+	}
+	for _, c := range cases {
+		y, m, d := calendarDiff(c.from, c.to)
+		if y != c.years || m != c.months || d != c.days {
+			t.Errorf("calendarDiff(%s, %s): got %d %d %d, want %d %d %d",
+				c.from.Format(time.DateOnly), c.to.Format(time.DateOnly), y, m, d, c.years, c.months, c.days)
+		}
+	}
+}
+
+func TestEaster(t *testing.T) {
+	var cases = []struct {
+		year int
+		want time.Time
+	}{
+		{1818, date(1818, 3, 22)},
+		{2000, date(2000, 4, 23)},
+		{2019, date(2019, 4, 21)},
+		{2024, date(2024, 3, 31)},
+		{2025, date(2025, 4, 20)},
+		{2026, date(2026, 4, 5)},
+		{2027, date(2027, 3, 28)},
+		{2038, date(2038, 4, 25)},
+	}
+	for _, c := range cases {
+		if got := easter(c.year); !got.Equal(c.want) {
+			t.Errorf("easter(%d): got %s, want %s", c.year, got.Format(time.DateOnly), c.want.Format(time.DateOnly))
+		}
+	}
+}
+
+func TestHolidays(t *testing.T) {
+	var cases = []struct {
+		region string
+		date   time.Time
+		want   string
+	}{
+		{"DE", date(2026, 1, 1), "Neujahr"},
+		{"DE", date(2026, 4, 3), "Karfreitag"},
+		{"DE", date(2026, 4, 6), "Ostermontag"},
+		{"DE", date(2026, 5, 14), "Christi Himmelfahrt"},
+		{"DE", date(2026, 5, 25), "Pfingstmontag"},
+		{"DE", date(2026, 10, 3), "Tag der Deutschen Einheit"},
+		{"DE", date(2026, 1, 6), ""},
+		{"DE", date(2026, 10, 31), ""},
+		{"DE", date(2026, 4, 5), ""},
+		{"DE-ST", date(2026, 1, 6), "Heilige Drei Könige"},
+		{"DE-ST", date(2026, 10, 31), "Reformationstag"},
+		{"DE-ST", date(2027, 3, 26), "Karfreitag"},
+		{"DE-ST", date(2027, 5, 6), "Christi Himmelfahrt"},
+		{"none", date(2026, 12, 25), ""},
+	}
+	for _, c := range cases {
+		cal, err := NewHolidayCalendar(c.region)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := cal.Holiday(c.date); got != c.want {
+			t.Errorf("%s %s: got %q, want %q", c.region, c.date.Format(time.DateOnly), got, c.want)
+		}
+	}
+	if de, st := len(germanHolidays(2026)), len(holidayRegions["DE-ST"](2026)); de != 9 || st != 11 {
+		t.Errorf("got %d holidays in DE and %d in DE-ST, want 9 and 11", de, st)
+	}
+}
+
+func TestAddBusinessDays(t *testing.T) {
+	var cases = []struct {
+		region  string
+		from    time.Time
+		n       int
+		want    time.Time
+		skipped int
+	}{
+		{"DE", date(2026, 1, 2), 0, date(2026, 1, 2), 0},
+		{"DE", date(2026, 1, 2), 5, date(2026, 1, 9), 0},
+		{"DE-ST", date(2026, 1, 2), 5, date(2026, 1, 12), 1},
+		{"DE", date(2026, 4, 2), 1, date(2026, 4, 7), 2},
+		{"DE", date(2026, 4, 7), -1, date(2026, 4, 2), 2},
+		{"none", date(2026, 12, 24), 1, date(2026, 12, 25), 0},
+		{"DE", date(2026, 12, 24), 1, date(2026, 12, 28), 1},
+		{"DE", date(2026, 1, 1), 250, date(2026, 12, 24), 5},
+	}
+	for _, c := range cases {
+		cal, err := NewHolidayCalendar(c.region)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, skipped := cal.addBusinessDays(c.from, c.n)
+		if !got.Equal(c.want) || len(skipped) != c.skipped {
+			t.Errorf("%s %s %+d: got %s with %d holidays skipped, want %s with %d",
+				c.region, c.from.Format(time.DateOnly), c.n, got.Format(time.DateOnly), len(skipped), c.want.Format(time.DateOnly), c.skipped)
+		}
+		if n, _ := cal.businessDays(c.from, got); n != c.n {
+			t.Errorf("%s: businessDays(%s, %s) = %d, want %d", c.region, c.from.Format(time.DateOnly), got.Format(time.DateOnly), n, c.n)
+		}
+	}
+}
+
+func TestDateCalcBusinessDaysLimit(t *testing.T) {
+	cal, err := NewHolidayCalendar("DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := dateCalc(cal)
+	for _, days := range []float64{maxBusinessDays + 1, -1e18} {
+		_, err := handler(map[string]any{"operation": "business_days", "date": "2026-01-01", "days": days})
+		if err == nil || !strings.Contains(err.Error(), "days must be between") {
+			t.Errorf("days %v: got %v, want range error", days, err)
+		}
+	}
+	result, err := handler(map[string]any{"operation": "business_days", "date": "2026-01-01", "days": float64(-maxBusinessDays)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.(map[string]any)["days"]; got != -maxBusinessDays {
+		t.Errorf("got days %v", got)
+	}
+	for _, dates := range [][2]string{{"0001-01-01", "9999-12-31"}, {"9999-12-31", "0001-01-01"}, {"2026-01-01", "2064-06-01"}} {
+		_, err := handler(map[string]any{"operation": "business_days", "date": dates[0], "date2": dates[1]})
+		if err == nil || !strings.Contains(err.Error(), "at most 14000 days apart") {
+			t.Errorf("%s to %s: got %v, want span error", dates[0], dates[1], err)
+		}
+	}
+	result, err = handler(map[string]any{"operation": "business_days", "date": "2026-01-01", "date2": "2064-04-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := result.(map[string]any)["business_days"].(int); n < 9000 || n > maxBusinessDays {
+		t.Errorf("got %d business days in 38 years", n)
+	}
+}
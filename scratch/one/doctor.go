@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// doctor collects check results for the doctor subcommand.
+type doctor struct {
+	w        io.Writer
+	failures int
+	warnings int
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Fprintf(d.w, "[ OK ] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) warn(format string, args ...any) {
+	d.warnings++
+	fmt.Fprintf(d.w, "[WARN] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) fail(format string, args ...any) {
+	d.failures++
+	fmt.Fprintf(d.w, "[FAIL] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) info(format string, args ...any) {
+	fmt.Fprintf(d.w, "       %s\n", fmt.Sprintf(format, args...))
+}
+
+// runDoctor checks that the hosts are reachable, lists their models, checks
+// the capabilities of the chosen model against the requested features and
+// validates the registered tool schemas.
+func runDoctor(client *LlmClient, registry *ToolRegistry, base ChatRequest, w io.Writer) error {
+	d := &doctor{w: w}
+	var found bool
+	for _, ep := range client.endpoints {
+		tagsResp, err := client.tags(ep.baseURL)
+		if err != nil {
+			d.fail("host %s is not reachable: %v", ep.baseURL, err)
+			continue
+		}
+		d.ok("host %s is reachable, %d model(s)", ep.baseURL, len(tagsResp.Models))
+		for _, m := range tagsResp.Models {
+			marker := " "
+			if normalizeModel(m.Name) == normalizeModel(base.Model) {
+				marker, found = "*", true
+			}
+			d.info("%s %-40s %6.1f GB  %-8s %s", marker, m.Name, float64(m.Size)/(1<<30),
+				m.Details.ParameterSize, m.Details.QuantizationLevel)
+		}
+	}
+	if !found {
+		d.fail("model %s is not available on any host, try: ollama pull %s", base.Model, base.Model)
+	} else if show, err := client.Show(base.Model); err != nil {
+		d.fail("cannot show model %s: %v", base.Model, err)
+	} else {
+		checkCapabilities(d, show, base)
+	}
+	checkToolSchemas(d, registry.GetTools())
+	fmt.Fprintf(w, "\n%d failure(s), %d warning(s)\n", d.failures, d.warnings)
+	if d.failures > 0 {
+		return fmt.Errorf("doctor found %d failure(s)", d.failures)
+	}
+	return nil
+}
+
+func checkCapabilities(d *doctor, show *ShowResponse, base ChatRequest) {
+	has := func(c string) bool { return slices.Contains(show.Capabilities, c) }
+	d.ok("model %s has capabilities: %s", base.Model, strings.Join(show.Capabilities, ", "))
+	if has("tools") {
+		d.ok("model supports native tool calls")
+	} else {
+		d.warn("model %s does not support native tool calls; tools will be rejected or ignored", base.Model)
+		d.info("use a model with tool support, or the prompt based protocol of the")
+		d.info("modelfiles in modelfiles/, e.g. make create-model-gemma3-weather")
+	}
+	if !has("vision") {
+		d.info("model has no vision support, images (-i, view_image) will not be seen")
+	}
+	if base.Think != nil && base.Think != false && !has("thinking") {
+		d.warn("-think is set, but model %s does not support thinking", base.Model)
+	}
+	numCtx, contextLength := show.NumCtx(), show.ContextLength()
+	if v, ok := base.Options["num_ctx"]; ok {
+		d.info("num_ctx %v requested", v)
+	} else if numCtx == 0 {
+		d.warn("num_ctx not set, ollama uses its default of about %d tokens (model supports %d), consider -num-ctx",
+			defaultNumCtx, contextLength)
+	} else {
+		d.ok("num_ctx %d from modelfile, model supports %d", numCtx, contextLength)
+	}
+}
+
+// checkToolSchemas validates the tool definitions sent to the model.
+func checkToolSchemas(d *doctor, tools []Tool) {
+	seen := make(map[string]bool)
+	var invalid int
+	for _, t := range tools {
+		var problems []string
+		name := t.Function.Name
+		switch {
+		case name == "":
+			problems = append(problems, "missing name")
+		case seen[name]:
+			problems = append(problems, "duplicate name")
+		}
+		seen[name] = true
+		if t.Type != "function" {
+			problems = append(problems, fmt.Sprintf("type is %q, want \"function\"", t.Type))
+		}
+		if strings.TrimSpace(t.Function.Description) == "" {
+			problems = append(problems, "missing description")
+		}
+		problems = append(problems, schemaProblems(t.Function.Parameters, "parameters", true)...)
+		if len(problems) > 0 {
+			invalid++
+			d.fail("tool %s: %s", name, strings.Join(problems, "; "))
+		}
+	}
+	if invalid == 0 {
+		d.ok("%d tool schema(s) are well-formed", len(tools))
+	}
+}
+
+var schemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// schemaProblems checks a JSON schema fragment as used in tool parameters.
+func schemaProblems(schema map[string]any, path string, root bool) []string {
+	var problems []string
+	if schema == nil {
+		return []string{path + ": missing schema"}
+	}
+	typ, _ := schema["type"].(string)
+	switch {
+	case root && typ != "object":
+		problems = append(problems, fmt.Sprintf("%s: type is %q, want \"object\"", path, typ))
+	case typ == "" && schema["enum"] == nil && schema["anyOf"] == nil:
+		problems = append(problems, path+": missing type")
+	case typ != "" && !slices.Contains(schemaTypes, typ):
+		problems = append(problems, fmt.Sprintf("%s: unknown type %q", path, typ))
+	}
+	if typ == "object" {
+		props := map[string]any{}
+		if p, ok := schema["properties"]; ok {
+			if props, ok = p.(map[string]any); !ok {
+				problems = append(problems, path+": properties is not an object")
+			}
+		}
+		for _, k := range sortedKeys(props) {
+			ps, ok := props[k].(map[string]any)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: schema is not an object", path, k))
+				continue
+			}
+			if root {
+				if desc, _ := ps["description"].(string); desc == "" {
+					problems = append(problems, fmt.Sprintf("%s.%s: missing description", path, k))
+				}
+			}
+			problems = append(problems, schemaProblems(ps, path+"."+k, false)...)
+		}
+		var required []string
+		switch r := schema["required"].(type) {
+		case nil:
+		case []string:
+			required = r
+		case []any:
+			for _, v := range r {
+				s, _ := v.(string)
+				required = append(required, s)
+			}
+		default:
+			problems = append(problems, path+": required is not a list")
+		}
+		for _, r := range required {
+			if _, ok := props[r]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: required property %q is not defined", path, r))
+			}
+		}
+	}
+	if typ == "array" {
+		if items, ok := schema["items"].(map[string]any); ok {
+			problems = append(problems, schemaProblems(items, path+"[]", false)...)
+		} else {
+			problems = append(problems, path+": array without items schema")
+		}
+	}
+	return problems
+}
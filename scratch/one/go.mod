@@ -1,5 +1,11 @@
-module github.com/miku/unplugged/scratch/zero
+module github.com/miku/unplugged/scratch/one
 
 go 1.25.4
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/joho/godotenv v1.5.1
+	github.com/miku/unplugged v0.0.0
+)
+
+// The shared packages in scratch live in the root module.
+replace github.com/miku/unplugged => ../..
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// healthInterval is the time after which the status of a host is checked
+// again.
+const healthInterval = 30 * time.Second
+
+// probeTimeout bounds a health check, also without a client timeout, so a
+// dead host cannot hold up failover.
+const probeTimeout = 5 * time.Second
+
+// endpoint is a single ollama host. The state is guarded by the client mutex.
+type endpoint struct {
+	baseURL  string
+	checked  time.Time
+	healthy  bool
+	models   map[string]bool // models available on the host, nil if unknown
+	inflight int
+	latency  time.Duration // moving average of successful requests
+}
+
+// normalizeModel appends the default tag, so "qwen3" and "qwen3:latest"
+// refer to the same model.
+func normalizeModel(model string) string {
+	if model != "" && !strings.Contains(model, ":") {
+		return model + ":latest"
+	}
+	return model
+}
+
+// TagsResponse is the subset of the /api/tags response we use.
+type TagsResponse struct {
+	Models []struct {
+		Name    string `json:"name"`
+		Size    int64  `json:"size"`
+		Details struct {
+			ParameterSize     string `json:"parameter_size"`
+			QuantizationLevel string `json:"quantization_level"`
+		} `json:"details"`
+	} `json:"models"`
+}
+
+// tags lists the models available on a host.
+func (c *LlmClient) tags(baseURL string) (*TagsResponse, error) {
+	timeout := probeTimeout
+	if c.client.Timeout > 0 {
+		timeout = min(c.client.Timeout, probeTimeout)
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(baseURL + "/api/tags")
+	if err != nil {
+		return nil, fmt.Errorf("get tags: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	var tagsResp TagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tagsResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &tagsResp, nil
+}
+
+// checkHealth refreshes reachability and the model list of an endpoint, if
+// the last check is older than the health interval. Called with the mutex
+// held, which is released during the request.
+func (c *LlmClient) checkHealth(ep *endpoint) {
+	if time.Since(ep.checked) < healthInterval {
+		return
+	}
+	c.mu.Unlock()
+	tagsResp, err := c.tags(ep.baseURL)
+	c.mu.Lock()
+	ep.checked = time.Now()
+	if err != nil {
+		if ep.healthy || ep.models == nil {
+			log.Printf("host %s is not available: %v", ep.baseURL, err)
+		}
+		ep.healthy = false
+		return
+	}
+	ep.healthy = true
+	ep.models = make(map[string]bool)
+	for _, m := range tagsResp.Models {
+		ep.models[normalizeModel(m.Name)] = true
+	}
+}
+
+// eligible reports whether an endpoint may serve the model, i.e. it is
+// either known to have the model or its model list is unknown.
+func (ep *endpoint) eligible(model string) bool {
+	return model == "" || ep.models == nil || ep.models[normalizeModel(model)]
+}
+
+// pick selects the endpoint for the next attempt, preferring healthy hosts
+// that have the model and have not been tried yet. If all hosts have been
+// tried, a new round starts with any eligible host.
+func (c *LlmClient) pick(model string, tried map[*endpoint]bool) (*endpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ep := range c.endpoints {
+		c.checkHealth(ep)
+	}
+	filters := []func(*endpoint) bool{
+		func(ep *endpoint) bool { return ep.healthy && ep.eligible(model) && !tried[ep] },
+		func(ep *endpoint) bool { return ep.eligible(model) && !tried[ep] },
+		func(ep *endpoint) bool { return ep.eligible(model) },
+	}
+	for _, keep := range filters {
+		var candidates []*endpoint
+		for _, ep := range c.endpoints {
+			if keep(ep) {
+				candidates = append(candidates, ep)
+			}
+		}
+		if len(candidates) > 0 {
+			return c.choose(candidates), nil
+		}
+	}
+	return nil, fmt.Errorf("model %s is not available on any host", model)
+}
+
+// choose applies the balancing strategy to the candidates.
+func (c *LlmClient) choose(candidates []*endpoint) *endpoint {
+	switch c.balance {
+	case "least-busy":
+		best := candidates[0]
+		for _, ep := range candidates[1:] {
+			if ep.inflight < best.inflight || (ep.inflight == best.inflight && ep.latency < best.latency) {
+				best = ep
+			}
+		}
+		return best
+	default:
+		ep := candidates[c.next%len(candidates)]
+		c.next++
+		return ep
+	}
+}
+
+// done updates the endpoint state after a request.
+func (c *LlmClient) done(ep *endpoint, took time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ep.inflight--
+	if err != nil {
+		ep.healthy = false
+		ep.checked = time.Now()
+		return
+	}
+	if ep.latency == 0 {
+		ep.latency = took
+	} else {
+		ep.latency = (3*ep.latency + took) / 4
+	}
+}
+
+// post sends a JSON body to the path on one of the hosts that has the model.
+// Connection errors and server errors fail over to the next host, up to the
+// configured number of retries. The caller must close the response body.
+func (c *LlmClient) post(path, model string, body []byte) (*http.Response, error) {
+	var (
+		tried   = make(map[*endpoint]bool)
+		lastErr error
+	)
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		ep, err := c.pick(model, tried)
+		if err != nil {
+			return nil, err
+		}
+		if attempt > 0 {
+			wait := time.Duration(attempt) * 500 * time.Millisecond
+			log.Printf("retrying with %s in %v after: %v", ep.baseURL, wait, lastErr)
+			time.Sleep(wait)
+		}
+		tried[ep] = true
+		c.mu.Lock()
+		ep.inflight++
+		c.mu.Unlock()
+		started := time.Now()
+		resp, err := c.client.Post(ep.baseURL+path, "application/json", bytes.NewReader(body))
+		switch {
+		case err != nil:
+			lastErr = fmt.Errorf("post request to %s: %w", ep.baseURL, err)
+		case resp.StatusCode >= 500:
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			lastErr = fmt.Errorf("unexpected status %d from %s: %s", resp.StatusCode, ep.baseURL, string(b))
+		default:
+			c.done(ep, time.Since(started), nil)
+			return resp, nil
+		}
+		c.done(ep, 0, lastErr)
+	}
+	return nil, lastErr
+}
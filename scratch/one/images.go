@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"strings"
+)
+
+// stringsFlag collects repeated string flags.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// loadImage reads a PNG, JPEG or GIF file and returns it base64 encoded, as
+// expected in Message.Images. Images with a side longer than maxSize are
+// downscaled, GIF is always converted to PNG. A maxSize of 0 keeps the
+// original size.
+func loadImage(path string, maxSize int) (encoded string, width, height int, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("cannot read image: %w", err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("unsupported image: %w", err)
+	}
+	width, height = cfg.Width, cfg.Height
+	tooLarge := maxSize > 0 && (width > maxSize || height > maxSize)
+	if format != "gif" && !tooLarge {
+		return base64.StdEncoding.EncodeToString(b), width, height, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("cannot decode image: %w", err)
+	}
+	if tooLarge {
+		img = downscale(img, maxSize)
+		width, height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", 0, 0, fmt.Errorf("cannot encode image: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), width, height, nil
+}
+
+// downscale shrinks an image so that its longer side is maxSize, averaging
+// all source pixels that fall into a target pixel.
+func downscale(src image.Image, maxSize int) image.Image {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dw, dh := maxSize, maxSize
+	if sw > sh {
+		dh = max(1, sh*maxSize/sw)
+	} else {
+		dw = max(1, sw*maxSize/sh)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sb.Min.X+sx, sb.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
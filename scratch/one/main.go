@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -14,9 +15,12 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/miku/unplugged/scratch/catalog"
+	"github.com/miku/unplugged/scratch/llm"
 )
 
 var (
@@ -26,130 +30,191 @@ var (
 	timeout          = flag.Duration("T", 90*time.Second, "timeout for requests")
 	dumpTools        = flag.Bool("t", false, "dump tools")
 	debugRenderOnly  = flag.Bool("d", false, "debug render only")
+	configFile       = flag.String("c", "", "path to JSON config file with model options")
+	temperature      = flag.Float64("temperature", 0, "sampling temperature (only sent if set)")
+	seed             = flag.Int("seed", 0, "random seed for reproducible runs (only sent if set)")
+	numCtx           = flag.Int("num-ctx", 0, "context window size in tokens (only sent if set)")
+	keepAlive        = flag.String("keep-alive", "", "how long to keep the model loaded, e.g. 5m, 1h, -1m")
+	imageMaxSize     = flag.Int("image-max-size", 1024, "downscale images with a longer side, 0 keeps the original size")
+	think            = flag.String("think", "", "request reasoning: true, false or low, medium, high (default: model decides)")
+	hideThinking     = flag.Bool("hide-thinking", false, "do not display reasoning content")
+	keepThinking     = flag.Bool("keep-thinking", false, "send reasoning content back to the model as part of the history")
+	transcriptFile   = flag.String("transcript", "", "append all messages, including reasoning, as JSON lines to this file")
+	schemaFile       = flag.String("schema", "", "structured output: JSON schema file, writes validated JSON to stdout")
+	jsonOutput       = flag.Bool("json", false, "structured output: ask for any JSON, writes validated JSON to stdout")
+	schemaRetries    = flag.Int("schema-retries", 2, "number of retries when structured output does not validate")
+	readStdin        = flag.Bool("stdin", false, "append standard input to the user message")
+	balance          = flag.String("balance", "round-robin", "how to spread requests over multiple hosts in OLLAMA_HOST: round-robin, least-busy")
+	retries          = flag.Int("retries", 2, "number of retries on connection errors or server errors, trying other hosts first")
+	recordFile       = flag.String("record", "", "record chat responses and tool results to this cassette file")
+	replayFile       = flag.String("replay", "", "replay chat responses and tool results from this cassette file, without a server")
+	showStats        = flag.Bool("stats", false, "print a performance summary at the end of the run")
+	statsFile        = flag.String("stats-json", "", "append the performance summary as JSON to this file")
+	envContext       = flag.Bool("env", true, "add date and working directory to the first user message")
+	routeTools       = flag.Bool("route-tools", false, "only send tools relevant to the request, selected by embedding similarity")
+	routerK          = flag.Int("route-k", 4, "number of tools selected by the tool router, in addition to the core tools")
+	routerCore       = flag.String("route-core", "list_files,read_file", "comma separated tools always sent when routing")
+	embedModel       = flag.String("embed-model", "nomic-embed-text", "embedding model for the tool router")
+	offloadBytes     = flag.Int("offload", 16384, "store tool outputs larger than this many bytes outside the context, 0 disables")
+	resultDir        = flag.String("result-dir", "", "directory for stored tool outputs (default: temporary, removed on exit)")
+	resultFormat     = flag.String("result-format", "json", "format of tool results in the context: json, yaml or toon")
+	holidays         = flag.String("holidays", "DE-ST", "holiday calendar for business days: DE, DE-ST, none or a file with one 'YYYY-MM-DD name' per line")
+	weatherURL       = flag.String("weather-url", "https://api.open-meteo.com", "base URL of the open-meteo forecast API")
+	geocodingURL     = flag.String("geocoding-url", "https://geocoding-api.open-meteo.com", "base URL of the open-meteo geocoding API")
+	weatherCache     = flag.Duration("weather-cache", 10*time.Minute, "how long to cache weather and geocoding responses, 0 disables")
+	catalogURL       = flag.String("catalog-url", os.Getenv("CATALOG_URL"), "Solr select endpoint of the library catalog, e.g. https://example.org/solr/biblio/select")
+	compactOutput    = flag.Bool("compact", true, "compact the output of common commands, e.g. keep only failures of go test; needs -offload to keep the raw output")
+	extraOptions     = make(optionsFlag)
+	imageFiles       stringsFlag
 )
 
-type Message struct {
-	Role      string     `json:"role"`
-	Content   string     `json:"content"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+func init() {
+	flag.Var(extraOptions, "O", "model option as key=value, may be repeated, e.g. -O top_k=20")
+	flag.Var(&imageFiles, "i", "image file to attach to the user message, may be repeated")
 }
 
-type ToolCall struct {
-	Function FunctionCall `json:"function"`
-}
-
-type FunctionCall struct {
-	Name      string         `json:"name"`
-	Arguments map[string]any `json:"arguments"`
-}
-
-type Tool struct {
-	Type     string       `json:"type"`
-	Function ToolFunction `json:"function"`
-}
-
-type ToolFunction struct {
-	Name        string         `json:"name"`
-	Description string         `json:"description"`
-	Parameters  map[string]any `json:"parameters"`
-}
-
-// ChatRequest, cf. https://github.com/ollama/ollama/blob/47e272c35a9d9b5780826a4965f3115908187a7b/openai/openai.go#L98-L117
-type ChatRequest struct {
-	Model           string    `json:"model"`
-	Messages        []Message `json:"messages"`
-	Tools           []Tool    `json:"tools,omitempty"`
-	Stream          bool      `json:"stream"`
-	DebugRenderOnly bool      `json:"_debug_render_only"`
-}
-
-type ChatResponse struct {
-	Message Message `json:"message"`
-	Done    bool    `json:"done"`
-}
-
-type ToolHandler func(args map[string]any) (string, error)
+// The chat types are shared with the other programs in scratch.
+type (
+	Message      = llm.Message
+	ToolCall     = llm.ToolCall
+	FunctionCall = llm.FunctionCall
+	Tool         = llm.Tool
+	ToolFunction = llm.ToolFunction
+	ChatRequest  = llm.ChatRequest
+	ChatResponse = llm.ChatResponse
+	// ToolHandler executes a tool call. The result is marshaled to JSON and
+	// rendered in the result format of the registry.
+	ToolHandler = llm.ToolHandler
+)
 
+// ToolRegistry adds cassettes, metrics, result offloading and formatting,
+// tool routing and image attachments to the shared registry.
 type ToolRegistry struct {
-	definitions []Tool
-	handlers    map[string]ToolHandler
+	*llm.ToolRegistry
+	attachments []string // images to send along with the next tool result
+	cassette    *Cassette
+	metrics     *Metrics
+	router      *ToolRouter
+	results     *ResultStore
+	format      func(v any) string // result format, nil for JSON
 }
 
 func NewToolRegistry() *ToolRegistry {
-	return &ToolRegistry{
-		definitions: []Tool{},
-		handlers:    make(map[string]ToolHandler),
-	}
+	return &ToolRegistry{ToolRegistry: llm.NewToolRegistry()}
 }
 
-func (r *ToolRegistry) Register(name, description string, parameters map[string]any, handler ToolHandler) {
-	tool := Tool{
-		Type: "function",
-		Function: ToolFunction{
-			Name:        name,
-			Description: description,
-			Parameters:  parameters,
-		},
+// ToolsFor returns the tools to offer for the conversation: all tools, or
+// the selection of the router, if any.
+func (r *ToolRegistry) ToolsFor(messages []Message) []Tool {
+	if r.router == nil {
+		return r.GetTools()
 	}
-	r.definitions = append(r.definitions, tool)
-	r.handlers[name] = handler
+	return r.router.Select(r.GetTools(), messages)
+}
+
+// Attach adds base64 encoded images to the message carrying the result of the
+// currently running tool.
+func (r *ToolRegistry) Attach(images ...string) {
+	r.attachments = append(r.attachments, images...)
 }
 
-func (r *ToolRegistry) GetTools() []Tool {
-	return r.definitions
+// TakeAttachments returns and clears images attached during tool execution.
+func (r *ToolRegistry) TakeAttachments() []string {
+	images := r.attachments
+	r.attachments = nil
+	return images
 }
 
 func (r *ToolRegistry) Execute(name string, args map[string]any) (string, error) {
-	handler, ok := r.handlers[name]
+	handler, ok := r.Handler(name)
 	if !ok {
 		return "", fmt.Errorf("unknown tool: %s", name)
 	}
-	return handler(args)
+	started := time.Now()
+	result, images, err := r.cassette.Tool(name, args, func() (string, []string, error) {
+		v, err := handler(args)
+		if err != nil {
+			return "", r.TakeAttachments(), err
+		}
+		result, err := marshalResult(v)
+		return result, r.TakeAttachments(), err
+	})
+	r.metrics.Tool(name, time.Since(started), result, err)
+	r.Attach(images...)
+	if err == nil && name != "read_result" && name != "search_result" {
+		result, err = r.results.Offload(name, result)
+	}
+	return r.formatResult(result), err
 }
 
 // ---- LLM Client ----
 
 type LlmClient struct {
-	baseURL string
-	client  *http.Client
+	client   *http.Client
+	models   map[string]*ShowResponse // cached model info, nil if unavailable
+	balance  string
+	retries  int
+	cassette *Cassette
+	metrics  *Metrics
+	// renderOut receives the rendered prompts of debug render requests.
+	renderOut io.Writer
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
 }
 
-func NewLlmClient(baseURL string) *LlmClient {
-	return &LlmClient{
-		baseURL: baseURL,
+// NewLlmClient creates a client for one or more ollama hosts. Requests are
+// spread across hosts that have the requested model.
+func NewLlmClient(hosts ...string) *LlmClient {
+	c := &LlmClient{
 		client: &http.Client{
 			Timeout: *timeout,
 		},
+		models:    make(map[string]*ShowResponse),
+		balance:   *balance,
+		retries:   *retries,
+		renderOut: os.Stdout,
+	}
+	for _, h := range hosts {
+		c.endpoints = append(c.endpoints, &endpoint{baseURL: h})
 	}
+	return c
 }
 
+// Chat sends a chat request, or replays the response from a cassette.
 func (c *LlmClient) Chat(req ChatRequest) (*ChatResponse, error) {
+	started := time.Now()
+	resp, err := c.cassette.Chat(req, c.chat)
+	c.metrics.Chat(req, resp, time.Since(started))
+	return resp, err
+}
+
+func (c *LlmClient) chat(req ChatRequest) (*ChatResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 	log.Printf("context length: %v", len(body))
-	resp, err := c.client.Post(c.baseURL+"/api/chat", "application/json", bytes.NewReader(body))
+	c.checkContextWindow(req)
+	resp, err := c.post("/api/chat", req.Model, body)
 	if err != nil {
-		return nil, fmt.Errorf("post request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	if err := llm.CheckStatus(resp); err != nil {
+		return nil, err
 	}
 	if req.DebugRenderOnly {
-		if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
-			log.Fatal(err)
+		if _, err := io.Copy(c.renderOut, resp.Body); err != nil {
+			return nil, fmt.Errorf("read rendered prompt: %w", err)
 		}
 		return &ChatResponse{Message: Message{Content: "this is a debug message"}}, nil
 	}
-
-	var chatResp ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
+	if req.Stream {
+		return llm.DecodeChatStream(resp.Body)
 	}
-	return &chatResp, nil
+	return llm.DecodeChatResponse(resp.Body)
 }
 
 func main() {
@@ -163,189 +228,290 @@ func main() {
 		model = "qwen3-vl:latest"
 	}
 	log.Printf("using %s from %s", model, ollamaHost)
+	modelConfig, err := resolveModelConfig(*configFile, model)
+	if err != nil {
+		log.Fatal(err)
+	}
+	thinkValue, err := parseThink(*think)
+	if err != nil {
+		log.Fatal(err)
+	}
+	format, schema, err := loadFormat(*schemaFile, *jsonOutput)
+	if err != nil {
+		log.Fatal(err)
+	}
 	var (
-		client   = NewLlmClient(ollamaHost)
+		client   = NewLlmClient(llm.SplitHosts(ollamaHost)...)
 		registry = NewToolRegistry()
+		base     = ChatRequest{
+			Model:     model,
+			Options:   modelConfig.Options,
+			KeepAlive: modelConfig.KeepAlive,
+			Think:     thinkValue,
+			Format:    format,
+		}
 	)
+	if len(base.Options) > 0 {
+		b, _ := json.Marshal(base.Options)
+		log.Printf("options: %s", string(b))
+	}
 	registerTools(registry)
+	if f, ok := resultFormats[*resultFormat]; !ok {
+		log.Fatalf("unknown result format: %s", *resultFormat)
+	} else if *resultFormat != "json" {
+		registry.format = f
+	}
+	if *offloadBytes > 0 {
+		store, err := NewResultStore(*resultDir, *offloadBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+		store.Register(registry)
+	}
+	if *routeTools {
+		NewToolRouter(client, *embedModel, *routerK, strings.Split(*routerCore, ",")).Register(registry)
+	}
+	if *recordFile != "" || *replayFile != "" {
+		var cassette *Cassette
+		if *replayFile != "" {
+			cassette, err = OpenCassette(*replayFile, true)
+		} else {
+			cassette, err = OpenCassette(*recordFile, false)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer cassette.Close()
+		client.cassette, registry.cassette = cassette, cassette
+	}
 	switch {
 	case *dumpTools:
-		b, err := json.Marshal(registry.definitions)
+		b, err := json.Marshal(registry.GetTools())
 		if err != nil {
 			log.Fatal(err)
 		}
 		fmt.Println(string(b))
+	case flag.Arg(0) == "bench-formats":
+		if err := runFormatBench(registry, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	case flag.Arg(0) == "doctor":
+		if err := runDoctor(client, registry, base, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
 	default:
+		if *readStdin {
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatal(err)
+			}
+			*userMessage += "\n\n" + string(b)
+		}
 		log.Printf("user: %s", *userMessage)
-		err := runAgentLoop(client, model, registry, *userMessage)
+		var images []string
+		for _, filename := range imageFiles {
+			encoded, w, h, err := loadImage(filename, *imageMaxSize)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("attaching %s (%dx%d)", filename, w, h)
+			images = append(images, encoded)
+		}
+		transcript, err := OpenTranscript(*transcriptFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer transcript.Close()
+		if *showStats || *statsFile != "" {
+			client.metrics = NewMetrics(model)
+			registry.metrics = client.metrics
+		}
+		if format != nil {
+			err = runStructured(client, registry, base, schema, *userMessage, images, transcript, *schemaRetries, os.Stdout)
+		} else {
+			err = runAgentLoop(client, registry, base, *userMessage, images, transcript)
+		}
+		if client.metrics != nil {
+			summary := client.metrics.Summary()
+			if *showStats {
+				summary.WriteTable(os.Stderr)
+			}
+			if *statsFile != "" {
+				if err := summary.AppendJSON(*statsFile); err != nil {
+					log.Println(err)
+				}
+			}
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
-	}
-}
-
-func wmoCodeToCondition(code int) string {
-	switch {
-	case code == 0:
-		return "Clear sky"
-	case code <= 2:
-		return "Partly cloudy"
-	case code == 3:
-		return "Overcast"
-	case code <= 49:
-		return "Foggy"
-	case code <= 59:
-		return "Drizzle"
-	case code <= 69:
-		return "Rain"
-	case code <= 79:
-		return "Snow"
-	case code <= 82:
-		return "Rain showers"
-	case code <= 86:
-		return "Snow showers"
-	case code <= 99:
-		return "Thunderstorm"
-	default:
-		return "Unknown"
 	}
 }
 
 func registerTools(registry *ToolRegistry) {
+	weather := NewOpenMeteo(*weatherURL, *geocodingURL, *weatherCache)
 	registry.Register(
 		"get_weather",
-		"Get the current weather for a given city",
+		"Get the current weather for a given city and optionally a forecast for the next days",
 		map[string]any{
 			"type":     "object",
 			"required": []string{"city"},
 			"properties": map[string]any{
 				"city": map[string]any{
 					"type":        "string",
-					"description": "The city name, e.g. 'Paris' or 'New York'",
+					"description": "The city name, e.g. 'Paris' or 'New York', optionally with region or country to disambiguate, e.g. 'Paris, Texas' or 'Halle, Saxony-Anhalt'",
+				},
+				"unit": map[string]any{
+					"type":        "string",
+					"enum":        []string{"celsius", "fahrenheit"},
+					"description": "Temperature unit, default celsius",
+				},
+				"days": map[string]any{
+					"type":        "integer",
+					"description": fmt.Sprintf("Number of forecast days including today, 0 to %d, default 0 for current weather only", maxForecastDays),
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
-			city, _ := args["city"].(string)
-			if city == "" {
-				return "", fmt.Errorf("city name is required")
-			}
-
-			// Step 1: Geocode city name using Open-Meteo's geocoding API
-			geoURL := fmt.Sprintf("https://geocoding-api.open-meteo.com/v1/search?name=%s&count=1&language=en&format=json",
-				strings.ReplaceAll(city, " ", "+"))
-			geoResp, err := http.Get(geoURL)
-			if err != nil {
-				return "", fmt.Errorf("geocoding request failed: %w", err)
-			}
-			defer geoResp.Body.Close()
-
-			var geoData struct {
-				Results []struct {
-					Name      string  `json:"name"`
-					Latitude  float64 `json:"latitude"`
-					Longitude float64 `json:"longitude"`
-					Country   string  `json:"country"`
-				} `json:"results"`
-			}
-			if err := json.NewDecoder(geoResp.Body).Decode(&geoData); err != nil {
-				return "", fmt.Errorf("geocoding decode failed: %w", err)
-			}
-			if len(geoData.Results) == 0 {
-				return "", fmt.Errorf("city not found: %s", city)
-			}
-
-			loc := geoData.Results[0]
-
-			// Step 2: Fetch weather using coordinates
-			weatherURL := fmt.Sprintf(
-				"https://api.open-meteo.com/v1/forecast?latitude=%f&longitude=%f&current=temperature_2m,relative_humidity_2m,weather_code,wind_speed_10m&wind_speed_unit=kmh&temperature_unit=celsius",
-				loc.Latitude, loc.Longitude)
-			weatherResp, err := http.Get(weatherURL)
-			if err != nil {
-				return "", fmt.Errorf("weather request failed: %w", err)
-			}
-			defer weatherResp.Body.Close()
-
-			var weatherData struct {
-				Current struct {
-					Temperature float64 `json:"temperature_2m"`
-					Humidity    int     `json:"relative_humidity_2m"`
-					WeatherCode int     `json:"weather_code"`
-					WindSpeed   float64 `json:"wind_speed_10m"`
-				} `json:"current"`
-			}
-			if err := json.NewDecoder(weatherResp.Body).Decode(&weatherData); err != nil {
-				return "", fmt.Errorf("weather decode failed: %w", err)
-			}
-
-			c := weatherData.Current
-			return fmt.Sprintf(
-				`{"city": "%s", "country": "%s", "temperature": "%.1f°C", "humidity": "%d%%", "wind_speed": "%.1f km/h", "condition": "%s"}`,
-				loc.Name, loc.Country, c.Temperature, c.Humidity, c.WindSpeed, wmoCodeToCondition(c.WeatherCode),
-			), nil
-		},
+		getWeather(weather),
 	)
 
 	registry.Register(
-		"add_numbers",
-		"Add two numbers together and return the result",
+		"calculate",
+		"Evaluate an arithmetic expression exactly, e.g. '2^100', '0.1 + 0.2', '200 + 15%', 'sqrt(2) * sin(30°)', 'mean(3, 5, 8)' or a unit conversion like '100 km/h to mph', '72 F to C', '3 GiB in MB'. Supports + - * / ^ mod !, percentages, sqrt, cbrt, root, abs, floor, ceil, round, trigonometry, ln, log, exp, min, max, sum, mean, median, stdev, var, gcd, lcm and the constants pi, e, tau",
 		map[string]any{
 			"type":     "object",
-			"required": []string{"a", "b"},
+			"required": []string{"expression"},
 			"properties": map[string]any{
-				"a": map[string]any{
-					"type":        "number",
-					"description": "The first number",
-				},
-				"b": map[string]any{
-					"type":        "number",
-					"description": "The second number",
+				"expression": map[string]any{
+					"type":        "string",
+					"description": "The expression to evaluate, optionally followed by '<unit> to <unit>'",
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
-			a, _ := args["a"].(float64)
-			b, _ := args["b"].(float64)
-			return fmt.Sprintf(`{"result": %v}`, a+b), nil
-		},
+		calculate,
 	)
 
 	registry.Register(
 		"get_time",
-		"Get the current time in a given timezone",
+		"Get the current time in a timezone, with UTC offset and daylight saving time, or convert a time between two timezones",
 		map[string]any{
 			"type":     "object",
 			"required": []string{"timezone"},
 			"properties": map[string]any{
 				"timezone": map[string]any{
 					"type":        "string",
-					"description": "The timezone, e.g. 'UTC', 'America/New_York', 'Europe/London'",
+					"description": "An IANA timezone like 'Europe/Berlin', a city like 'Halle' or an abbreviation like 'CET' or 'PST'",
+				},
+				"time": map[string]any{
+					"type":        "string",
+					"description": "Optional time in that timezone to use instead of now, e.g. '2026-03-29 14:30' or '09:00'",
+				},
+				"to_timezone": map[string]any{
+					"type":        "string",
+					"description": "Optional timezone to convert the time to",
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
-			tz, _ := args["timezone"].(string)
-			return fmt.Sprintf(`{"timezone": "%s", "time": "14:30:00", "date": "2024-01-15"}`, tz), nil
+		getTime,
+	)
+
+	calendar, err := NewHolidayCalendar(*holidays)
+	if err != nil {
+		log.Fatal(err)
+	}
+	registry.Register(
+		"date_calc",
+		"Calendar arithmetic: add or subtract durations, difference between dates, weekday, ISO week and business days excluding public holidays",
+		map[string]any{
+			"type":     "object",
+			"required": []string{"operation"},
+			"properties": map[string]any{
+				"operation": map[string]any{
+					"type":        "string",
+					"enum":        []string{"add", "subtract", "diff", "weekday", "iso_week", "business_days"},
+					"description": "What to calculate",
+				},
+				"date": map[string]any{
+					"type":        "string",
+					"description": "The date, e.g. '2026-04-15', '15.04.2026', 'April 15, 2026' or 'tomorrow' (default: today)",
+				},
+				"date2": map[string]any{
+					"type":        "string",
+					"description": "The end date for diff and business_days (at most 14000 days from date for business_days)",
+				},
+				"duration": map[string]any{
+					"type":        "string",
+					"description": "The duration for add and subtract, e.g. '90 days', '2 weeks 3 days' or '1 month'",
+				},
+				"days": map[string]any{
+					"type":        "number",
+					"description": "Number of business days to add for business_days, instead of date2, negative to go back (at most 10000)",
+				},
+				"holidays": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string"},
+					"description": "Optional additional non-working days for business_days",
+				},
+			},
 		},
+		dateCalc(calendar),
 	)
 
 	registry.Register(
 		"search_library_catalog",
-		"Search for availability of a publication in a library catalog",
+		"Search the library catalog for publications, returns title, author, year and links of matching records, the number of hits and optionally facet counts",
 		map[string]any{
-			"type":     "object",
-			"required": []string{"query"},
+			"type": "object",
 			"properties": map[string]any{
 				"query": map[string]any{
 					"type":        "string",
-					"description": "a query string to put into a catalog search, can be an author, title, isbn, issn, or a combination of those",
+					"description": "free text to search in all fields, use double quotes for phrases",
+				},
+				"title": map[string]any{
+					"type":        "string",
+					"description": "words or phrase in the title",
+				},
+				"author": map[string]any{
+					"type":        "string",
+					"description": "author name, e.g. 'Jones, Nicola'",
+				},
+				"isbn": map[string]any{
+					"type":        "string",
+					"description": "ISBN, with or without hyphens",
+				},
+				"issn": map[string]any{
+					"type":        "string",
+					"description": "ISSN, e.g. 1556-5068",
+				},
+				"year_from": map[string]any{
+					"type":        "integer",
+					"description": "earliest publication year",
+				},
+				"year_to": map[string]any{
+					"type":        "integer",
+					"description": "latest publication year",
+				},
+				"open_access": map[string]any{
+					"type":        "boolean",
+					"description": "only return open access records",
+				},
+				"facets": map[string]any{
+					"type":        "array",
+					"items":       map[string]any{"type": "string", "enum": []string{"year", "access_state", "format", "language"}},
+					"description": "fields to count the values of over all hits, e.g. to see the distribution by year",
+				},
+				"page": map[string]any{
+					"type":        "integer",
+					"description": "page of results, starting at 1",
+				},
+				"rows": map[string]any{
+					"type":        "integer",
+					"description": fmt.Sprintf("records per page, default %d, at most %d", catalog.DefaultRows, catalog.MaxRows),
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
-			return "found 4 books", nil
-		},
+		searchCatalog(catalog.NewSolr(*catalogURL)),
 	)
 
 	registry.Register(
@@ -355,14 +521,14 @@ func registerTools(registry *ToolRegistry) {
 			"type":     "object",
 			"required": []string{"hostname_or_ip"},
 			"properties": map[string]any{
-				"query": map[string]any{
+				"hostname_or_ip": map[string]any{
 					"type":        "string",
 					"description": "a hostname (e.g. like google.com) or an ip v4 address (like 1.2.4.5)",
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
-			return "host is up", nil
+		func(args map[string]any) (any, error) {
+			return map[string]any{"result": "host is up"}, nil
 		},
 	)
 
@@ -379,21 +545,21 @@ func registerTools(registry *ToolRegistry) {
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
+		func(args map[string]any) (any, error) {
 			path, ok := args["path"].(string)
 			if !ok {
-				return "", fmt.Errorf("path must be a string")
+				return nil, fmt.Errorf("path must be a string")
 			}
 
 			// Resolve to absolute path for safety
 			absPath, err := filepath.Abs(path)
 			if err != nil {
-				return "", fmt.Errorf("invalid path: %w", err)
+				return nil, fmt.Errorf("invalid path: %w", err)
 			}
 
 			entries, err := os.ReadDir(absPath)
 			if err != nil {
-				return "", fmt.Errorf("cannot read directory: %w", err)
+				return nil, fmt.Errorf("cannot read directory: %w", err)
 			}
 
 			type FileInfo struct {
@@ -415,16 +581,11 @@ func registerTools(registry *ToolRegistry) {
 				})
 			}
 
-			result, err := json.Marshal(map[string]any{
+			return map[string]any{
 				"path":  absPath,
 				"count": len(files),
 				"files": files,
-			})
-			if err != nil {
-				return "", err
-			}
-
-			return string(result), nil
+			}, nil
 		},
 	)
 
@@ -445,10 +606,10 @@ func registerTools(registry *ToolRegistry) {
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
+		func(args map[string]any) (any, error) {
 			path, ok := args["path"].(string)
 			if !ok {
-				return "", fmt.Errorf("path must be a string")
+				return nil, fmt.Errorf("path must be a string")
 			}
 
 			maxBytes := int64(1024 * 1024) // 1MB default
@@ -459,22 +620,22 @@ func registerTools(registry *ToolRegistry) {
 			// Resolve to absolute path
 			absPath, err := filepath.Abs(path)
 			if err != nil {
-				return "", fmt.Errorf("invalid path: %w", err)
+				return nil, fmt.Errorf("invalid path: %w", err)
 			}
 
 			// Check if file exists and is a regular file
 			info, err := os.Stat(absPath)
 			if err != nil {
-				return "", fmt.Errorf("cannot access file: %w", err)
+				return nil, fmt.Errorf("cannot access file: %w", err)
 			}
 			if info.IsDir() {
-				return "", fmt.Errorf("path is a directory, not a file")
+				return nil, fmt.Errorf("path is a directory, not a file")
 			}
 
 			// Open and read file
 			file, err := os.Open(absPath)
 			if err != nil {
-				return "", fmt.Errorf("cannot open file: %w", err)
+				return nil, fmt.Errorf("cannot open file: %w", err)
 			}
 			defer file.Close()
 
@@ -482,21 +643,16 @@ func registerTools(registry *ToolRegistry) {
 			limitedReader := io.LimitReader(file, maxBytes)
 			content, err := io.ReadAll(limitedReader)
 			if err != nil {
-				return "", fmt.Errorf("cannot read file: %w", err)
+				return nil, fmt.Errorf("cannot read file: %w", err)
 			}
 
-			result, err := json.Marshal(map[string]any{
+			return map[string]any{
 				"path":      absPath,
 				"size":      info.Size(),
 				"read_size": len(content),
 				"content":   string(content),
 				"truncated": info.Size() > maxBytes,
-			})
-			if err != nil {
-				return "", err
-			}
-
-			return string(result), nil
+			}, nil
 		},
 	)
 
@@ -529,10 +685,10 @@ func registerTools(registry *ToolRegistry) {
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
+		func(args map[string]any) (any, error) {
 			pattern, ok := args["pattern"].(string)
 			if !ok || pattern == "" {
-				return "", fmt.Errorf("pattern must be a non-empty string")
+				return nil, fmt.Errorf("pattern must be a non-empty string")
 			}
 
 			searchPath := "."
@@ -651,10 +807,10 @@ func registerTools(registry *ToolRegistry) {
 			})
 
 			if err != nil {
-				return "", fmt.Errorf("search failed: %w", err)
+				return nil, fmt.Errorf("search failed: %w", err)
 			}
 
-			result, err := json.Marshal(map[string]any{
+			return map[string]any{
 				"pattern":        pattern,
 				"path":           searchPath,
 				"case_sensitive": caseSensitive,
@@ -662,12 +818,7 @@ func registerTools(registry *ToolRegistry) {
 				"match_count":    len(matches),
 				"truncated":      len(matches) >= maxResults,
 				"matches":        matches,
-			})
-			if err != nil {
-				return "", err
-			}
-
-			return string(result), nil
+			}, nil
 		},
 	)
 
@@ -696,15 +847,15 @@ func registerTools(registry *ToolRegistry) {
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
+		func(args map[string]any) (any, error) {
 			path, ok := args["path"].(string)
 			if !ok || path == "" {
-				return "", fmt.Errorf("path must be a non-empty string")
+				return nil, fmt.Errorf("path must be a non-empty string")
 			}
 
 			content, ok := args["content"].(string)
 			if !ok {
-				return "", fmt.Errorf("content must be a string")
+				return nil, fmt.Errorf("content must be a string")
 			}
 
 			overwrite := false
@@ -720,7 +871,7 @@ func registerTools(registry *ToolRegistry) {
 			// Resolve to absolute path
 			absPath, err := filepath.Abs(path)
 			if err != nil {
-				return "", fmt.Errorf("invalid path: %w", err)
+				return nil, fmt.Errorf("invalid path: %w", err)
 			}
 
 			// Check if file exists
@@ -729,32 +880,32 @@ func registerTools(registry *ToolRegistry) {
 			if err == nil {
 				fileExists = true
 				if existingInfo.IsDir() {
-					return "", fmt.Errorf("path is a directory, not a file")
+					return nil, fmt.Errorf("path is a directory, not a file")
 				}
 				if !overwrite {
-					return "", fmt.Errorf("file already exists (use overwrite=true to replace)")
+					return nil, fmt.Errorf("file already exists (use overwrite=true to replace)")
 				}
 			} else if !os.IsNotExist(err) {
-				return "", fmt.Errorf("cannot access path: %w", err)
+				return nil, fmt.Errorf("cannot access path: %w", err)
 			}
 
 			// Create parent directories if needed
 			if createDirs {
 				dir := filepath.Dir(absPath)
 				if err := os.MkdirAll(dir, 0755); err != nil {
-					return "", fmt.Errorf("cannot create directories: %w", err)
+					return nil, fmt.Errorf("cannot create directories: %w", err)
 				}
 			}
 
 			// Write the file
 			if err := os.WriteFile(absPath, []byte(content), 0644); err != nil {
-				return "", fmt.Errorf("cannot write file: %w", err)
+				return nil, fmt.Errorf("cannot write file: %w", err)
 			}
 
 			// Get final file info
 			finalInfo, err := os.Stat(absPath)
 			if err != nil {
-				return "", fmt.Errorf("file written but cannot stat: %w", err)
+				return nil, fmt.Errorf("file written but cannot stat: %w", err)
 			}
 
 			operation := "created"
@@ -762,17 +913,12 @@ func registerTools(registry *ToolRegistry) {
 				operation = "overwritten"
 			}
 
-			result, err := json.Marshal(map[string]any{
+			return map[string]any{
 				"path":      absPath,
 				"operation": operation,
 				"size":      finalInfo.Size(),
 				"success":   true,
-			})
-			if err != nil {
-				return "", err
-			}
-
-			return string(result), nil
+			}, nil
 		},
 	)
 
@@ -805,15 +951,15 @@ func registerTools(registry *ToolRegistry) {
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
+		func(args map[string]any) (any, error) {
 			path, ok := args["path"].(string)
 			if !ok || path == "" {
-				return "", fmt.Errorf("path must be a non-empty string")
+				return nil, fmt.Errorf("path must be a non-empty string")
 			}
 
 			content, ok := args["content"].(string)
 			if !ok {
-				return "", fmt.Errorf("content must be a string")
+				return nil, fmt.Errorf("content must be a string")
 			}
 
 			newlineBefore := true
@@ -834,7 +980,7 @@ func registerTools(registry *ToolRegistry) {
 			// Resolve to absolute path
 			absPath, err := filepath.Abs(path)
 			if err != nil {
-				return "", fmt.Errorf("invalid path: %w", err)
+				return nil, fmt.Errorf("invalid path: %w", err)
 			}
 
 			// Check if file exists
@@ -845,19 +991,19 @@ func registerTools(registry *ToolRegistry) {
 				fileExists = true
 				existingSize = existingInfo.Size()
 				if existingInfo.IsDir() {
-					return "", fmt.Errorf("path is a directory, not a file")
+					return nil, fmt.Errorf("path is a directory, not a file")
 				}
 			} else if !os.IsNotExist(err) {
-				return "", fmt.Errorf("cannot access path: %w", err)
+				return nil, fmt.Errorf("cannot access path: %w", err)
 			} else if !createIfMissing {
-				return "", fmt.Errorf("file does not exist and create_if_missing is false")
+				return nil, fmt.Errorf("file does not exist and create_if_missing is false")
 			}
 
 			// Create parent directories if needed
 			if createDirs {
 				dir := filepath.Dir(absPath)
 				if err := os.MkdirAll(dir, 0755); err != nil {
-					return "", fmt.Errorf("cannot create directories: %w", err)
+					return nil, fmt.Errorf("cannot create directories: %w", err)
 				}
 			}
 
@@ -871,20 +1017,20 @@ func registerTools(registry *ToolRegistry) {
 			// Open file for appending (create if doesn't exist)
 			file, err := os.OpenFile(absPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 			if err != nil {
-				return "", fmt.Errorf("cannot open file for appending: %w", err)
+				return nil, fmt.Errorf("cannot open file for appending: %w", err)
 			}
 			defer file.Close()
 
 			// Write the content
 			bytesWritten, err := file.WriteString(appendContent)
 			if err != nil {
-				return "", fmt.Errorf("cannot write to file: %w", err)
+				return nil, fmt.Errorf("cannot write to file: %w", err)
 			}
 
 			// Get final file info
 			finalInfo, err := os.Stat(absPath)
 			if err != nil {
-				return "", fmt.Errorf("file written but cannot stat: %w", err)
+				return nil, fmt.Errorf("file written but cannot stat: %w", err)
 			}
 
 			operation := "created"
@@ -892,19 +1038,62 @@ func registerTools(registry *ToolRegistry) {
 				operation = "appended"
 			}
 
-			result, err := json.Marshal(map[string]any{
+			return map[string]any{
 				"path":          absPath,
 				"operation":     operation,
 				"bytes_written": bytesWritten,
 				"size_before":   existingSize,
 				"size_after":    finalInfo.Size(),
 				"success":       true,
-			})
+			}, nil
+		},
+	)
+
+	registry.Register(
+		"view_image",
+		"Look at an image file (PNG, JPEG or GIF) on disk, e.g. to verify an image you just generated. The image is attached to the tool result.",
+		map[string]any{
+			"type":     "object",
+			"required": []string{"path"},
+			"properties": map[string]any{
+				"path": map[string]any{
+					"type":        "string",
+					"description": "The image file path",
+				},
+				"max_size": map[string]any{
+					"type":        "number",
+					"description": "Optional maximum width or height in pixels, larger images are downscaled (default: 1024)",
+				},
+			},
+		},
+		func(args map[string]any) (any, error) {
+			path, ok := args["path"].(string)
+			if !ok || path == "" {
+				return nil, fmt.Errorf("path must be a non-empty string")
+			}
+
+			maxSize := *imageMaxSize
+			if ms, ok := args["max_size"].(float64); ok {
+				maxSize = int(ms)
+			}
+
+			absPath, err := filepath.Abs(path)
 			if err != nil {
-				return "", err
+				return nil, fmt.Errorf("invalid path: %w", err)
 			}
 
-			return string(result), nil
+			encoded, width, height, err := loadImage(absPath, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			registry.Attach(encoded)
+
+			return map[string]any{
+				"path":     absPath,
+				"width":    width,
+				"height":   height,
+				"attached": true,
+			}, nil
 		},
 	)
 
@@ -929,10 +1118,10 @@ func registerTools(registry *ToolRegistry) {
 				},
 			},
 		},
-		func(args map[string]any) (string, error) {
+		func(args map[string]any) (any, error) {
 			command, ok := args["command"].(string)
 			if !ok || command == "" {
-				return "", fmt.Errorf("command must be a non-empty string")
+				return nil, fmt.Errorf("command must be a non-empty string")
 			}
 
 			workingDir := "."
@@ -952,22 +1141,29 @@ func registerTools(registry *ToolRegistry) {
 			}
 
 			if needsConfirm {
-				fmt.Printf("\n⚠️  The agent wants to run a command:\n")
-				fmt.Printf("   Command: %s\n", command)
-				fmt.Printf("   Working Dir: %s\n\n", workingDir)
-				fmt.Printf("Allow this command? [y/N]: ")
+				// The prompt goes to the terminal, since stdout may carry
+				// structured output and stdin the input of -stdin.
+				tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+				if err != nil {
+					return nil, fmt.Errorf("confirmation needs a terminal, run with -confirm=false to allow commands: %w", err)
+				}
+				defer tty.Close()
+				fmt.Fprintf(tty, "\n⚠️  The agent wants to run a command:\n")
+				fmt.Fprintf(tty, "   Command: %s\n", command)
+				fmt.Fprintf(tty, "   Working Dir: %s\n\n", workingDir)
+				fmt.Fprintf(tty, "Allow this command? [y/N]: ")
 
-				reader := bufio.NewReader(os.Stdin)
+				reader := bufio.NewReader(tty)
 				response, err := reader.ReadString('\n')
 				if err != nil {
-					return "", fmt.Errorf("confirmation failed: %w", err)
+					return nil, fmt.Errorf("confirmation failed: %w", err)
 				}
 
 				response = strings.TrimSpace(strings.ToLower(response))
 				if response != "y" && response != "yes" {
-					return "", fmt.Errorf("command execution denied by user")
+					return nil, fmt.Errorf("command execution denied by user")
 				}
-				fmt.Println()
+				fmt.Fprintln(tty)
 			}
 
 			// Create context with timeout
@@ -993,21 +1189,20 @@ func registerTools(registry *ToolRegistry) {
 				if exitError, ok := err.(*exec.ExitError); ok {
 					exitCode = exitError.ExitCode()
 				} else if ctx.Err() == context.DeadlineExceeded {
-					result, _ := json.Marshal(map[string]any{
+					return map[string]any{
 						"command":     command,
 						"stdout":      stdout.String(),
 						"stderr":      stderr.String(),
 						"exit_code":   -1,
 						"error":       "command timed out",
 						"duration_ms": duration.Milliseconds(),
-					})
-					return string(result), nil
+					}, nil
 				} else {
-					return "", fmt.Errorf("failed to execute command: %w", err)
+					return nil, fmt.Errorf("failed to execute command: %w", err)
 				}
 			}
 
-			result, err := json.Marshal(map[string]any{
+			fields := map[string]any{
 				"command":     command,
 				"working_dir": workingDir,
 				"stdout":      stdout.String(),
@@ -1015,12 +1210,11 @@ func registerTools(registry *ToolRegistry) {
 				"exit_code":   exitCode,
 				"duration_ms": duration.Milliseconds(),
 				"success":     exitCode == 0,
-			})
-			if err != nil {
-				return "", err
 			}
-
-			return string(result), nil
+			if *compactOutput {
+				compactRunOutput(registry, fields, command, stdout.String(), stderr.String(), exitCode)
+			}
+			return fields, nil
 		},
 	)
 
@@ -1062,8 +1256,27 @@ func isReadOnlyCommand(command string) bool {
 	return false
 }
 
-func runAgentLoop(client *LlmClient, model string, registry *ToolRegistry, userMessage string) error {
-	messages := []Message{
+// runAgentLoop runs the conversation until the model answers without tool
+// calls. The base request carries model and options, messages and tools are
+// filled in on every turn. Reasoning content is written to the transcript,
+// but only kept in the history with -keep-thinking.
+func runAgentLoop(client *LlmClient, registry *ToolRegistry, base ChatRequest, userMessage string, images []string, transcript *Transcript) error {
+	messages := initialMessages(userMessage, images)
+	if err := transcript.Write(messages...); err != nil {
+		return err
+	}
+	_, err := converse(client, registry, base, messages, transcript)
+	return err
+}
+
+// initialMessages returns the system prompt and the user message. The
+// system prompt is constant to keep the prompt prefix cacheable; dynamic
+// context is prepended to the user message instead.
+func initialMessages(userMessage string, images []string) []Message {
+	if *envContext {
+		userMessage = environmentContext(clock()) + "\n\n" + userMessage
+	}
+	return []Message{
 		{
 			Role:    "system",
 			Content: "You are a helpful assistant with access to tools. Use them when needed.",
@@ -1071,42 +1284,78 @@ func runAgentLoop(client *LlmClient, model string, registry *ToolRegistry, userM
 		{
 			Role:    "user",
 			Content: userMessage,
+			Images:  images,
 		},
 	}
+}
+
+// converse sends the messages to the model and executes requested tools until
+// the model answers without tool calls. It returns the history including the
+// final answer.
+func converse(client *LlmClient, registry *ToolRegistry, base ChatRequest, messages []Message, transcript *Transcript) ([]Message, error) {
 	maxIterations := 10
 	for i := 0; i < maxIterations; i++ {
-		req := ChatRequest{
-			Model:           model,
-			Messages:        messages,
-			Tools:           registry.GetTools(),
-			Stream:          false,
-			DebugRenderOnly: *debugRenderOnly,
-		}
+		req := buildRequest(base, registry.ToolsFor(messages), messages)
+		req.Stream = false
+		req.DebugRenderOnly = *debugRenderOnly
 		resp, err := client.Chat(req)
 		if err != nil {
-			return fmt.Errorf("chat error: %w", err)
+			return nil, fmt.Errorf("chat error: %w", err)
+		}
+		if err := transcript.Write(resp.Message); err != nil {
+			return nil, err
+		}
+		logThinking(resp.Message.Thinking)
+		if !*keepThinking {
+			resp.Message.Thinking = ""
+		}
+		// Only replies to requests with tools can hold a call in the text; a
+		// structured answer is never one.
+		if len(resp.Message.ToolCalls) == 0 && len(req.Tools) > 0 && len(req.Format) == 0 {
+			if tc, ok := registry.textToolCall(resp.Message.Content); ok {
+				log.Printf("found tool call in message content")
+				resp.Message.ToolCalls = []ToolCall{tc}
+			}
 		}
 		if len(resp.Message.ToolCalls) > 0 {
 			log.Printf("assistant wants to call %d tool(s)", len(resp.Message.ToolCalls))
+			// Repair before the message goes into the history, which must
+			// only contain valid calls.
+			repairErrs := make([]error, len(resp.Message.ToolCalls))
+			for i := range resp.Message.ToolCalls {
+				repairErrs[i] = registry.repairCall(&resp.Message.ToolCalls[i].Function)
+			}
 			messages = append(messages, resp.Message)
-			for _, tc := range resp.Message.ToolCalls {
+			for i, tc := range resp.Message.ToolCalls {
 				log.Printf("calling tool: %s", tc.Function.Name)
 				argsJSON, _ := json.Marshal(tc.Function.Arguments)
 				log.Printf("args: %s", string(argsJSON))
-				result, err := registry.Execute(tc.Function.Name, tc.Function.Arguments)
+				var result string
+				err := repairErrs[i]
+				if err == nil {
+					result, err = registry.Execute(tc.Function.Name, tc.Function.Arguments)
+				}
+				if errors.Is(err, ErrNotRecorded) {
+					return nil, err
+				}
 				if err != nil {
-					result = fmt.Sprintf(`{"error": "%s"}`, err.Error())
+					result = registry.ErrorResult(err)
 				}
 				log.Printf("    Result: %s", result)
-				messages = append(messages, Message{
+				msg := Message{
 					Role:    "tool",
 					Content: result,
-				})
+					Images:  registry.TakeAttachments(),
+				}
+				if err := transcript.Write(msg); err != nil {
+					return nil, err
+				}
+				messages = append(messages, msg)
 			}
 		} else {
 			log.Printf("assistant: %s", resp.Message.Content)
-			return nil
+			return append(messages, resp.Message), nil
 		}
 	}
-	return fmt.Errorf("max iterations reached")
+	return nil, fmt.Errorf("max iterations reached")
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/miku/unplugged/scratch/one/ollamatest"
+)
+
+// newTestClient returns a client for a fake server that fails fast.
+func newTestClient(srv *ollamatest.Server) *LlmClient {
+	client := NewLlmClient(srv.URL)
+	client.retries = 0
+	return client
+}
+
+func TestChatErrors(t *testing.T) {
+	var cases = []struct {
+		name string
+		turn ollamatest.Turn
+		err  string
+	}{
+		{"server error", ollamatest.Turn{Status: 500, Body: `{"error":"model runner crashed"}`}, "unexpected status 500"},
+		{"bad request", ollamatest.Turn{Status: 400, Body: `{"error":"invalid options"}`}, "invalid options"},
+		{"malformed body", ollamatest.Turn{Body: `{"message": {"role": "assistant", "content": "cut`}, "decode response"},
+		{"wrong type", ollamatest.Turn{Body: `{"message": "hello"}`}, "decode response"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := ollamatest.NewServer(c.turn)
+			defer srv.Close()
+			resp, err := newTestClient(srv).Chat(ChatRequest{
+				Model:    "qwen3-vl:latest",
+				Messages: []Message{{Role: "user", Content: "hello"}},
+			})
+			if err == nil {
+				t.Fatalf("got response %+v, want error", resp)
+			}
+			if !strings.Contains(err.Error(), c.err) {
+				t.Errorf("got error %q, want %q", err, c.err)
+			}
+			srv.AssertDone(t)
+		})
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	srv := ollamatest.NewServer(
+		ollamatest.Turn{Thinking: "two and two", Content: "2 + 2 is 4", PromptEvalCount: 12, EvalCount: 5},
+		ollamatest.Turn{ToolCalls: []ollamatest.ToolCall{{Name: "echo", Arguments: map[string]any{"text": "ping"}}}},
+	)
+	defer srv.Close()
+	client := newTestClient(srv)
+	req := ChatRequest{Model: "qwen3-vl:latest", Messages: []Message{{Role: "user", Content: "2 + 2?"}}, Stream: true}
+	resp, err := client.Chat(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := resp.Message
+	if m.Role != "assistant" || m.Content != "2 + 2 is 4" || m.Thinking != "two and two" {
+		t.Errorf("got message %+v", m)
+	}
+	if !resp.Done || resp.PromptEvalCount != 12 || resp.EvalCount != 5 {
+		t.Errorf("got done %v, counts %d and %d", resp.Done, resp.PromptEvalCount, resp.EvalCount)
+	}
+	resp, err = client.Chat(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := resp.Message.ToolCalls; len(calls) != 1 || calls[0].Function.Name != "echo" || calls[0].Function.Arguments["text"] != "ping" {
+		t.Errorf("got tool calls %+v", calls)
+	}
+	for i, r := range srv.ChatRequests() {
+		if !r.Stream {
+			t.Errorf("request %d was not streamed", i)
+		}
+	}
+	srv.AssertDone(t)
+}
+
+func TestChatStreamErrors(t *testing.T) {
+	var cases = []struct {
+		name string
+		body string
+		err  string
+	}{
+		{"cut off", `{"message": {"role": "assistant", "content": "2 + "}, "done": false}` + "\n", "stream ended before done"},
+		{"error chunk", `{"message": {"role": "assistant", "content": "2"}, "done": false}` + "\n" + `{"error": "model runner crashed"}` + "\n", "model runner crashed"},
+		{"malformed chunk", `{"message": {"role": "assistant", "content": "2`, "decode response"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := ollamatest.NewServer(ollamatest.Turn{Body: c.body})
+			defer srv.Close()
+			_, err := newTestClient(srv).Chat(ChatRequest{
+				Model:    "qwen3-vl:latest",
+				Messages: []Message{{Role: "user", Content: "2 + 2?"}},
+				Stream:   true,
+			})
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("got error %v, want %q", err, c.err)
+			}
+			srv.AssertDone(t)
+		})
+	}
+}
+
+func echoRegistry() *ToolRegistry {
+	registry := NewToolRegistry()
+	registry.Register("echo", "Echo a text", map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"text": map[string]any{"type": "string"}},
+	}, func(args map[string]any) (any, error) {
+		return map[string]any{"echo": args["text"]}, nil
+	})
+	return registry
+}
+
+func TestConverseToolCallThenAnswer(t *testing.T) {
+	srv := ollamatest.NewServer(
+		ollamatest.Turn{ToolCalls: []ollamatest.ToolCall{{Name: "echo", Arguments: map[string]any{"text": "ping"}}}},
+		ollamatest.Turn{Content: "the tool said ping"},
+	)
+	defer srv.Close()
+	messages := []Message{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "echo ping"},
+	}
+	history, err := converse(newTestClient(srv), echoRegistry(), ChatRequest{Model: "qwen3-vl:latest"}, messages, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roles []string
+	for _, m := range history {
+		roles = append(roles, m.Role)
+	}
+	if got, want := strings.Join(roles, " "), "system user assistant tool assistant"; got != want {
+		t.Errorf("history roles %q, want %q", got, want)
+	}
+	if got := history[len(history)-1].Content; got != "the tool said ping" {
+		t.Errorf("final answer %q", got)
+	}
+	srv.AssertDone(t)
+	srv.AssertToolsOffered(t, 0, "echo")
+	srv.AssertToolsOffered(t, 1, "echo")
+	srv.AssertMessageCount(t, 0, 2)
+	srv.AssertMessageCount(t, 1, 4)
+	srv.AssertMessage(t, 1, 2, "assistant", "")
+	srv.AssertMessage(t, 1, -1, "tool", `"echo":"ping"`)
+	for i, req := range srv.ChatRequests() {
+		if req.Stream || req.DebugRenderOnly {
+			t.Errorf("request %d: stream=%v, debug render=%v", i, req.Stream, req.DebugRenderOnly)
+		}
+	}
+}
+
+func TestConverseJSONAnswerWithName(t *testing.T) {
+	srv := ollamatest.NewServer(
+		ollamatest.Turn{Content: `{"name": "echo", "age": 3}`},
+		ollamatest.Turn{Content: `{"name": "echo", "arguments": {"text": "Alice"}}`},
+	)
+	defer srv.Close()
+	// Without format, a JSON answer naming a tool but without arguments is
+	// not a call.
+	messages := []Message{{Role: "user", Content: "who is it?"}}
+	history, err := converse(newTestClient(srv), echoRegistry(), ChatRequest{Model: "qwen3-vl:latest"}, messages, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := history[len(history)-1]; got.Role != "assistant" || got.Content != `{"name": "echo", "age": 3}` {
+		t.Errorf("final message %+v", got)
+	}
+	// With a format, even an answer in the shape of a call is the answer.
+	base := ChatRequest{Model: "qwen3-vl:latest", Format: json.RawMessage(`"json"`)}
+	var out bytes.Buffer
+	if err := runStructured(newTestClient(srv), echoRegistry(), base, nil, "who is it?", nil, nil, 0, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), `{"name":"echo","arguments":{"text":"Alice"}}`+"\n"; got != want {
+		t.Errorf("output %q, want %q", got, want)
+	}
+	srv.AssertDone(t)
+}
+
+func TestConverseMaxIterations(t *testing.T) {
+	var turns []ollamatest.Turn
+	for range 10 {
+		turns = append(turns, ollamatest.Turn{ToolCalls: []ollamatest.ToolCall{{Name: "echo", Arguments: map[string]any{"text": "again"}}}})
+	}
+	srv := ollamatest.NewServer(turns...)
+	defer srv.Close()
+	messages := []Message{{Role: "user", Content: "loop forever"}}
+	_, err := converse(newTestClient(srv), echoRegistry(), ChatRequest{Model: "qwen3-vl:latest"}, messages, nil)
+	if err == nil || !strings.Contains(err.Error(), "max iterations") {
+		t.Fatalf("got %v, want max iterations error", err)
+	}
+	srv.AssertDone(t)
+	srv.AssertMessageCount(t, 9, 19)
+}
+
+func TestConverseDebugRenderOnly(t *testing.T) {
+	saved := *debugRenderOnly
+	*debugRenderOnly = true
+	defer func() { *debugRenderOnly = saved }()
+	srv := ollamatest.NewServer()
+	defer srv.Close()
+	client := newTestClient(srv)
+	var out bytes.Buffer
+	client.renderOut = &out
+	messages := []Message{{Role: "user", Content: "what is the weather?"}}
+	history, err := converse(client, echoRegistry(), ChatRequest{Model: "qwen3-vl:latest"}, messages, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The loop stops after the render, no turn is used.
+	if len(history) != 2 {
+		t.Errorf("got %d messages, want 2", len(history))
+	}
+	if n := len(srv.ChatRequests()); n != 0 {
+		t.Errorf("got %d chat requests, want only the render request", n)
+	}
+	var rendered struct {
+		DebugInfo struct {
+			RenderedTemplate string `json:"rendered_template"`
+		} `json:"debug_info"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &rendered); err != nil {
+		t.Fatalf("rendered output %q: %v", out.String(), err)
+	}
+	for _, s := range []string{"<tool>echo</tool>", "<|user|>what is the weather?"} {
+		if !strings.Contains(rendered.DebugInfo.RenderedTemplate, s) {
+			t.Errorf("rendered template %q does not contain %q", rendered.DebugInfo.RenderedTemplate, s)
+		}
+	}
+	srv.AssertDone(t)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// TurnMetrics are the performance figures of a single chat request, as
+// reported by ollama.
+type TurnMetrics struct {
+	PromptEvalCount    int           `json:"prompt_eval_count"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration"`
+	EvalCount          int           `json:"eval_count"`
+	EvalDuration       time.Duration `json:"eval_duration"`
+	LoadDuration       time.Duration `json:"load_duration"`
+	TotalDuration      time.Duration `json:"total_duration"`
+	Wall               time.Duration `json:"wall"`
+	RequestBytes       int           `json:"request_bytes"`
+	// PromptTokens is the estimated size of the whole prompt, CachedTokens
+	// the estimated part of it served from the prefix cache.
+	PromptTokens int    `json:"prompt_tokens"`
+	CachedTokens int    `json:"cached_tokens"`
+	PrefixChange string `json:"prefix_change,omitempty"`
+}
+
+// ToolMetrics aggregate the executions of one tool.
+type ToolMetrics struct {
+	Calls       int           `json:"calls"`
+	Errors      int           `json:"errors"`
+	Duration    time.Duration `json:"duration"`
+	ResultBytes int           `json:"result_bytes"`
+}
+
+// Summary is the performance summary of a run, suitable for comparing models.
+type Summary struct {
+	Model              string                  `json:"model"`
+	Started            time.Time               `json:"started"`
+	Wall               time.Duration           `json:"wall"`
+	Turns              int                     `json:"turns"`
+	PromptTokens       int                     `json:"prompt_tokens"`
+	EvalTokens         int                     `json:"eval_tokens"`
+	PromptTokensPerSec float64                 `json:"prompt_tokens_per_sec"`
+	EvalTokensPerSec   float64                 `json:"eval_tokens_per_sec"`
+	LoadDuration       time.Duration           `json:"load_duration"`
+	ModelDuration      time.Duration           `json:"model_duration"`
+	ToolDuration       time.Duration           `json:"tool_duration"`
+	ContextBytesFirst  int                     `json:"context_bytes_first"`
+	ContextBytesLast   int                     `json:"context_bytes_last"`
+	ContextGrowth      int                     `json:"context_growth"`
+	PrefixCacheHit     float64                 `json:"prefix_cache_hit"`
+	PerTurn            []TurnMetrics           `json:"per_turn"`
+	PerTool            map[string]*ToolMetrics `json:"per_tool"`
+}
+
+// Metrics collects per turn and per tool figures of a run. A nil Metrics
+// records nothing.
+type Metrics struct {
+	mu      sync.Mutex
+	started time.Time
+	model   string
+	turns   []TurnMetrics
+	tools   map[string]*ToolMetrics
+	prev    *ChatRequest
+	perByte float64 // tokens per request byte, calibrated on the first turn
+}
+
+// NewMetrics starts collecting metrics for a run.
+func NewMetrics(model string) *Metrics {
+	return &Metrics{started: time.Now(), model: model, tools: make(map[string]*ToolMetrics)}
+}
+
+// Chat records a chat request and its response.
+func (m *Metrics) Chat(req ChatRequest, resp *ChatResponse, wall time.Duration) {
+	if m == nil || resp == nil {
+		return
+	}
+	b, _ := json.Marshal(req)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := TurnMetrics{
+		PromptEvalCount:    resp.PromptEvalCount,
+		PromptEvalDuration: resp.PromptEvalDuration,
+		EvalCount:          resp.EvalCount,
+		EvalDuration:       resp.EvalDuration,
+		LoadDuration:       resp.LoadDuration,
+		TotalDuration:      resp.TotalDuration,
+		Wall:               wall,
+		RequestBytes:       len(b),
+	}
+	m.estimateCache(&t, req)
+	m.turns = append(m.turns, t)
+}
+
+// estimateCache infers prefix cache reuse from prompt_eval_count, which
+// only counts prompt tokens that had to be evaluated. The first turn is
+// assumed to be uncached and calibrates tokens per byte. Every later prompt
+// consists of the previous prompt, the previous answer and new messages;
+// whatever was not evaluated came from the cache.
+func (m *Metrics) estimateCache(t *TurnMetrics, req ChatRequest) {
+	defer func() { m.prev = &req }()
+	if len(m.turns) == 0 || m.perByte == 0 {
+		t.PromptTokens = t.PromptEvalCount
+		if t.RequestBytes > 0 {
+			m.perByte = float64(t.PromptEvalCount) / float64(t.RequestBytes)
+		}
+		return
+	}
+	last := m.turns[len(m.turns)-1]
+	grown := int(float64(t.RequestBytes-last.RequestBytes) * m.perByte)
+	t.PromptTokens = max(last.PromptTokens+last.EvalCount+grown, t.PromptEvalCount)
+	t.CachedTokens = t.PromptTokens - t.PromptEvalCount
+	t.PrefixChange = prefixChange(*m.prev, req)
+	if t.PrefixChange != "" {
+		log.Printf("prefix cache: %s, the prompt is evaluated from there on", t.PrefixChange)
+	}
+}
+
+// Tool records a tool execution.
+func (m *Metrics) Tool(name string, took time.Duration, result string, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tm, ok := m.tools[name]
+	if !ok {
+		tm = &ToolMetrics{}
+		m.tools[name] = tm
+	}
+	tm.Calls++
+	tm.Duration += took
+	tm.ResultBytes += len(result)
+	if err != nil {
+		tm.Errors++
+	}
+}
+
+func perSecond(count int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(count) / d.Seconds()
+}
+
+// Summary aggregates the collected metrics.
+func (m *Metrics) Summary() Summary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := Summary{
+		Model:   m.model,
+		Started: m.started,
+		Wall:    time.Since(m.started),
+		Turns:   len(m.turns),
+		PerTurn: append([]TurnMetrics(nil), m.turns...),
+		PerTool: make(map[string]*ToolMetrics),
+	}
+	var (
+		promptDuration, evalDuration time.Duration
+		cached, reusable             int
+	)
+	for i, t := range m.turns {
+		if i > 0 {
+			cached += t.CachedTokens
+			reusable += t.PromptTokens
+		}
+		s.PromptTokens += t.PromptEvalCount
+		s.EvalTokens += t.EvalCount
+		promptDuration += t.PromptEvalDuration
+		evalDuration += t.EvalDuration
+		s.LoadDuration += t.LoadDuration
+		s.ModelDuration += t.Wall
+	}
+	s.PromptTokensPerSec = perSecond(s.PromptTokens, promptDuration)
+	s.EvalTokensPerSec = perSecond(s.EvalTokens, evalDuration)
+	if reusable > 0 {
+		s.PrefixCacheHit = float64(cached) / float64(reusable)
+	}
+	for name, tm := range m.tools {
+		c := *tm
+		s.PerTool[name] = &c
+		s.ToolDuration += tm.Duration
+	}
+	if len(m.turns) > 0 {
+		s.ContextBytesFirst = m.turns[0].RequestBytes
+		s.ContextBytesLast = m.turns[len(m.turns)-1].RequestBytes
+		s.ContextGrowth = s.ContextBytesLast - s.ContextBytesFirst
+	}
+	return s
+}
+
+// WriteTable writes a human readable summary.
+func (s Summary) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "turn\tprompt tok\t~cached\teval tok\teval tok/s\tload\tmodel\trequest bytes\t")
+	for i, t := range s.PerTurn {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%.1f\t%v\t%v\t%d\t\n", i+1, t.PromptEvalCount, t.CachedTokens, t.EvalCount,
+			perSecond(t.EvalCount, t.EvalDuration), t.LoadDuration.Round(time.Millisecond),
+			t.Wall.Round(time.Millisecond), t.RequestBytes)
+	}
+	fmt.Fprintf(tw, "total\t%d\t%.0f%%\t%d\t%.1f\t%v\t%v\t%+d\t\n", s.PromptTokens, 100*s.PrefixCacheHit,
+		s.EvalTokens, s.EvalTokensPerSec, s.LoadDuration.Round(time.Millisecond),
+		s.ModelDuration.Round(time.Millisecond), s.ContextGrowth)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(s.PerTool) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "tool\tcalls\terrors\ttime\tresult bytes\t")
+		names := make([]string, 0, len(s.PerTool))
+		for name := range s.PerTool {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			tm := s.PerTool[name]
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%v\t%d\t\n", name, tm.Calls, tm.Errors,
+				tm.Duration.Round(time.Millisecond), tm.ResultBytes)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%s: %d turn(s) in %v, model %v, tools %v, prompt %.1f tok/s, eval %.1f tok/s, ~%.0f%% prefix cache hits\n",
+		s.Model, s.Turns, s.Wall.Round(time.Millisecond), s.ModelDuration.Round(time.Millisecond),
+		s.ToolDuration.Round(time.Millisecond), s.PromptTokensPerSec, s.EvalTokensPerSec, 100*s.PrefixCacheHit)
+	return err
+}
+
+// AppendJSON appends the summary as a JSON line to a file, so runs with
+// different models can be compared.
+func (s Summary) AppendJSON(filename string) error {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open stats file: %w", err)
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(s); err != nil {
+		return fmt.Errorf("write stats: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,528 @@
+// Package ollamatest provides an in-process fake ollama server for tests.
+//
+// The server answers /api/chat from a script of turns, one turn per chat
+// request, streamed as NDJSON chunks if the request asks for it. It serves
+// /api/tags and /api/show from a configurable list of models. /api/embed
+// returns deterministic bag of words vectors, so texts sharing words are
+// similar. All requests are recorded for later assertions.
+//
+//	srv := ollamatest.NewServer(
+//		ollamatest.Turn{ToolCalls: []ollamatest.ToolCall{{Name: "calculate", Arguments: map[string]any{"expression": "2 + 2"}}}},
+//		ollamatest.Turn{Content: "2 + 2 is 4"},
+//	)
+//	defer srv.Close()
+//	client := NewLlmClient(srv.URL)
+//	... run the agent loop ...
+//	srv.AssertToolsOffered(t, 0, "calculate")
+//	srv.AssertMessage(t, 1, -1, "tool", `"result":"4"`)
+//	srv.AssertDone(t)
+package ollamatest
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode"
+)
+
+// Message is a chat message as sent on the wire.
+type Message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Thinking  string     `json:"thinking,omitempty"`
+	Images    []string   `json:"images,omitempty"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+}
+
+type toolCall struct {
+	Function struct {
+		Name      string `json:"name"`
+		Arguments any    `json:"arguments"`
+	} `json:"function"`
+}
+
+// Tool is a tool definition as sent on the wire.
+type Tool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+// ChatRequest is a received chat request.
+type ChatRequest struct {
+	Model           string          `json:"model"`
+	Messages        []Message       `json:"messages"`
+	Tools           []Tool          `json:"tools"`
+	Stream          bool            `json:"stream"`
+	Format          json.RawMessage `json:"format"`
+	Options         map[string]any  `json:"options"`
+	KeepAlive       any             `json:"keep_alive"`
+	Think           any             `json:"think"`
+	DebugRenderOnly bool            `json:"_debug_render_only"`
+}
+
+// ToolNames returns the names of the offered tools.
+func (r ChatRequest) ToolNames() []string {
+	var names []string
+	for _, t := range r.Tools {
+		names = append(names, t.Function.Name)
+	}
+	return names
+}
+
+// Request is any request received by the server.
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// ToolCall is a tool call the model should emit.
+type ToolCall struct {
+	Name      string
+	Arguments map[string]any
+	// RawArguments, if set, is sent as a JSON string instead of Arguments,
+	// as some models do, e.g. `{'city': 'Halle',}`.
+	RawArguments string
+}
+
+// Turn scripts the answer to one chat request.
+type Turn struct {
+	Content   string
+	Thinking  string
+	ToolCalls []ToolCall
+	// Status, if set, is returned together with Body instead of an answer,
+	// e.g. http.StatusInternalServerError.
+	Status int
+	// Body, if set, is written verbatim, e.g. to test malformed responses.
+	Body string
+	// Delay is waited before answering.
+	Delay time.Duration
+	// PromptEvalCount and EvalCount are reported as token counts.
+	PromptEvalCount int
+	EvalCount       int
+}
+
+// Model describes a model served by /api/tags and /api/show.
+type Model struct {
+	Name          string
+	Family        string
+	Capabilities  []string // e.g. "completion", "tools", "vision", "thinking"
+	ContextLength int
+	NumCtx        int // num_ctx parameter from the modelfile, 0 if unset
+}
+
+// DefaultModel is served if no models are configured.
+var DefaultModel = Model{
+	Name:          "qwen3-vl:latest",
+	Family:        "qwen3vl",
+	Capabilities:  []string{"completion", "tools", "vision", "thinking"},
+	ContextLength: 262144,
+}
+
+// Server is a fake ollama server.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	models   []Model
+	turns    []Turn
+	next     int
+	requests []Request
+	chats    []ChatRequest
+	errors   []string
+}
+
+// NewServer starts a server answering chat requests with the given turns.
+func NewServer(turns ...Turn) *Server {
+	s := &Server{turns: turns}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/chat", s.handleChat)
+	mux.HandleFunc("/api/tags", s.handleTags)
+	mux.HandleFunc("/api/show", s.handleShow)
+	mux.HandleFunc("/api/embed", s.handleEmbed)
+	s.Server = httptest.NewServer(s.record(mux))
+	return s
+}
+
+// Script appends turns to the script.
+func (s *Server) Script(turns ...Turn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turns = append(s.turns, turns...)
+}
+
+// SetModels replaces the models served by /api/tags and /api/show.
+func (s *Server) SetModels(models ...Model) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models = models
+}
+
+// Requests returns all requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// ChatRequests returns all chat requests received so far, excluding debug
+// render requests.
+func (s *Server) ChatRequests() []ChatRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.chats)
+}
+
+// Remaining returns the number of scripted turns not yet served.
+func (s *Server) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.turns) - s.next
+}
+
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Body: body})
+		s.mu.Unlock()
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) fail(w http.ResponseWriter, status int, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	s.mu.Lock()
+	s.errors = append(s.errors, msg)
+	s.mu.Unlock()
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) lookup(name string) (Model, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.models) == 0 {
+		m := DefaultModel
+		m.Name = name
+		return m, true
+	}
+	for _, m := range s.models {
+		if m.Name == name || m.Name == name+":latest" {
+			return m, true
+		}
+	}
+	return Model{}, false
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	models := s.models
+	if len(models) == 0 {
+		models = []Model{DefaultModel}
+	}
+	s.mu.Unlock()
+	var list []map[string]any
+	for _, m := range models {
+		list = append(list, map[string]any{
+			"name":    m.Name,
+			"model":   m.Name,
+			"size":    1 << 30,
+			"details": map[string]any{"family": m.Family, "parameter_size": "4B", "quantization_level": "Q4_K_M"},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"models": list})
+}
+
+func (s *Server) handleShow(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, http.StatusBadRequest, "invalid show request: %v", err)
+		return
+	}
+	name := cmp.Or(req.Model, req.Name)
+	m, ok := s.lookup(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("model '%s' not found", name)})
+		return
+	}
+	var params string
+	if m.NumCtx > 0 {
+		params = fmt.Sprintf("num_ctx                        %d", m.NumCtx)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"parameters":   params,
+		"details":      map[string]any{"family": m.Family},
+		"model_info":   map[string]any{"general.architecture": m.Family, m.Family + ".context_length": m.ContextLength},
+		"capabilities": m.Capabilities,
+	})
+}
+
+func (s *Server) handleEmbed(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string `json:"model"`
+		Input any    `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, http.StatusBadRequest, "invalid embed request: %v", err)
+		return
+	}
+	var inputs []string
+	switch v := req.Input.(type) {
+	case string:
+		inputs = []string{v}
+	case []any:
+		for _, x := range v {
+			s, _ := x.(string)
+			inputs = append(inputs, s)
+		}
+	}
+	var embeddings [][]float64
+	for _, in := range inputs {
+		embeddings = append(embeddings, Embedding(in))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"model": req.Model, "embeddings": embeddings})
+}
+
+// EmbeddingSize is the dimension of the fake embeddings.
+const EmbeddingSize = 64
+
+// Embedding returns a normalized bag of words vector for a text.
+func Embedding(text string) []float64 {
+	v := make([]float64, EmbeddingSize)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		v[h.Sum32()%EmbeddingSize]++
+	}
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range v {
+			v[i] /= norm
+		}
+	}
+	return v
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.fail(w, http.StatusBadRequest, "invalid chat request: %v", err)
+		return
+	}
+	if _, ok := s.lookup(req.Model); !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+	if req.DebugRenderOnly {
+		s.renderOnly(w, req)
+		return
+	}
+	s.mu.Lock()
+	s.chats = append(s.chats, req)
+	if s.next >= len(s.turns) {
+		s.mu.Unlock()
+		s.fail(w, http.StatusInternalServerError, "no scripted turn left for chat request %d", len(s.chats))
+		return
+	}
+	turn := s.turns[s.next]
+	s.next++
+	s.mu.Unlock()
+
+	if turn.Delay > 0 {
+		time.Sleep(turn.Delay)
+	}
+	if turn.Status != 0 || turn.Body != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(cmp.Or(turn.Status, http.StatusOK))
+		io.WriteString(w, turn.Body)
+		return
+	}
+	msg := Message{Role: "assistant", Content: turn.Content, Thinking: turn.Thinking}
+	for _, tc := range turn.ToolCalls {
+		var call toolCall
+		call.Function.Name = tc.Name
+		call.Function.Arguments = tc.Arguments
+		if tc.RawArguments != "" {
+			call.Function.Arguments = tc.RawArguments
+		}
+		msg.ToolCalls = append(msg.ToolCalls, call)
+	}
+	final := map[string]any{
+		"model":                req.Model,
+		"created_at":           time.Now().UTC().Format(time.RFC3339Nano),
+		"message":              msg,
+		"done":                 true,
+		"done_reason":          "stop",
+		"total_duration":       int64(time.Second),
+		"load_duration":        int64(10 * time.Millisecond),
+		"prompt_eval_count":    turn.PromptEvalCount,
+		"prompt_eval_duration": int64(100 * time.Millisecond),
+		"eval_count":           turn.EvalCount,
+		"eval_duration":        int64(800 * time.Millisecond),
+	}
+	if req.Stream {
+		stream(w, req.Model, msg, final)
+		return
+	}
+	writeJSON(w, http.StatusOK, final)
+}
+
+// stream writes a message as ollama streams it, one JSON object per line:
+// the thinking and the content word by word, the tool calls in one chunk,
+// and a final chunk with an empty message, done and the counts.
+func stream(w http.ResponseWriter, model string, msg Message, final map[string]any) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(m Message) {
+		enc.Encode(map[string]any{
+			"model":      model,
+			"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+			"message":    m,
+			"done":       false,
+		})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	for _, part := range strings.SplitAfter(msg.Thinking, " ") {
+		if part != "" {
+			send(Message{Role: "assistant", Thinking: part})
+		}
+	}
+	for _, part := range strings.SplitAfter(msg.Content, " ") {
+		if part != "" {
+			send(Message{Role: "assistant", Content: part})
+		}
+	}
+	if len(msg.ToolCalls) > 0 {
+		send(Message{Role: "assistant", ToolCalls: msg.ToolCalls})
+	}
+	final["message"] = Message{Role: "assistant"}
+	enc.Encode(final)
+}
+
+// renderOnly answers a debug render request with a simple rendering of the
+// messages, without consuming a turn.
+func (s *Server) renderOnly(w http.ResponseWriter, req ChatRequest) {
+	var (
+		sb     strings.Builder
+		images int
+	)
+	for _, t := range req.Tools {
+		fmt.Fprintf(&sb, "<tool>%s</tool>\n", t.Function.Name)
+	}
+	for _, m := range req.Messages {
+		fmt.Fprintf(&sb, "<|%s|>%s\n", m.Role, m.Content)
+		images += len(m.Images)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"model":      req.Model,
+		"debug_info": map[string]any{"rendered_template": sb.String(), "image_count": images},
+	})
+}
+
+// AssertDone fails the test if scripted turns were left over or the server
+// answered requests with errors.
+func (s *Server) AssertDone(t testing.TB) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.errors {
+		t.Errorf("ollamatest: %s", e)
+	}
+	if n := len(s.turns) - s.next; n > 0 {
+		t.Errorf("ollamatest: %d scripted turn(s) not used", n)
+	}
+}
+
+// AssertToolsOffered fails the test if chat request i did not offer exactly
+// the named tools, in any order.
+func (s *Server) AssertToolsOffered(t testing.TB, i int, names ...string) {
+	t.Helper()
+	req, ok := s.chat(t, i)
+	if !ok {
+		return
+	}
+	got, want := req.ToolNames(), slices.Clone(names)
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("ollamatest: request %d offered tools %v, want %v", i, got, want)
+	}
+}
+
+// AssertMessage fails the test if message j of chat request i does not have
+// the role or does not contain the substring. Negative j count from the end.
+func (s *Server) AssertMessage(t testing.TB, i, j int, role, contains string) {
+	t.Helper()
+	req, ok := s.chat(t, i)
+	if !ok {
+		return
+	}
+	if j < 0 {
+		j += len(req.Messages)
+	}
+	if j < 0 || j >= len(req.Messages) {
+		t.Errorf("ollamatest: request %d has %d messages, no message %d", i, len(req.Messages), j)
+		return
+	}
+	m := req.Messages[j]
+	if m.Role != role {
+		t.Errorf("ollamatest: request %d message %d has role %q, want %q", i, j, m.Role, role)
+	}
+	if !strings.Contains(m.Content, contains) {
+		t.Errorf("ollamatest: request %d message %d content %q does not contain %q", i, j, m.Content, contains)
+	}
+}
+
+// AssertMessageCount fails the test if chat request i does not carry n
+// messages.
+func (s *Server) AssertMessageCount(t testing.TB, i, n int) {
+	t.Helper()
+	if req, ok := s.chat(t, i); ok && len(req.Messages) != n {
+		t.Errorf("ollamatest: request %d has %d messages, want %d", i, len(req.Messages), n)
+	}
+}
+
+func (s *Server) chat(t testing.TB, i int) (ChatRequest, bool) {
+	t.Helper()
+	chats := s.ChatRequests()
+	if i < 0 || i >= len(chats) {
+		t.Errorf("ollamatest: got %d chat requests, no request %d", len(chats), i)
+		return ChatRequest{}, false
+	}
+	return chats[i], true
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultNumCtx is the context window ollama uses when neither the request
+// nor the modelfile sets num_ctx.
+const defaultNumCtx = 4096
+
+// ModelConfig holds request settings passed through to ollama, cf.
+// https://github.com/ollama/ollama/blob/main/docs/modelfile.md#valid-parameters-and-values
+type ModelConfig struct {
+	Options   map[string]any `json:"options,omitempty"`
+	KeepAlive string         `json:"keep_alive,omitempty"`
+}
+
+// Config is the content of the file given with -c. Top level settings apply
+// to all models, entries in models override them for a single model, e.g.
+//
+//	{
+//	  "options": {"temperature": 0, "seed": 42},
+//	  "models": {"qwen3-vl:latest": {"options": {"num_ctx": 16384}, "keep_alive": "1h"}}
+//	}
+type Config struct {
+	ModelConfig
+	Models map[string]ModelConfig `json:"models,omitempty"`
+}
+
+// optionsFlag collects repeated key=value flags. Values are parsed as JSON
+// if possible, so numbers and booleans keep their type.
+type optionsFlag map[string]any
+
+func (f optionsFlag) String() string {
+	var parts []string
+	for _, k := range slices.Sorted(maps.Keys(f)) {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, f[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f optionsFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	var value any
+	if err := json.Unmarshal([]byte(v), &value); err != nil {
+		value = v
+	}
+	f[k] = value
+	return nil
+}
+
+// resolveModelConfig merges settings for a model, in increasing order of
+// precedence: config file, model section of the config file, flags.
+func resolveModelConfig(filename, model string) (ModelConfig, error) {
+	result := ModelConfig{Options: make(map[string]any)}
+	if filename != "" {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return result, fmt.Errorf("read config: %w", err)
+		}
+		var config Config
+		if err := json.Unmarshal(b, &config); err != nil {
+			return result, fmt.Errorf("parse config %s: %w", filename, err)
+		}
+		maps.Copy(result.Options, config.Options)
+		result.KeepAlive = config.KeepAlive
+		if mc, ok := config.Models[model]; ok {
+			maps.Copy(result.Options, mc.Options)
+			if mc.KeepAlive != "" {
+				result.KeepAlive = mc.KeepAlive
+			}
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "temperature":
+			result.Options["temperature"] = *temperature
+		case "seed":
+			result.Options["seed"] = *seed
+		case "num-ctx":
+			result.Options["num_ctx"] = *numCtx
+		case "keep-alive":
+			result.KeepAlive = *keepAlive
+		}
+	})
+	maps.Copy(result.Options, extraOptions)
+	return result, nil
+}
+
+// ShowResponse is the subset of the /api/show response we use.
+type ShowResponse struct {
+	Parameters   string         `json:"parameters"`
+	ModelInfo    map[string]any `json:"model_info"`
+	Capabilities []string       `json:"capabilities"`
+}
+
+// ContextLength returns the trained context length of the model, or 0.
+func (r *ShowResponse) ContextLength() int {
+	for k, v := range r.ModelInfo {
+		if !strings.HasSuffix(k, ".context_length") {
+			continue
+		}
+		if f, ok := v.(float64); ok {
+			return int(f)
+		}
+	}
+	return 0
+}
+
+// NumCtx returns num_ctx as set in the modelfile parameters, or 0.
+func (r *ShowResponse) NumCtx() int {
+	for _, line := range strings.Split(r.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			if v, err := strconv.Atoi(fields[1]); err == nil {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
+// Show fetches model information from /api/show.
+func (c *LlmClient) Show(model string) (*ShowResponse, error) {
+	body, err := json.Marshal(map[string]string{"model": model})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	resp, err := c.post("/api/show", model, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	var showResp ShowResponse
+	if err := json.NewDecoder(resp.Body).Decode(&showResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &showResp, nil
+}
+
+// modelInfo returns the cached /api/show response for a model, fetching it
+// on first use. It returns nil if the information is not available.
+func (c *LlmClient) modelInfo(model string) *ShowResponse {
+	show, ok := c.models[model]
+	if !ok {
+		var err error
+		if show, err = c.Show(model); err != nil {
+			log.Printf("cannot fetch model info for %s: %v", model, err)
+		}
+		c.models[model] = show
+	}
+	return show
+}
+
+// effectiveNumCtx returns the context window ollama will use for a request:
+// num_ctx from the request options, else from the modelfile, else the
+// default. It also returns the trained context length, if known.
+func (c *LlmClient) effectiveNumCtx(req ChatRequest) (numCtx, contextLength int) {
+	if show := c.modelInfo(req.Model); show != nil {
+		numCtx, contextLength = show.NumCtx(), show.ContextLength()
+	}
+	switch v := req.Options["num_ctx"].(type) {
+	case int:
+		numCtx = v
+	case float64:
+		numCtx = int(v)
+	}
+	if numCtx == 0 {
+		numCtx = defaultNumCtx
+	}
+	return numCtx, contextLength
+}
+
+// estimatePromptTokens estimates the prompt size of a request with four
+// bytes per token, counting the text of the messages and their tool calls
+// and the names of the tools. Images and tool schemas are left out, since
+// their size in bytes says little about their size in tokens.
+func estimatePromptTokens(req ChatRequest) int {
+	size := 0
+	for _, m := range req.Messages {
+		size += len(m.Content) + len(m.Thinking)
+		for _, tc := range m.ToolCalls {
+			args, _ := json.Marshal(tc.Function.Arguments)
+			size += len(tc.Function.Name) + len(args)
+		}
+	}
+	for _, t := range req.Tools {
+		size += len(t.Function.Name)
+	}
+	return size / 4
+}
+
+// checkContextWindow warns if the prompt likely exceeds the context window,
+// in which case ollama silently truncates the history.
+func (c *LlmClient) checkContextWindow(req ChatRequest) {
+	numCtx, contextLength := c.effectiveNumCtx(req)
+	if contextLength > 0 && numCtx > contextLength {
+		log.Printf("warning: num_ctx %d exceeds trained context length %d of %s", numCtx, contextLength, req.Model)
+	}
+	if estimated := estimatePromptTokens(req); estimated > numCtx {
+		log.Printf("warning: prompt of ~%d tokens exceeds context window of %d tokens (model supports %d), consider -num-ctx",
+			estimated, numCtx, contextLength)
+	}
+}
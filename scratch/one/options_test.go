@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/miku/unplugged/scratch/one/ollamatest"
+)
+
+func TestCheckContextWindow(t *testing.T) {
+	srv := ollamatest.NewServer()
+	defer srv.Close()
+	client := newTestClient(srv)
+	tools := NewToolRegistry()
+	registerTools(tools)
+	// A large image and all tool schemas, with little text.
+	image := strings.Repeat("iVBORw0KGgo", 100000)
+	req := ChatRequest{
+		Model: "qwen3-vl:latest",
+		Messages: []Message{
+			{Role: "user", Content: strings.Repeat("word ", 100), Images: []string{image}},
+			{Role: "assistant", ToolCalls: []ToolCall{{Function: FunctionCall{Name: "calculate", Arguments: map[string]any{"expression": "2+2"}}}}},
+		},
+		Tools: tools.GetTools(),
+	}
+	var names int
+	for _, tool := range req.Tools {
+		names += len(tool.Function.Name)
+	}
+	if got, want := estimatePromptTokens(req), (500+len("calculate")+len(`{"expression":"2+2"}`)+names)/4; got != want {
+		t.Errorf("estimated %d tokens, want %d", got, want)
+	}
+
+	var buf bytes.Buffer
+	saved := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(saved)
+	client.checkContextWindow(req)
+	if strings.Contains(buf.String(), "exceeds context window") {
+		t.Errorf("warning for a prompt with an image: %s", buf.String())
+	}
+	req.Messages[0].Content = strings.Repeat("word ", 4000)
+	client.checkContextWindow(req)
+	if !strings.Contains(buf.String(), "exceeds context window of 4096 tokens") {
+		t.Errorf("no warning for a long prompt: %q", buf.String())
+	}
+}
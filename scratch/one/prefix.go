@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ollama can only reuse its KV cache for the part of the prompt that is
+// byte-identical to the previous request. The rendered prompt starts with the
+// system prompt and the tool definitions, followed by the history. To keep
+// that prefix stable, the system prompt is constant, tools are sent in a
+// fixed order, dynamic context goes into the first user message and the
+// history is only ever appended to.
+
+// buildRequest assembles a chat request from the base settings, the tools
+// and the history. Tools are sorted by name, so the request does not depend
+// on registration or selection order.
+func buildRequest(base ChatRequest, tools []Tool, messages []Message) ChatRequest {
+	req := base
+	req.Tools = slices.SortedFunc(slices.Values(tools), func(a, b Tool) int {
+		return strings.Compare(a.Function.Name, b.Function.Name)
+	})
+	req.Messages = messages
+	return req
+}
+
+// environmentContext describes the environment of the agent. It changes
+// between runs and therefore belongs after the stable prefix.
+func environmentContext(now time.Time) string {
+	cwd, _ := os.Getwd()
+	return fmt.Sprintf("<context>\ndate: %s\nworking directory: %s\nos: %s\n</context>",
+		now.Format("Monday, 2006-01-02 15:04 MST"), cwd, runtime.GOOS)
+}
+
+// prefixChange reports how cur breaks the cache prefix established by prev,
+// or returns an empty string if cur only appends to prev.
+func prefixChange(prev, cur ChatRequest) string {
+	if prev.Model != cur.Model {
+		return "model changed"
+	}
+	pt, _ := json.Marshal(prev.Tools)
+	ct, _ := json.Marshal(cur.Tools)
+	if string(pt) != string(ct) {
+		return "tool definitions changed"
+	}
+	if len(cur.Messages) < len(prev.Messages) {
+		return fmt.Sprintf("history shrank from %d to %d messages", len(prev.Messages), len(cur.Messages))
+	}
+	for i, m := range prev.Messages {
+		pm, _ := json.Marshal(m)
+		cm, _ := json.Marshal(cur.Messages[i])
+		if string(pm) != string(cm) {
+			return fmt.Sprintf("message %d (%s) changed", i, m.Role)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Small models often emit tool calls that are almost right: arguments as a
+// string instead of an object, JSON with trailing commas, single quotes or
+// raw newlines, output cut off at the token limit, or a slightly misspelled
+// tool name. Calls are repaired where the intent is unambiguous; otherwise
+// the model gets an error describing what to fix.
+
+// errTruncated marks JSON that was cut off where completing it would
+// require guessing.
+var errTruncated = errors.New("truncated")
+
+// jsonRepairer rewrites almost-JSON into JSON in a single pass.
+type jsonRepairer struct {
+	in      string
+	i       int
+	out     strings.Builder
+	stack   []byte // open brackets
+	key     bool   // the next string is an object key
+	lastKey string
+	last    byte // last significant byte written outside of strings
+	repairs []string
+}
+
+func (p *jsonRepairer) note(repair string) {
+	if !slices.Contains(p.repairs, repair) {
+		p.repairs = append(p.repairs, repair)
+	}
+}
+
+// nextSignificant returns the next non-space byte after position i, or 0.
+func (p *jsonRepairer) nextSignificant(i int) byte {
+	for ; i < len(p.in); i++ {
+		if !unicode.IsSpace(rune(p.in[i])) {
+			return p.in[i]
+		}
+	}
+	return 0
+}
+
+var codeFence = regexp.MustCompile("(?s)^\\s*```[a-zA-Z]*\\s*\n(.*?)\n?```\\s*$")
+
+// repairJSON turns almost-JSON into a JSON document. It returns the repairs
+// applied, or an error if the input cannot be repaired without guessing.
+func repairJSON(s string) (string, []string, error) {
+	p := &jsonRepairer{}
+	if m := codeFence.FindStringSubmatch(s); m != nil {
+		s = m[1]
+		p.note("removed code fence")
+	}
+	start := strings.IndexAny(s, "{[")
+	if start < 0 {
+		return "", nil, fmt.Errorf("no JSON object found")
+	}
+	if strings.TrimSpace(s[:start]) != "" {
+		p.note("ignored text before the JSON")
+	}
+	p.in = s[start:]
+	if err := p.run(); err != nil {
+		return "", p.repairs, err
+	}
+	return p.out.String(), p.repairs, nil
+}
+
+func (p *jsonRepairer) run() error {
+	for p.i < len(p.in) {
+		c := p.in[p.i]
+		switch {
+		case c == '"' || c == '\'':
+			if err := p.str(c); err != nil {
+				return err
+			}
+			continue
+		case c == '{' || c == '[':
+			p.stack = append(p.stack, c)
+			p.key = c == '{'
+		case c == '}' || c == ']':
+			if p.last == ',' {
+				trimmed := strings.TrimRightFunc(p.out.String(), unicode.IsSpace)
+				p.out.Reset()
+				p.out.WriteString(strings.TrimSuffix(trimmed, ","))
+				p.note("removed trailing comma")
+			}
+			open := byte('{')
+			if c == ']' {
+				open = '['
+			}
+			if len(p.stack) == 0 || p.stack[len(p.stack)-1] != open {
+				return fmt.Errorf("unexpected %q at offset %d", c, p.i)
+			}
+			p.stack = p.stack[:len(p.stack)-1]
+			if len(p.stack) == 0 {
+				p.out.WriteByte(c)
+				if strings.TrimSpace(p.in[p.i+1:]) != "" {
+					p.note("ignored text after the JSON")
+				}
+				return nil
+			}
+		case c == ',':
+			p.key = p.stack[len(p.stack)-1] == '{'
+		case c == ':':
+			p.key = false
+		case c == '-' || c >= '0' && c <= '9':
+			j := p.i + 1
+			for j < len(p.in) && strings.IndexByte("0123456789.eE+-", p.in[j]) >= 0 {
+				j++
+			}
+			p.out.WriteString(p.in[p.i:j])
+			p.last = p.in[j-1]
+			p.i = j
+			continue
+		case c == '_' || c == '$' || unicode.IsLetter(rune(c)):
+			if err := p.word(); err != nil {
+				return err
+			}
+			continue
+		case unicode.IsSpace(rune(c)):
+			p.out.WriteByte(c)
+			p.i++
+			continue
+		}
+		p.out.WriteByte(c)
+		p.last = c
+		p.i++
+	}
+	return p.truncated()
+}
+
+// word handles bare words: JSON and Python literals and unquoted keys.
+func (p *jsonRepairer) word() error {
+	j := p.i
+	for j < len(p.in) && (p.in[j] == '_' || p.in[j] == '$' || p.in[j] == '-' ||
+		unicode.IsLetter(rune(p.in[j])) || unicode.IsDigit(rune(p.in[j]))) {
+		j++
+	}
+	w := p.in[p.i:j]
+	switch {
+	case w == "true" || w == "false" || w == "null":
+	case w == "True" || w == "False" || w == "None":
+		w = map[string]string{"True": "true", "False": "false", "None": "null"}[w]
+		p.note("converted Python literals")
+	case p.key && p.nextSignificant(j) == ':':
+		p.lastKey = w
+		w = `"` + w + `"`
+		p.note("quoted keys")
+	case j == len(p.in):
+		return fmt.Errorf("%w after %q", errTruncated, w)
+	default:
+		return fmt.Errorf("unquoted value %q at offset %d", w, p.i)
+	}
+	p.out.WriteString(w)
+	p.last = w[len(w)-1]
+	p.i = j
+	return nil
+}
+
+// str copies a string, quoted with q, as a JSON string. Raw control
+// characters are escaped, and quotes not followed by a delimiter are taken
+// as part of the string.
+func (p *jsonRepairer) str(q byte) error {
+	if q == '\'' {
+		p.note("replaced single quotes")
+	}
+	isKey := p.key
+	p.out.WriteByte('"')
+	var content strings.Builder
+	for p.i++; p.i < len(p.in); p.i++ {
+		c := p.in[p.i]
+		switch {
+		case c == '\\':
+			if p.i+1 == len(p.in) {
+				return p.cutInString(isKey, content.Len())
+			}
+			p.i++
+			switch n := p.in[p.i]; {
+			case n == '\'':
+				p.out.WriteByte('\'')
+				content.WriteByte('\'')
+			case strings.IndexByte(`"\/bfnrtu`, n) >= 0:
+				p.out.WriteByte('\\')
+				p.out.WriteByte(n)
+				content.WriteByte(n)
+			default:
+				p.out.WriteString(`\\`)
+				p.out.WriteByte(n)
+				content.WriteByte(n)
+				p.note("escaped backslashes")
+			}
+		case c == q:
+			if p.closesString(isKey) {
+				p.out.WriteByte('"')
+				p.i++
+				p.last = '"'
+				if isKey {
+					p.lastKey = content.String()
+				}
+				return nil
+			}
+			if q == '"' {
+				p.out.WriteString(`\"`)
+			} else {
+				p.out.WriteByte(c)
+			}
+			content.WriteByte(c)
+			p.note("escaped quotes inside strings")
+		case c == '"':
+			p.out.WriteString(`\"`)
+			content.WriteByte(c)
+		case c == '\n':
+			p.out.WriteString(`\n`)
+			content.WriteByte(c)
+			p.note("escaped newlines inside strings")
+		case c == '\r':
+			p.out.WriteString(`\r`)
+			content.WriteByte(c)
+		case c == '\t':
+			p.out.WriteString(`\t`)
+			content.WriteByte(c)
+		case c < ' ':
+			fmt.Fprintf(&p.out, `\u%04x`, c)
+			content.WriteByte(c)
+		default:
+			p.out.WriteByte(c)
+			content.WriteByte(c)
+		}
+	}
+	return p.cutInString(isKey, content.Len())
+}
+
+var memberAhead = regexp.MustCompile(`^,\s*(["'][^"'\n]*["']\s*:|[A-Za-z_$][\w$-]*\s*:|[}\]]|["'][^"'\n]*$|$)`)
+
+// closesString reports whether the quote at the current position ends the
+// string: it must be followed by what can follow a key or a value, e.g. a
+// comma and the next key.
+func (p *jsonRepairer) closesString(isKey bool) bool {
+	rest := strings.TrimLeftFunc(p.in[p.i+1:], unicode.IsSpace)
+	switch {
+	case rest == "":
+		return true
+	case isKey:
+		return rest[0] == ':'
+	case rest[0] == '}' || rest[0] == ']':
+		return true
+	case rest[0] == ',':
+		return p.stack[len(p.stack)-1] == '[' || memberAhead.MatchString(rest)
+	}
+	return false
+}
+
+func (p *jsonRepairer) cutInString(isKey bool, n int) error {
+	if isKey {
+		return fmt.Errorf("%w inside a key after %q", errTruncated, p.lastKey)
+	}
+	return fmt.Errorf("%w inside the value of %q after %d bytes", errTruncated, p.lastKey, n)
+}
+
+// truncated closes the open objects of input that ended early, if the
+// last value is complete. Arrays are not closed, items might be missing.
+func (p *jsonRepairer) truncated() error {
+	if len(p.stack) == 0 {
+		return fmt.Errorf("empty input")
+	}
+	if slices.Contains(p.stack, '[') {
+		return fmt.Errorf("%w inside an array", errTruncated)
+	}
+	switch {
+	case p.last == ',':
+		trimmed := strings.TrimRightFunc(p.out.String(), unicode.IsSpace)
+		p.out.Reset()
+		p.out.WriteString(strings.TrimSuffix(trimmed, ","))
+	case p.last == ':' || (p.last == '"' && p.key):
+		return fmt.Errorf("%w before the value of %q", errTruncated, p.lastKey)
+	case p.last >= '0' && p.last <= '9':
+		return fmt.Errorf("%w in the number value of %q", errTruncated, p.lastKey)
+	}
+	for range p.stack {
+		p.out.WriteByte('}')
+	}
+	p.note("closed truncated object")
+	return nil
+}
+
+// repairArguments parses tool call arguments that did not arrive as a JSON
+// object.
+func repairArguments(raw string) (map[string]any, []string, error) {
+	var repairs []string
+	if strings.TrimSpace(raw) == "" {
+		return map[string]any{}, []string{"empty arguments"}, nil
+	}
+	var s string
+	if json.Unmarshal([]byte(raw), &s) == nil {
+		raw = s
+		repairs = append(repairs, "decoded arguments encoded as a string")
+	}
+	for range 2 {
+		fixed, notes, err := repairJSON(raw)
+		repairs = append(repairs, notes...)
+		if err != nil {
+			return nil, repairs, err
+		}
+		var v any
+		if err := json.Unmarshal([]byte(fixed), &v); err != nil {
+			return nil, repairs, describeSyntaxError(fixed, err)
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			return v, repairs, nil
+		case string:
+			// Encoded twice.
+			raw = v
+			repairs = append(repairs, "decoded arguments encoded as a string")
+		default:
+			return nil, repairs, fmt.Errorf("arguments must be a JSON object, got %s", typeName(v))
+		}
+	}
+	return nil, repairs, fmt.Errorf("arguments must be a JSON object")
+}
+
+// describeSyntaxError points to the location of a JSON syntax error.
+func describeSyntaxError(s string, err error) error {
+	var serr *json.SyntaxError
+	if !errors.As(err, &serr) {
+		return err
+	}
+	at := int(serr.Offset)
+	from, to := max(0, at-20), min(len(s), at+20)
+	return fmt.Errorf("%v at offset %d, near %q", serr, at, s[from:to])
+}
+
+// normalizeName reduces a tool name to lower case letters and digits, so
+// getWeather, get-weather and GET_WEATHER compare equal.
+func normalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// resolveTool maps a possibly misspelled tool name to a registered tool.
+// A near miss is only accepted if there is a single closest tool.
+func (r *ToolRegistry) resolveTool(name string) (string, error) {
+	if _, ok := r.Handler(name); ok {
+		return name, nil
+	}
+	var names []string
+	for _, t := range r.GetTools() {
+		names = append(names, t.Function.Name)
+	}
+	slices.Sort(names)
+	var (
+		best     []string
+		bestDist = -1
+		norm     = normalizeName(name)
+	)
+	for _, candidate := range names {
+		d := levenshtein(norm, normalizeName(candidate))
+		if d > max(1, len(candidate)/4) {
+			continue
+		}
+		switch {
+		case bestDist < 0 || d < bestDist:
+			best, bestDist = []string{candidate}, d
+		case d == bestDist:
+			best = append(best, candidate)
+		}
+	}
+	switch len(best) {
+	case 1:
+		return best[0], nil
+	case 0:
+		return "", fmt.Errorf("unknown tool %q, available tools: %s", name, strings.Join(names, ", "))
+	default:
+		return "", fmt.Errorf("unknown tool %q, did you mean one of: %s", name, strings.Join(best, ", "))
+	}
+}
+
+// repairCall fixes the name and arguments of a tool call in place and logs
+// the repairs. If the call cannot be repaired safely, the returned error
+// tells the model what to correct; the arguments are then left empty.
+func (r *ToolRegistry) repairCall(fc *FunctionCall) error {
+	var repairs []string
+	name, err := r.resolveTool(fc.Name)
+	if err != nil {
+		fc.Arguments, fc.RawArguments = map[string]any{}, ""
+		return err
+	}
+	if name != fc.Name {
+		repairs = append(repairs, fmt.Sprintf("tool name %q", fc.Name))
+		fc.Name = name
+	}
+	if fc.Arguments == nil {
+		raw := fc.RawArguments
+		fc.Arguments, fc.RawArguments = map[string]any{}, ""
+		if raw != "" {
+			args, notes, err := repairArguments(raw)
+			if errors.Is(err, errTruncated) {
+				return fmt.Errorf("the arguments for %s are incomplete, the output was %v; "+
+					"call the tool again with complete arguments, split long content into several calls (e.g. write_file, then append_file)", name, err)
+			}
+			if err != nil {
+				return fmt.Errorf("the arguments for %s are not a valid JSON object: %v", name, err)
+			}
+			fc.Arguments = args
+			repairs = append(repairs, "arguments sent as a string")
+			repairs = append(repairs, notes...)
+		}
+	}
+	if len(repairs) > 0 {
+		log.Printf("repaired call of %s: %s", name, strings.Join(repairs, ", "))
+	}
+	return nil
+}
+
+var (
+	toolCallTag  = regexp.MustCompile(`(?s)^\s*<tool_call>(.*?)(</tool_call>\s*)?$`)
+	textCallHead = regexp.MustCompile(`^\s*(` + "```" + `[a-zA-Z]*\s*)?\{\s*["']?(tool|name)["']?\s*:\s*["']([^"']+)["']`)
+	textCallArgs = regexp.MustCompile(`["']?(tool_input|arguments|parameters)["']?\s*:`)
+)
+
+// textToolCall recognizes a tool call written into the message content, in
+// the {"tool": ..., "tool_input": ...} form used by the modelfiles or the
+// {"name": ..., "arguments": ...} form of models whose native call could
+// not be parsed by the server. The name must be one of the registry's tools
+// and the arguments must be there, so that a JSON answer with a "name"
+// field is not taken for a call. The arguments are left for repairCall.
+func (r *ToolRegistry) textToolCall(content string) (ToolCall, bool) {
+	if m := toolCallTag.FindStringSubmatch(content); m != nil {
+		content = m[1]
+	}
+	head := textCallHead.FindStringSubmatch(content)
+	if head == nil || !textCallArgs.MatchString(content) {
+		return ToolCall{}, false
+	}
+	if _, err := r.resolveTool(head[3]); err != nil {
+		return ToolCall{}, false
+	}
+	call := ToolCall{Function: FunctionCall{Name: head[3]}}
+	fixed, notes, err := repairJSON(content)
+	var envelope map[string]json.RawMessage
+	if err == nil {
+		err = json.Unmarshal([]byte(fixed), &envelope)
+	}
+	if err != nil {
+		// Leave the whole text for repairCall to report.
+		call.Function.RawArguments = content
+		return call, true
+	}
+	if len(notes) > 0 {
+		log.Printf("repaired text tool call: %s", strings.Join(notes, ", "))
+	}
+	for _, k := range []string{"tool_input", "arguments", "parameters"} {
+		if args, ok := envelope[k]; ok {
+			var s string
+			if json.Unmarshal(args, &s) == nil {
+				call.Function.RawArguments = s
+			} else if json.Unmarshal(args, &call.Function.Arguments) != nil {
+				call.Function.RawArguments = string(args)
+			}
+			return call, true
+		}
+	}
+	// The key was found in a nested object only.
+	return ToolCall{}, false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRepairArguments(t *testing.T) {
+	var cases = []struct {
+		name   string
+		raw    string
+		want   string // arguments as JSON, if repaired
+		repair string // one of the repairs
+		err    string // error substring, if not repaired
+	}{
+		{"valid", `{"city": "Halle"}`, `{"city":"Halle"}`, "", ""},
+		{"empty", "  ", `{}`, "empty arguments", ""},
+		{"single quotes", `{'city': 'Halle', 'days': 3}`, `{"city":"Halle","days":3}`, "replaced single quotes", ""},
+		{"apostrophe in double quotes", `{'msg': "it's"}`, `{"msg":"it's"}`, "replaced single quotes", ""},
+		{"unquoted keys", `{city: "Halle", days: 3}`, `{"city":"Halle","days":3}`, "quoted keys", ""},
+		{"trailing comma", `{"city": "Halle", "days": 3,}`, `{"city":"Halle","days":3}`, "removed trailing comma", ""},
+		{"trailing comma in array", `{"items": [1, 2, 3,],}`, `{"items":[1,2,3]}`, "removed trailing comma", ""},
+		{"code fence", "```json\n{\"city\": \"Halle\"}\n```", `{"city":"Halle"}`, "removed code fence", ""},
+		{"raw newline", "{\"content\": \"line one\nline two\"}", `{"content":"line one\nline two"}`, "escaped newlines inside strings", ""},
+		{"inner quotes", `{"text": "he said "hi" to me"}`, `{"text":"he said \"hi\" to me"}`, "escaped quotes inside strings", ""},
+		{"python literals", `{"ok": True, "x": None, "y": False}`, `{"ok":true,"x":null,"y":false}`, "converted Python literals", ""},
+		{"encoded as string", `"{\"city\": \"Halle\"}"`, `{"city":"Halle"}`, "decoded arguments encoded as a string", ""},
+		{"text after", `{"a": 1} trailing`, `{"a":1}`, "ignored text after the JSON", ""},
+		{"truncated after value", `{"path": "a.txt", "content": "hello",`, `{"content":"hello","path":"a.txt"}`, "closed truncated object", ""},
+		{"truncated in string", `{"path": "a.txt", "content": "hello`, "", "", `inside the value of "content"`},
+		{"truncated before value", `{"path": "a.txt", "content": `, "", "", `before the value of "content"`},
+		{"truncated in number", `{"n": 12`, "", "", `in the number value of "n"`},
+		{"truncated in array", `{"items": [1, 2`, "", "", "inside an array"},
+		{"array", `[1, 2]`, "", "", "must be a JSON object, got array"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args, repairs, err := repairArguments(c.raw)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got %v, %v, want error %q", args, err, c.err)
+				}
+				if strings.HasPrefix(c.name, "truncated") && !errors.Is(err, errTruncated) {
+					t.Errorf("error %v is not errTruncated", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := json.Marshal(args)
+			if string(b) != c.want {
+				t.Errorf("got %s, want %s", b, c.want)
+			}
+			if c.repair != "" && !strings.Contains(strings.Join(repairs, ", "), c.repair) {
+				t.Errorf("repairs %q do not contain %q", repairs, c.repair)
+			}
+		})
+	}
+}
+
+func TestResolveTool(t *testing.T) {
+	registry := NewToolRegistry()
+	registerTools(registry)
+	var cases = []struct {
+		name, want, err string
+	}{
+		{"get_weather", "get_weather", ""},
+		{"getWeather", "get_weather", ""},
+		{"GET-WEATHER", "get_weather", ""},
+		{"get_wether", "get_weather", ""},
+		{"calculator", "calculate", ""},
+		{"read_files", "read_file", ""},
+		{"list_file", "list_files", ""},
+		{"calc", "", "available tools"},
+		{"foo", "", "available tools"},
+	}
+	for _, c := range cases {
+		got, err := registry.resolveTool(c.name)
+		switch {
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("resolveTool(%q): got %q, %v, want error %q", c.name, got, err, c.err)
+		case c.err == "" && (err != nil || got != c.want):
+			t.Errorf("resolveTool(%q): got %q, %v, want %q", c.name, got, err, c.want)
+		}
+	}
+
+	// Two equally close tools are ambiguous.
+	ambiguous := NewToolRegistry()
+	for _, name := range []string{"get_time", "set_time"} {
+		ambiguous.Register(name, "", map[string]any{"type": "object"}, func(map[string]any) (any, error) { return nil, nil })
+	}
+	if got, err := ambiguous.resolveTool("bet_time"); err == nil || !strings.Contains(err.Error(), "did you mean one of: get_time, set_time") {
+		t.Errorf("resolveTool(bet_time): got %q, %v, want ambiguity error", got, err)
+	}
+}
+
+func TestRepairCall(t *testing.T) {
+	registry := NewToolRegistry()
+	registerTools(registry)
+	fc := FunctionCall{Name: "getWeather", RawArguments: `{'city': 'Halle',}`}
+	if err := registry.repairCall(&fc); err != nil {
+		t.Fatal(err)
+	}
+	if fc.Name != "get_weather" || fc.Arguments["city"] != "Halle" || fc.RawArguments != "" {
+		t.Errorf("got %+v", fc)
+	}
+	fc = FunctionCall{Name: "write_file", RawArguments: `{"path": "a.txt", "content": "hel`}
+	if err := registry.repairCall(&fc); err == nil || !strings.Contains(err.Error(), "incomplete") {
+		t.Errorf("truncated call: got %v, want incomplete arguments error", err)
+	}
+	if len(fc.Arguments) != 0 {
+		t.Errorf("arguments of a failed repair: %v", fc.Arguments)
+	}
+}
+
+func TestTextToolCall(t *testing.T) {
+	var cases = []struct {
+		content string
+		name    string
+		args    string
+	}{
+		{`{"tool": "get_weather", "tool_input": {"city": "Halle"}}`, "get_weather", `{"city":"Halle"}`},
+		{"<tool_call>\n{\"name\": \"calculate\", \"arguments\": {\"expression\": \"2+2\"}}\n</tool_call>", "calculate", `{"expression":"2+2"}`},
+		{"```json\n{'name': 'calculate', 'arguments': {'expression': '2+2'},}\n```", "calculate", `{"expression":"2+2"}`},
+		{"The weather in Halle is sunny.", "", ""},
+		{`{"name": "Alice", "age": 3}`, "", ""},
+		{`{"name": "Alice", "arguments": {"age": 3}}`, "", ""},
+		{`{"name": "calculate", "expression": "2+2"}`, "", ""},
+		{`{"name": "calculate", "result": {"arguments": 1}}`, "", ""},
+	}
+	registry := NewToolRegistry()
+	registerTools(registry)
+	for _, c := range cases {
+		call, ok := registry.textToolCall(c.content)
+		if ok != (c.name != "") {
+			t.Errorf("textToolCall(%q): ok %v", c.content, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		b, _ := json.Marshal(call.Function.Arguments)
+		if call.Function.Name != c.name || string(b) != c.args {
+			t.Errorf("textToolCall(%q): got %s %s, want %s %s", c.content, call.Function.Name, b, c.name, c.args)
+		}
+	}
+}
+
+func FuzzRepairArguments(f *testing.F) {
+	for _, seed := range []string{
+		`{"city": "Halle"}`, `{'city': 'Halle',}`, `{city: Halle}`, "```json\n{\"a\": [1, 2,]}\n```",
+		`{"a": "b`, `{"a": [`, `"{\"a\": 1}"`, `{"a": True}`, `{"a": "x"y"}`, `{"a": {"b": {"c":`, `{,}`, `'`, `{"\`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		args, _, err := repairArguments(raw)
+		if err == nil && args == nil {
+			t.Errorf("repairArguments(%q): no error and no arguments", raw)
+		}
+		if err == nil {
+			if _, err := json.Marshal(args); err != nil {
+				t.Errorf("repairArguments(%q): %v", raw, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ResultStore keeps tool outputs that are too large for the context in
+// session scoped files. The model gets a preview and a handle, and can page
+// through or search the full output with read_result and search_result.
+type ResultStore struct {
+	mu        sync.Mutex
+	dir       string
+	temporary bool
+	threshold int
+	n         int
+}
+
+// NewResultStore stores results larger than threshold bytes in dir. If dir
+// is empty, a temporary directory is used and removed on Close.
+func NewResultStore(dir string, threshold int) (*ResultStore, error) {
+	s := &ResultStore{dir: dir, threshold: threshold}
+	if dir == "" {
+		d, err := os.MkdirTemp("", "unplugged-results-")
+		if err != nil {
+			return nil, fmt.Errorf("create result store: %w", err)
+		}
+		s.dir, s.temporary = d, true
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create result store: %w", err)
+	}
+	return s, nil
+}
+
+// Close removes a temporary store.
+func (s *ResultStore) Close() error {
+	if s == nil || !s.temporary {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// Put stores a text and returns its handle.
+func (s *ResultStore) Put(text string) (string, error) {
+	s.mu.Lock()
+	s.n++
+	handle := fmt.Sprintf("r%d", s.n)
+	s.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(s.dir, handle+".txt"), []byte(text), 0644); err != nil {
+		return "", fmt.Errorf("store result: %w", err)
+	}
+	return handle, nil
+}
+
+var handlePattern = regexp.MustCompile(`^r[0-9]+$`)
+
+// Get returns the stored text for a handle.
+func (s *ResultStore) Get(handle string) (string, error) {
+	if !handlePattern.MatchString(handle) {
+		return "", fmt.Errorf("invalid handle %q", handle)
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, handle+".txt"))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("unknown handle %q", handle)
+	}
+	if err != nil {
+		return "", fmt.Errorf("read result: %w", err)
+	}
+	return string(b), nil
+}
+
+// expandResult turns a JSON object result into line oriented text, with
+// long string fields like file content or command output written out
+// verbatim instead of escaped, so they can be paged and searched by line.
+func expandResult(result string) string {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(result), &obj); err != nil {
+		return result
+	}
+	var head, body strings.Builder
+	for _, k := range sortedKeys(obj) {
+		if s, ok := obj[k].(string); ok && (len(s) > 200 || strings.Contains(s, "\n")) {
+			fmt.Fprintf(&body, "--- %s ---\n%s\n", k, strings.TrimRight(s, "\n"))
+			continue
+		}
+		b, _ := json.Marshal(obj[k])
+		fmt.Fprintf(&head, "%s: %s\n", k, b)
+	}
+	return head.String() + body.String()
+}
+
+func clip(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+// Offload stores a result larger than the threshold and returns a preview
+// with the first and last lines and a handle instead.
+func (s *ResultStore) Offload(tool, result string) (string, error) {
+	if s == nil || s.threshold <= 0 || len(result) <= s.threshold {
+		return result, nil
+	}
+	text := expandResult(result)
+	handle, err := s.Put(text)
+	if err != nil {
+		return "", err
+	}
+	const headLines, tailLines, maxLine = 20, 10, 200
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	var head, tail []string
+	for i, line := range lines {
+		switch {
+		case i < headLines:
+			head = append(head, clip(line, maxLine))
+		case i >= len(lines)-tailLines:
+			tail = append(tail, clip(line, maxLine))
+		}
+	}
+	b, err := json.Marshal(map[string]any{
+		"handle":  handle,
+		"tool":    tool,
+		"bytes":   len(text),
+		"lines":   len(lines),
+		"head":    strings.Join(head, "\n"),
+		"tail":    strings.Join(tail, "\n"),
+		"omitted": max(0, len(lines)-len(head)-len(tail)),
+		"note":    "output too large for the context; use read_result or search_result with the handle to see more",
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Register adds read_result and search_result and enables offloading for the
+// registry.
+func (s *ResultStore) Register(registry *ToolRegistry) {
+	registry.results = s
+	registry.Register(
+		"read_result",
+		"Read lines of a large tool output that was stored under a handle",
+		map[string]any{
+			"type":     "object",
+			"required": []string{"handle"},
+			"properties": map[string]any{
+				"handle": map[string]any{
+					"type":        "string",
+					"description": "The handle of the stored output, e.g. 'r1'",
+				},
+				"offset": map[string]any{
+					"type":        "number",
+					"description": "The first line to read, starting at 1 (default: 1)",
+				},
+				"limit": map[string]any{
+					"type":        "number",
+					"description": "Maximum number of lines to read (default: 100)",
+				},
+			},
+		},
+		func(args map[string]any) (any, error) {
+			handle, _ := args["handle"].(string)
+			text, err := s.Get(handle)
+			if err != nil {
+				return nil, err
+			}
+			offset, limit := 1, 100
+			if o, ok := args["offset"].(float64); ok && o >= 1 {
+				offset = int(o)
+			}
+			if l, ok := args["limit"].(float64); ok && l >= 1 {
+				limit = int(l)
+			}
+			lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+			start := min(offset-1, len(lines))
+			end := min(start+limit, len(lines))
+			// Keep a single page well below the offload threshold, stopping
+			// at the last whole line, so offset + lines_read continues
+			// where the page ends. A single line longer than the budget is
+			// clipped and counts as read.
+			budget := max(s.threshold/2, 1024)
+			size := 0
+			for i := start; i < end; i++ {
+				if i > start && size+1+len(lines[i]) > budget {
+					end = i
+					break
+				}
+				size += len(lines[i]) + 1
+			}
+			content := strings.Join(lines[start:end], "\n")
+			result := map[string]any{
+				"handle":      handle,
+				"offset":      offset,
+				"lines_read":  end - start,
+				"total_lines": len(lines),
+				"content":     clip(content, budget),
+				"more":        end < len(lines),
+			}
+			if len(content) > budget {
+				result["line_clipped"] = true
+			}
+			return result, nil
+		},
+	)
+
+	registry.Register(
+		"search_result",
+		"Search a large tool output stored under a handle for a regular expression, returning matching lines with line numbers",
+		map[string]any{
+			"type":     "object",
+			"required": []string{"handle", "pattern"},
+			"properties": map[string]any{
+				"handle": map[string]any{
+					"type":        "string",
+					"description": "The handle of the stored output, e.g. 'r1'",
+				},
+				"pattern": map[string]any{
+					"type":        "string",
+					"description": "A regular expression (RE2 syntax), e.g. 'FAIL|panic'",
+				},
+				"max_results": map[string]any{
+					"type":        "number",
+					"description": "Maximum number of matching lines to return (default: 50)",
+				},
+			},
+		},
+		func(args map[string]any) (any, error) {
+			handle, _ := args["handle"].(string)
+			text, err := s.Get(handle)
+			if err != nil {
+				return nil, err
+			}
+			pattern, _ := args["pattern"].(string)
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern: %w", err)
+			}
+			maxResults := 50
+			if mr, ok := args["max_results"].(float64); ok && mr >= 1 {
+				maxResults = int(mr)
+			}
+			type Match struct {
+				Line int    `json:"line"`
+				Text string `json:"text"`
+			}
+			var (
+				matches []Match
+				total   int
+			)
+			for i, line := range strings.Split(text, "\n") {
+				if re.MatchString(line) {
+					total++
+					if len(matches) < maxResults {
+						matches = append(matches, Match{Line: i + 1, Text: clip(line, 300)})
+					}
+				}
+			}
+			return map[string]any{
+				"handle":      handle,
+				"pattern":     pattern,
+				"match_count": total,
+				"truncated":   total > len(matches),
+				"matches":     matches,
+			}, nil
+		},
+	)
+}
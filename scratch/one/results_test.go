@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadResultPaging(t *testing.T) {
+	store, err := NewResultStore("", 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	registry := NewToolRegistry()
+	store.Register(registry)
+	var lines []string
+	for i := 1; i <= 300; i++ {
+		lines = append(lines, fmt.Sprintf("line %d %s", i, strings.Repeat("x", i%70)))
+	}
+	// A single line longer than a page.
+	lines[150] = strings.Repeat("y", 5000)
+	handle, err := store.Put(strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read []string
+	for offset := 1; ; {
+		result, err := registry.Execute("read_result", map[string]any{"handle": handle, "offset": float64(offset), "limit": float64(100)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page struct {
+			LinesRead   int    `json:"lines_read"`
+			Content     string `json:"content"`
+			More        bool   `json:"more"`
+			LineClipped bool   `json:"line_clipped"`
+		}
+		if err := json.Unmarshal([]byte(result), &page); err != nil {
+			t.Fatal(err)
+		}
+		if page.LinesRead == 0 {
+			t.Fatalf("no lines read at offset %d", offset)
+		}
+		if len(page.Content) > 2048+len("…") {
+			t.Errorf("page at offset %d has %d bytes", offset, len(page.Content))
+		}
+		got := strings.Split(page.Content, "\n")
+		if len(got) != page.LinesRead {
+			t.Fatalf("offset %d: content has %d lines, lines_read is %d", offset, len(got), page.LinesRead)
+		}
+		if page.LineClipped != (offset == 151) {
+			t.Errorf("offset %d: line_clipped is %v", offset, page.LineClipped)
+		}
+		read = append(read, got...)
+		offset += page.LinesRead
+		if !page.More {
+			break
+		}
+	}
+	if len(read) != len(lines) {
+		t.Fatalf("read %d lines, want %d", len(read), len(lines))
+	}
+	for i := range lines {
+		if read[i] != lines[i] && i != 150 {
+			t.Errorf("line %d: got %q, want %q", i+1, read[i], lines[i])
+		}
+	}
+}
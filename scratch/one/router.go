@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// EmbedRequest, cf. https://github.com/ollama/ollama/blob/main/docs/api.md#generate-embeddings
+type EmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbedResponse struct {
+	Embeddings [][]float64 `json:"embeddings"`
+}
+
+// Embed returns one embedding vector per input.
+func (c *LlmClient) Embed(model string, input []string) ([][]float64, error) {
+	body, err := json.Marshal(EmbedRequest{Model: model, Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	resp, err := c.post("/api/embed", model, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	var embedResp EmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if len(embedResp.Embeddings) != len(input) {
+		return nil, fmt.Errorf("got %d embeddings for %d inputs", len(embedResp.Embeddings), len(input))
+	}
+	return embedResp.Embeddings, nil
+}
+
+func cosine(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range min(len(a), len(b)) {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// ToolRouter limits the tools sent to the model to a small always-on core
+// and the top k tools most similar to the user request. Selected tools stay
+// selected for the rest of the session, so the prompt prefix only grows.
+// The model can pull in more tools with search_tools.
+type ToolRouter struct {
+	client  *LlmClient
+	model   string
+	k       int
+	core    map[string]bool
+	vectors map[string][]float64 // tool name to embedding of its description
+	active  map[string]bool
+	routed  string // last routed user message
+	failed  bool   // embeddings not available, all tools are sent
+}
+
+// NewToolRouter creates a router using the embedding model.
+func NewToolRouter(client *LlmClient, model string, k int, core []string) *ToolRouter {
+	r := &ToolRouter{
+		client: client,
+		model:  model,
+		k:      k,
+		core:   map[string]bool{"search_tools": true},
+		active: make(map[string]bool),
+	}
+	for _, name := range core {
+		if name = strings.TrimSpace(name); name != "" {
+			r.core[name] = true
+		}
+	}
+	return r
+}
+
+// toolText is the text embedded for a tool: name, description and the
+// descriptions of its parameters.
+func toolText(t Tool) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s", t.Function.Name, t.Function.Description)
+	if props, ok := t.Function.Parameters["properties"].(map[string]any); ok {
+		for _, k := range sortedKeys(props) {
+			if p, ok := props[k].(map[string]any); ok {
+				fmt.Fprintf(&sb, "\n%s: %v", k, p["description"])
+			}
+		}
+	}
+	return sb.String()
+}
+
+// index embeds all tool descriptions not embedded yet.
+func (r *ToolRouter) index(tools []Tool) error {
+	if r.vectors == nil {
+		r.vectors = make(map[string][]float64)
+	}
+	var (
+		names []string
+		texts []string
+	)
+	for _, t := range tools {
+		if _, ok := r.vectors[t.Function.Name]; !ok {
+			names = append(names, t.Function.Name)
+			texts = append(texts, toolText(t))
+		}
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	vectors, err := r.client.Embed(r.model, texts)
+	if err != nil {
+		return fmt.Errorf("embed tools: %w", err)
+	}
+	for i, name := range names {
+		r.vectors[name] = vectors[i]
+	}
+	return nil
+}
+
+type scoredTool struct {
+	Tool  Tool
+	Score float64
+}
+
+// rank returns all tools ordered by similarity to the query.
+func (r *ToolRouter) rank(tools []Tool, query string) ([]scoredTool, error) {
+	if err := r.index(tools); err != nil {
+		return nil, err
+	}
+	qv, err := r.client.Embed(r.model, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+	var ranked []scoredTool
+	for _, t := range tools {
+		ranked = append(ranked, scoredTool{Tool: t, Score: cosine(qv[0], r.vectors[t.Function.Name])})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	return ranked, nil
+}
+
+// lastUserMessage returns the content of the latest user message, without
+// the environment context.
+func lastUserMessage(messages []Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			content := messages[i].Content
+			if _, after, ok := strings.Cut(content, "</context>"); ok {
+				content = after
+			}
+			return strings.TrimSpace(content)
+		}
+	}
+	return ""
+}
+
+// Select returns the core tools and the tools relevant to the latest user
+// request. If embeddings are not available, all tools are returned.
+func (r *ToolRouter) Select(tools []Tool, messages []Message) []Tool {
+	if r.failed {
+		return tools
+	}
+	if query := lastUserMessage(messages); query != "" && query != r.routed {
+		r.routed = query
+		ranked, err := r.rank(tools, query)
+		if err != nil {
+			log.Printf("tool router disabled: %v", err)
+			r.failed = true
+			return tools
+		}
+		var picked []string
+		for _, st := range ranked {
+			if len(picked) == r.k {
+				break
+			}
+			if !r.core[st.Tool.Function.Name] {
+				r.active[st.Tool.Function.Name] = true
+				picked = append(picked, fmt.Sprintf("%s (%.2f)", st.Tool.Function.Name, st.Score))
+			}
+		}
+		log.Printf("tool router selected: %s", strings.Join(picked, ", "))
+	}
+	return slices.DeleteFunc(slices.Clone(tools), func(t Tool) bool {
+		return !r.core[t.Function.Name] && !r.active[t.Function.Name]
+	})
+}
+
+// Register adds the search_tools meta tool and enables routing for the
+// registry.
+func (r *ToolRouter) Register(registry *ToolRegistry) {
+	registry.router = r
+	registry.Register(
+		"search_tools",
+		"Search for additional tools by describing what you want to do. Matching tools become available in your next step.",
+		map[string]any{
+			"type":     "object",
+			"required": []string{"query"},
+			"properties": map[string]any{
+				"query": map[string]any{
+					"type":        "string",
+					"description": "What the tool should do, e.g. 'convert between timezones'",
+				},
+				"limit": map[string]any{
+					"type":        "number",
+					"description": "Maximum number of tools to add (default: 3)",
+				},
+			},
+		},
+		func(args map[string]any) (any, error) {
+			query, ok := args["query"].(string)
+			if !ok || query == "" {
+				return nil, fmt.Errorf("query must be a non-empty string")
+			}
+			limit := 3
+			if l, ok := args["limit"].(float64); ok && l > 0 {
+				limit = int(l)
+			}
+			ranked, err := r.rank(registry.GetTools(), query)
+			if err != nil {
+				return nil, err
+			}
+			type found struct {
+				Name        string  `json:"name"`
+				Description string  `json:"description"`
+				Score       float64 `json:"score"`
+				New         bool    `json:"new"`
+			}
+			var tools []found
+			for _, st := range ranked {
+				name := st.Tool.Function.Name
+				if len(tools) == limit {
+					break
+				}
+				if name == "search_tools" {
+					continue
+				}
+				tools = append(tools, found{
+					Name:        name,
+					Description: st.Tool.Function.Description,
+					Score:       math.Round(st.Score*100) / 100,
+					New:         !r.core[name] && !r.active[name],
+				})
+				r.active[name] = true
+			}
+			return map[string]any{
+				"query": query,
+				"tools": tools,
+			}, nil
+		},
+	)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// loadFormat returns the format request parameter for structured output,
+// either the JSON schema read from a file or the string "json", and the
+// decoded schema used for validation (nil for plain JSON).
+func loadFormat(schemaFile string, plainJSON bool) (json.RawMessage, map[string]any, error) {
+	if schemaFile == "" {
+		if plainJSON {
+			return json.RawMessage(`"json"`), nil, nil
+		}
+		return nil, nil, nil
+	}
+	b, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read schema: %w", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return nil, nil, fmt.Errorf("parse schema %s: %w", schemaFile, err)
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return nil, nil, err
+	}
+	return json.RawMessage(buf.Bytes()), schema, nil
+}
+
+// runStructured asks the model for JSON output conforming to the request
+// format and writes the validated, compacted JSON to w. If the answer does
+// not validate, the error is sent back to the model, up to retries times.
+func runStructured(client *LlmClient, registry *ToolRegistry, base ChatRequest, schema map[string]any,
+	userMessage string, images []string, transcript *Transcript, retries int, w io.Writer) error {
+	messages := initialMessages(userMessage, images)
+	messages[0].Content += " Respond with JSON only."
+	if err := transcript.Write(messages...); err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		var err error
+		messages, err = converse(client, registry, base, messages, transcript)
+		if err != nil {
+			return err
+		}
+		answer := messages[len(messages)-1].Content
+		verr := validateJSON(answer, schema)
+		if verr == nil {
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, []byte(strings.TrimSpace(answer))); err != nil {
+				return err
+			}
+			buf.WriteByte('\n')
+			_, err := w.Write(buf.Bytes())
+			return err
+		}
+		if attempt >= retries {
+			return fmt.Errorf("invalid output after %d attempt(s): %w", attempt+1, verr)
+		}
+		log.Printf("output does not validate, retrying: %v", verr)
+		feedback := Message{
+			Role:    "user",
+			Content: fmt.Sprintf("Your answer is not valid: %v. Respond again with corrected JSON only.", verr),
+		}
+		if err := transcript.Write(feedback); err != nil {
+			return err
+		}
+		messages = append(messages, feedback)
+	}
+}
+
+// validateJSON checks that s is a JSON document and, if schema is not nil,
+// that it conforms to the schema.
+func validateJSON(s string, schema map[string]any) error {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return fmt.Errorf("not JSON: %w", err)
+	}
+	if schema == nil {
+		return nil
+	}
+	return validateSchema(v, schema, "$")
+}
+
+// validateSchema implements the commonly used subset of JSON Schema: type,
+// enum, const, properties, required, additionalProperties, items, anyOf,
+// oneOf, length, size and range constraints and pattern.
+func validateSchema(v any, schema map[string]any, path string) error {
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []any:
+			for _, s := range t {
+				if s, ok := s.(string); ok {
+					types = append(types, s)
+				}
+			}
+		}
+		if !slices.ContainsFunc(types, func(t string) bool { return hasType(v, t) }) {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), typeName(v))
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		if !slices.ContainsFunc(enum, func(e any) bool { return jsonEqual(e, v) }) {
+			return fmt.Errorf("%s: value %v not in enum %v", path, v, enum)
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, v) {
+		return fmt.Errorf("%s: expected constant %v", path, c)
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		alternatives, ok := schema[key].([]any)
+		if !ok {
+			continue
+		}
+		matched := 0
+		for _, alt := range alternatives {
+			if alt, ok := alt.(map[string]any); ok && validateSchema(v, alt, path) == nil {
+				matched++
+			}
+		}
+		if matched == 0 || (key == "oneOf" && matched > 1) {
+			return fmt.Errorf("%s: value does not match %s (%d of %d alternatives match)", path, key, matched, len(alternatives))
+		}
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, r := range required {
+				if name, ok := r.(string); ok {
+					if _, ok := v[name]; !ok {
+						return fmt.Errorf("%s: missing required property %q", path, name)
+					}
+				}
+			}
+		}
+		for _, k := range sortedKeys(v) {
+			if ps, ok := props[k].(map[string]any); ok {
+				if err := validateSchema(v[k], ps, path+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			switch ap := schema["additionalProperties"].(type) {
+			case bool:
+				if !ap {
+					return fmt.Errorf("%s: unexpected property %q", path, k)
+				}
+			case map[string]any:
+				if err := validateSchema(v[k], ap, path+"."+k); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if n, ok := schema["minItems"].(float64); ok && float64(len(v)) < n {
+			return fmt.Errorf("%s: expected at least %v items, got %d", path, n, len(v))
+		}
+		if n, ok := schema["maxItems"].(float64); ok && float64(len(v)) > n {
+			return fmt.Errorf("%s: expected at most %v items, got %d", path, n, len(v))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		if m, ok := schema["minLength"].(float64); ok && n < m {
+			return fmt.Errorf("%s: string shorter than %v", path, m)
+		}
+		if m, ok := schema["maxLength"].(float64); ok && n > m {
+			return fmt.Errorf("%s: string longer than %v", path, m)
+		}
+		if p, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("%s: invalid pattern in schema: %w", path, err)
+			}
+			if !re.MatchString(v) {
+				return fmt.Errorf("%s: %q does not match pattern %s", path, v, p)
+			}
+		}
+	case float64:
+		if m, ok := schema["minimum"].(float64); ok && v < m {
+			return fmt.Errorf("%s: %v is less than minimum %v", path, v, m)
+		}
+		if m, ok := schema["maximum"].(float64); ok && v > m {
+			return fmt.Errorf("%s: %v is greater than maximum %v", path, v, m)
+		}
+	}
+	return nil
+}
+
+func hasType(v any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return false
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func jsonEqual(a, b any) bool {
+	ba, errA := json.Marshal(a)
+	bb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ba, bb)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Tool handlers return structured values. These are marshaled to compact
+// JSON first, which is what cassettes record and the result store offloads,
+// and then rendered in the configured result format when placed in the
+// context. YAML and TOON (https://github.com/toon-format/spec) drop most of
+// the quotes and braces of JSON; TOON additionally writes arrays of uniform
+// objects as a table with a single header.
+
+// resultFormats render a decoded JSON value as text.
+var resultFormats = map[string]func(v any) string{
+	"json": formatJSON,
+	"yaml": formatYAML,
+	"toon": formatTOON,
+}
+
+// field is a member of a decoded JSON object. Objects are decoded into
+// []field to keep the order of the handler's struct fields.
+type field struct {
+	Key   string
+	Value any
+}
+
+// marshalResult encodes a tool result as compact JSON, without escaping
+// HTML characters, which only costs tokens.
+func marshalResult(v any) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", fmt.Errorf("marshal result: %w", err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// decodeOrdered decodes a JSON document into []field, []any, json.Number,
+// string, bool or nil values.
+func decodeOrdered(s string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := []field{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, field{Key: key.(string), Value: v})
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// formatResult renders a JSON tool result in the format of the registry.
+// Results that are not JSON are returned unchanged.
+func (r *ToolRegistry) formatResult(result string) string {
+	if r.format == nil {
+		return result
+	}
+	v, err := decodeOrdered(result)
+	if err != nil {
+		return result
+	}
+	return r.format(v)
+}
+
+// ErrorResult is the tool result reporting a failed tool call to the model.
+func (r *ToolRegistry) ErrorResult(err error) string {
+	result, merr := marshalResult(map[string]any{"error": err.Error()})
+	if merr != nil {
+		return err.Error()
+	}
+	return r.formatResult(result)
+}
+
+func formatJSON(v any) string {
+	var sb strings.Builder
+	writeJSON(&sb, v)
+	return sb.String()
+}
+
+func writeJSON(sb *strings.Builder, v any) {
+	switch v := v.(type) {
+	case []field:
+		sb.WriteByte('{')
+		for i, f := range v {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(quoteJSON(f.Key))
+			sb.WriteByte(':')
+			writeJSON(sb, f.Value)
+		}
+		sb.WriteByte('}')
+	case []any:
+		sb.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeJSON(sb, e)
+		}
+		sb.WriteByte(']')
+	default:
+		sb.WriteString(scalarText(v, quoteJSON))
+	}
+}
+
+// quoteJSON returns s as a JSON string, which is also a valid YAML double
+// quoted string.
+func quoteJSON(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// scalarText renders numbers, booleans and null literally and strings with
+// the quote function of the format.
+func scalarText(v any, quote func(string) string) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		return quote(v)
+	}
+	return quote(fmt.Sprint(v))
+}
+
+func isScalar(v any) bool {
+	switch v.(type) {
+	case []field, []any:
+		return false
+	}
+	return true
+}
+
+var (
+	yamlReserved = regexp.MustCompile(`^(?i:true|false|yes|no|on|off|y|n|null|~)$`)
+	looksNumeric = regexp.MustCompile(`^[-+]?(\.?[0-9]|0x|0o|\.inf|\.nan)|^[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}`)
+)
+
+// yamlString returns s as a plain scalar if YAML would read it back as the
+// same string, and double quoted otherwise.
+func yamlString(s string) string {
+	switch {
+	case s == "", s != strings.TrimSpace(s),
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`"),
+		strings.Contains(s, ": "), strings.Contains(s, " #"), strings.HasSuffix(s, ":"),
+		yamlReserved.MatchString(s), looksNumeric.MatchString(s),
+		strings.ContainsFunc(s, func(r rune) bool { return r < ' ' || r == 0x7f }):
+		return quoteJSON(s)
+	}
+	return s
+}
+
+// yamlBlock reports whether s is best written as a literal block scalar.
+func yamlBlock(s string) bool {
+	if !strings.Contains(strings.TrimRight(s, "\n"), "\n") || strings.HasSuffix(s, "\n\n") {
+		return false
+	}
+	if strings.HasPrefix(s, " ") || strings.HasPrefix(s, "\n") {
+		return false // would need an indentation indicator
+	}
+	return !strings.ContainsFunc(s, func(r rune) bool { return r < ' ' && r != '\n' && r != '\t' || r == 0x7f })
+}
+
+func formatYAML(v any) string {
+	var sb strings.Builder
+	switch x := v.(type) {
+	case []field:
+		if len(x) == 0 {
+			return "{}"
+		}
+	case []any:
+		if len(x) == 0 {
+			return "[]"
+		}
+	default:
+		return scalarText(v, yamlString)
+	}
+	writeYAMLNode(&sb, v, 0)
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// writeYAMLValue writes the value following a key or list dash, at the given
+// indentation of the nested lines.
+func writeYAMLValue(sb *strings.Builder, v any, indent int) {
+	switch x := v.(type) {
+	case []field:
+		if len(x) == 0 {
+			sb.WriteString(" {}\n")
+			return
+		}
+		sb.WriteByte('\n')
+		writeYAMLNode(sb, x, indent)
+	case []any:
+		if len(x) == 0 {
+			sb.WriteString(" []\n")
+			return
+		}
+		sb.WriteByte('\n')
+		writeYAMLNode(sb, x, indent)
+	case string:
+		if !yamlBlock(x) {
+			sb.WriteString(" " + yamlString(x) + "\n")
+			return
+		}
+		if strings.HasSuffix(x, "\n") {
+			sb.WriteString(" |\n")
+		} else {
+			sb.WriteString(" |-\n")
+		}
+		pad := strings.Repeat(" ", indent)
+		for _, line := range strings.Split(strings.TrimSuffix(x, "\n"), "\n") {
+			if line != "" {
+				sb.WriteString(pad + line)
+			}
+			sb.WriteByte('\n')
+		}
+	default:
+		sb.WriteString(" " + scalarText(x, yamlString) + "\n")
+	}
+}
+
+// writeYAMLNode writes an object or array, one member per line.
+func writeYAMLNode(sb *strings.Builder, v any, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch x := v.(type) {
+	case []field:
+		for _, f := range x {
+			sb.WriteString(pad + yamlString(f.Key) + ":")
+			writeYAMLValue(sb, f.Value, indent+2)
+		}
+	case []any:
+		for _, e := range x {
+			sb.WriteString(pad + "-")
+			if obj, ok := e.([]field); ok && len(obj) > 0 {
+				// The first member goes on the line of the dash.
+				var item strings.Builder
+				writeYAMLNode(&item, obj, indent+2)
+				sb.WriteString(" " + strings.TrimPrefix(item.String(), pad+"  "))
+				continue
+			}
+			writeYAMLValue(sb, e, indent+2)
+		}
+	}
+}
+
+var (
+	toonKey     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+	toonNumeric = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$|^0[0-9]`)
+	toonEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+)
+
+// toonString returns s unquoted, unless it could be mistaken for another
+// type or contains the delimiter or structural characters.
+func toonString(s string) string {
+	switch {
+	case s == "", s != strings.TrimSpace(s), strings.HasPrefix(s, "-"),
+		s == "true", s == "false", s == "null", toonNumeric.MatchString(s),
+		strings.ContainsAny(s, `:"\[]{},`),
+		strings.ContainsFunc(s, func(r rune) bool { return r < ' ' }):
+		return `"` + toonEscaper.Replace(s) + `"`
+	}
+	return s
+}
+
+func toonKeyText(k string) string {
+	if toonKey.MatchString(k) {
+		return k
+	}
+	return `"` + toonEscaper.Replace(k) + `"`
+}
+
+func formatTOON(v any) string {
+	var sb strings.Builder
+	switch x := v.(type) {
+	case []field:
+		writeTOONObject(&sb, x, 0)
+	case []any:
+		writeTOONArray(&sb, "", x, 0)
+	default:
+		return scalarText(x, toonString)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func writeTOONObject(sb *strings.Builder, obj []field, indent int) {
+	pad := strings.Repeat(" ", indent)
+	for _, f := range obj {
+		key := toonKeyText(f.Key)
+		switch x := f.Value.(type) {
+		case []field:
+			sb.WriteString(pad + key + ":\n")
+			writeTOONObject(sb, x, indent+2)
+		case []any:
+			writeTOONArray(sb, pad+key, x, indent)
+		default:
+			sb.WriteString(pad + key + ": " + scalarText(x, toonString) + "\n")
+		}
+	}
+}
+
+// tableHeader returns the common keys if all elements are objects with the
+// same keys in the same order and only scalar values.
+func tableHeader(arr []any) []string {
+	var keys []string
+	for i, e := range arr {
+		obj, ok := e.([]field)
+		if !ok || len(obj) == 0 || (i > 0 && len(obj) != len(keys)) {
+			return nil
+		}
+		for j, f := range obj {
+			if !isScalar(f.Value) {
+				return nil
+			}
+			if i == 0 {
+				keys = append(keys, f.Key)
+			} else if keys[j] != f.Key {
+				return nil
+			}
+		}
+	}
+	return keys
+}
+
+// writeTOONArray writes an array with its length: inline for scalars, as a
+// table for uniform objects and as a list otherwise. The prefix is the
+// indented key, if any.
+func writeTOONArray(sb *strings.Builder, prefix string, arr []any, indent int) {
+	header := fmt.Sprintf("%s[%d]", prefix, len(arr))
+	if len(arr) == 0 {
+		sb.WriteString(header + ":\n")
+		return
+	}
+	row := func(values []string) string { return strings.Join(values, ",") }
+	if !slices.ContainsFunc(arr, func(e any) bool { return !isScalar(e) }) {
+		var values []string
+		for _, e := range arr {
+			values = append(values, scalarText(e, toonString))
+		}
+		sb.WriteString(header + ": " + row(values) + "\n")
+		return
+	}
+	pad := strings.Repeat(" ", indent+2)
+	if keys := tableHeader(arr); keys != nil {
+		var names []string
+		for _, k := range keys {
+			names = append(names, toonKeyText(k))
+		}
+		sb.WriteString(header + "{" + row(names) + "}:\n")
+		for _, e := range arr {
+			var values []string
+			for _, f := range e.([]field) {
+				values = append(values, scalarText(f.Value, toonString))
+			}
+			sb.WriteString(pad + row(values) + "\n")
+		}
+		return
+	}
+	sb.WriteString(header + ":\n")
+	for _, e := range arr {
+		switch x := e.(type) {
+		case []field:
+			if len(x) == 0 {
+				sb.WriteString(pad + "-\n")
+				continue
+			}
+			// The first field goes on the line of the dash, the others
+			// are aligned with it.
+			var item strings.Builder
+			writeTOONObject(&item, x, indent+4)
+			sb.WriteString(pad + "- " + strings.TrimPrefix(item.String(), pad+"  "))
+		case []any:
+			writeTOONArray(sb, pad+"- ", x, indent+2)
+		default:
+			sb.WriteString(pad + "- " + scalarText(x, toonString) + "\n")
+		}
+	}
+}
+
+// tokenPattern approximates the pre-tokenization of BPE tokenizers: words
+// with a leading space, short digit groups and single punctuation marks.
+var tokenPattern = regexp.MustCompile(` ?[A-Za-z]+| ?[0-9]{1,3}| ?[^\sA-Za-z0-9]|\s+`)
+
+// estimateTokens roughly estimates the number of tokens of s. Long words
+// are split into several tokens.
+func estimateTokens(s string) int {
+	n := 0
+	for _, m := range tokenPattern.FindAllString(s, -1) {
+		n += 1 + (len(strings.TrimSpace(m))-1)/6
+	}
+	return n
+}
+
+// benchCalls are sample calls of the existing tools, used to compare result
+// formats if no cassette is given.
+var benchCalls = []struct {
+	Tool string
+	Args map[string]any
+}{
+	{"list_files", map[string]any{"path": "."}},
+	{"read_file", map[string]any{"path": "go.mod"}},
+	{"grep", map[string]any{"pattern": "func ", "path": ".", "context_lines": float64(1), "max_results": float64(10)}},
+	{"run_command", map[string]any{"command": "ls -l"}},
+	{"get_weather", map[string]any{"city": "Halle (Saale)"}},
+	{"get_time", map[string]any{"timezone": "Europe/Berlin"}},
+	{"calculate", map[string]any{"expression": "2^64 / 3"}},
+}
+
+type benchSample struct {
+	Tool   string
+	Result string // compact JSON
+}
+
+// benchSamples returns the tool results recorded in the cassettes, or the
+// results of the sample calls.
+func benchSamples(registry *ToolRegistry, cassettes []string) ([]benchSample, error) {
+	var samples []benchSample
+	for _, filename := range cassettes {
+		c, err := OpenCassette(filename, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, entries := range c.entries {
+			for _, e := range entries {
+				if e.Kind == "tool" && e.Result != "" {
+					samples = append(samples, benchSample{Tool: e.Tool, Result: e.Result})
+				}
+			}
+		}
+	}
+	if len(cassettes) > 0 {
+		return samples, nil
+	}
+	for _, call := range benchCalls {
+		handler, ok := registry.Handler(call.Tool)
+		if !ok {
+			continue
+		}
+		v, err := handler(call.Args)
+		if err != nil {
+			log.Printf("skipping %s: %v", call.Tool, err)
+			continue
+		}
+		result, err := marshalResult(v)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, benchSample{Tool: call.Tool, Result: result})
+	}
+	return samples, nil
+}
+
+// runFormatBench renders tool results in all formats and writes a table
+// with bytes and estimated tokens, relative to JSON.
+func runFormatBench(registry *ToolRegistry, cassettes []string, w io.Writer) error {
+	samples, err := benchSamples(registry, cassettes)
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("no tool results to compare")
+	}
+	names := []string{"json", "yaml", "toon"}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "tool\t")
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s bytes\t%s ~tok\t", name, name)
+	}
+	fmt.Fprintln(tw)
+	totalBytes := make(map[string]int)
+	totalTokens := make(map[string]int)
+	relative := func(n, base int) string {
+		if base == 0 {
+			return fmt.Sprint(n)
+		}
+		return fmt.Sprintf("%d (%+.0f%%)", n, 100*float64(n-base)/float64(base))
+	}
+	for _, s := range samples {
+		v, err := decodeOrdered(s.Result)
+		if err != nil {
+			log.Printf("skipping %s: result is not JSON", s.Tool)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t", s.Tool)
+		baseBytes, baseTokens := 0, 0
+		for _, name := range names {
+			text := resultFormats[name](v)
+			b, t := len(text), estimateTokens(text)
+			if name == "json" {
+				baseBytes, baseTokens = b, t
+			}
+			totalBytes[name] += b
+			totalTokens[name] += t
+			if name == "json" {
+				fmt.Fprintf(tw, "%d\t%d\t", b, t)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t", relative(b, baseBytes), relative(t, baseTokens))
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t", totalBytes["json"], totalTokens["json"])
+	for _, name := range names[1:] {
+		fmt.Fprintf(tw, "%s\t%s\t", relative(totalBytes[name], totalBytes["json"]),
+			relative(totalTokens[name], totalTokens["json"]))
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var formatCases = []struct {
+	about string
+	json  string
+	yaml  string
+	toon  string
+}{
+	{
+		"strings that look like other types are quoted",
+		`{"a":"true","b":"123","c":"1.5e3","d":"null","e":"yes","f":"2026-10-18","g":"007","h":"plain text"}`,
+		`a: "true"
+b: "123"
+c: "1.5e3"
+d: "null"
+e: "yes"
+f: "2026-10-18"
+g: "007"
+h: plain text`,
+		`a: "true"
+b: "123"
+c: "1.5e3"
+d: "null"
+e: yes
+f: 2026-10-18
+g: "007"
+h: plain text`,
+	},
+	{
+		"strings with structure or surrounding space are quoted",
+		`{"a":"","b":" x","c":"-x","d":"a: b","e":"a,b","f":"say \"hi\"","g":"tab\there"}`,
+		`a: ""
+b: " x"
+c: "-x"
+d: "a: b"
+e: a,b
+f: say "hi"
+g: "tab\there"`,
+		`a: ""
+b: " x"
+c: "-x"
+d: "a: b"
+e: "a,b"
+f: "say \"hi\""
+g: "tab\there"`,
+	},
+	{
+		"scalars are written literally",
+		`{"i":42,"f":-1.5,"t":true,"n":null}`,
+		`i: 42
+f: -1.5
+t: true
+"n": null`,
+		`i: 42
+f: -1.5
+t: true
+n: null`,
+	},
+	{
+		"keys that need quotes",
+		`{"my key":1,"a: b":2,"":3,"1x":4,"x.y":5,"-":6}`,
+		`my key: 1
+"a: b": 2
+"": 3
+"1x": 4
+x.y: 5
+"-": 6`,
+		`"my key": 1
+"a: b": 2
+"": 3
+"1x": 4
+x.y: 5
+"-": 6`,
+	},
+	{
+		"empty arrays and objects",
+		`{"e":[],"o":{},"nested":{"e":[],"o":{}}}`,
+		`e: []
+o: {}
+nested:
+  e: []
+  o: {}`,
+		`e[0]:
+o:
+nested:
+  e[0]:
+  o:`,
+	},
+	{
+		"multi-line strings",
+		`{"text":"line one\nline two\n","strip":"a\nb","lead":" lead\nx","blank":"a\n\n"}`,
+		`text: |
+  line one
+  line two
+strip: |-
+  a
+  b
+lead: " lead\nx"
+blank: "a\n\n"`,
+		`text: "line one\nline two\n"
+strip: "a\nb"
+lead: " lead\nx"
+blank: "a\n\n"`,
+	},
+	{
+		"uniform objects",
+		`{"rows":[{"a":1,"b":"x"},{"a":2,"b":"y, z"}]}`,
+		`rows:
+  - a: 1
+    b: x
+  - a: 2
+    b: y, z`,
+		`rows[2]{a,b}:
+  1,x
+  2,"y, z"`,
+	},
+	{
+		"objects with keys in another order",
+		`{"rows":[{"a":1,"b":"x"},{"b":"y","a":2}]}`,
+		`rows:
+  - a: 1
+    b: x
+  - b: "y"
+    a: 2`,
+		`rows[2]:
+  - a: 1
+    b: x
+  - b: y
+    a: 2`,
+	},
+	{
+		"objects with nested values",
+		`{"rows":[{"a":1,"b":[1,2]},{"a":2}]}`,
+		`rows:
+  - a: 1
+    b:
+      - 1
+      - 2
+  - a: 2`,
+		`rows[2]:
+  - a: 1
+    b[2]: 1,2
+  - a: 2`,
+	},
+	{
+		"mixed array",
+		`{"mixed":[1,"two",{"three":3},[4,5],[],{}]}`,
+		`mixed:
+  - 1
+  - two
+  - three: 3
+  -
+    - 4
+    - 5
+  - []
+  - {}`,
+		`mixed[6]:
+  - 1
+  - two
+  - three: 3
+  - [2]: 4,5
+  - [0]:
+  -`,
+	},
+	{
+		"top level array of objects",
+		`[{"a":1},{"a":2}]`,
+		`- a: 1
+- a: 2`,
+		`[2]{a}:
+  1
+  2`,
+	},
+	{
+		"top level array of arrays",
+		`[[1,2],[3]]`,
+		`-
+  - 1
+  - 2
+-
+  - 3`,
+		`[2]:
+  - [2]: 1,2
+  - [1]: 3`,
+	},
+	{"top level string", `"true"`, `"true"`, `"true"`},
+	{"top level number", `42`, `42`, `42`},
+	{"top level empty array", `[]`, `[]`, `[0]:`},
+	{"top level empty object", `{}`, `{}`, ``},
+}
+
+func TestFormatYAML(t *testing.T) {
+	for _, c := range formatCases {
+		v, err := decodeOrdered(c.json)
+		if err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if got := formatYAML(v); got != c.yaml {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.about, got, c.yaml)
+		}
+	}
+}
+
+func TestFormatTOON(t *testing.T) {
+	for _, c := range formatCases {
+		v, err := decodeOrdered(c.json)
+		if err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if got := formatTOON(v); got != c.toon {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.about, got, c.toon)
+		}
+	}
+}
+
+func TestFormatJSONKeepsOrder(t *testing.T) {
+	in := `{"z":1,"a":{"y":[true,null],"b":"x"}}`
+	v, err := decodeOrdered(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatJSON(v); got != in {
+		t.Errorf("got %s, want %s", got, in)
+	}
+	if _, err := decodeOrdered(`{"a":1} {"b":2}`); err == nil || !strings.Contains(err.Error(), "unexpected data") {
+		t.Errorf("trailing data: got %v", err)
+	}
+}
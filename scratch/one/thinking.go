@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	dim   = "\033[2m"
+	reset = "\033[0m"
+)
+
+// parseThink turns the -think flag value into the think request parameter:
+// a boolean, or a level for models that support it (gpt-oss). An empty
+// string leaves the decision to the model.
+func parseThink(s string) (any, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return nil, nil
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	case "low", "medium", "high":
+		return strings.ToLower(s), nil
+	default:
+		return nil, fmt.Errorf("invalid think value %q, want true, false, low, medium or high", s)
+	}
+}
+
+// logThinking shows reasoning content dimmed, unless hidden.
+func logThinking(thinking string) {
+	if thinking == "" || *hideThinking {
+		return
+	}
+	log.Printf("thinking: %s%s%s", dim, strings.TrimSpace(thinking), reset)
+}
+
+// Transcript records every message of a session as JSON lines, including
+// reasoning content that is not sent back to the model. A nil transcript
+// discards all messages.
+type Transcript struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// OpenTranscript opens or creates a transcript file for appending. An empty
+// filename returns a nil transcript.
+func OpenTranscript(filename string) (*Transcript, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open transcript: %w", err)
+	}
+	return &Transcript{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Write appends messages to the transcript.
+func (t *Transcript) Write(msgs ...Message) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, msg := range msgs {
+		if err := t.enc.Encode(msg); err != nil {
+			return fmt.Errorf("write transcript: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the transcript file.
+func (t *Transcript) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.f.Close()
+}
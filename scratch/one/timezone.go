@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // used if the system has no zoneinfo
+)
+
+// clock returns the current time. It is a variable, so a fixed time can be
+// injected.
+var clock = time.Now
+
+// zoneAliases maps abbreviations and cities that are not part of an IANA
+// zone name to a representative zone. Abbreviations stand for the region
+// that uses them, so CET in summer is reported as CEST.
+var zoneAliases = map[string]string{
+	"utc": "UTC", "gmt": "UTC", "z": "UTC", "zulu": "UTC",
+	"cet": "Europe/Berlin", "cest": "Europe/Berlin", "mez": "Europe/Berlin", "mesz": "Europe/Berlin",
+	"wet": "Europe/Lisbon", "west": "Europe/Lisbon", "bst": "Europe/London",
+	"eet": "Europe/Athens", "eest": "Europe/Athens", "msk": "Europe/Moscow",
+	"est": "America/New_York", "edt": "America/New_York", "et": "America/New_York",
+	"cst": "America/Chicago", "cdt": "America/Chicago", "ct": "America/Chicago",
+	"mst": "America/Denver", "mdt": "America/Denver", "mt": "America/Denver",
+	"pst": "America/Los_Angeles", "pdt": "America/Los_Angeles", "pt": "America/Los_Angeles",
+	"akst": "America/Anchorage", "akdt": "America/Anchorage", "hst": "Pacific/Honolulu",
+	"brt": "America/Sao_Paulo", "art": "America/Argentina/Buenos_Aires",
+	"ist": "Asia/Kolkata", "pkt": "Asia/Karachi", "sgt": "Asia/Singapore", "hkt": "Asia/Hong_Kong",
+	"jst": "Asia/Tokyo", "kst": "Asia/Seoul", "aest": "Australia/Sydney", "aedt": "Australia/Sydney",
+	"acst": "Australia/Adelaide", "awst": "Australia/Perth", "nzst": "Pacific/Auckland", "nzdt": "Pacific/Auckland",
+	"sast": "Africa/Johannesburg", "cat": "Africa/Maputo", "eat": "Africa/Nairobi", "wat": "Africa/Lagos",
+
+	"halle": "Europe/Berlin", "halle (saale)": "Europe/Berlin", "leipzig": "Europe/Berlin",
+	"magdeburg": "Europe/Berlin", "dresden": "Europe/Berlin", "munich": "Europe/Berlin",
+	"münchen": "Europe/Berlin", "munchen": "Europe/Berlin", "hamburg": "Europe/Berlin", "frankfurt": "Europe/Berlin",
+	"cologne": "Europe/Berlin", "köln": "Europe/Berlin", "stuttgart": "Europe/Berlin",
+	"düsseldorf": "Europe/Berlin", "bonn": "Europe/Berlin", "germany": "Europe/Berlin",
+	"wien": "Europe/Vienna", "geneva": "Europe/Zurich", "genf": "Europe/Zurich", "bern": "Europe/Zurich",
+	"milan": "Europe/Rome", "barcelona": "Europe/Madrid",
+	"krakow": "Europe/Warsaw", "kiev": "Europe/Kyiv", "st petersburg": "Europe/Moscow",
+	"washington": "America/New_York", "boston": "America/New_York", "philadelphia": "America/New_York",
+	"miami": "America/New_York", "atlanta": "America/New_York", "montreal": "America/Toronto",
+	"houston": "America/Chicago", "dallas": "America/Chicago", "austin": "America/Chicago",
+	"san francisco": "America/Los_Angeles", "seattle": "America/Los_Angeles", "san diego": "America/Los_Angeles",
+	"las vegas": "America/Los_Angeles", "salt lake city": "America/Denver", "rio de janeiro": "America/Sao_Paulo",
+	"buenos aires": "America/Argentina/Buenos_Aires", "beijing": "Asia/Shanghai", "peking": "Asia/Shanghai",
+	"mumbai": "Asia/Kolkata", "delhi": "Asia/Kolkata", "new delhi": "Asia/Kolkata", "bangalore": "Asia/Kolkata",
+	"osaka": "Asia/Tokyo", "abu dhabi": "Asia/Dubai", "hanoi": "Asia/Bangkok", "canberra": "Australia/Sydney",
+	"wellington": "Pacific/Auckland", "cape town": "Africa/Johannesburg",
+}
+
+// zoneRegions are tried as prefixes for plain city names like "Berlin".
+var zoneRegions = []string{"Europe", "America", "Asia", "Africa", "Australia", "Pacific", "Atlantic", "Indian"}
+
+// resolveZone returns the location for an IANA name, an alias or a city
+// that is part of an IANA name.
+func resolveZone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("timezone is required")
+	}
+	if zone, ok := zoneAliases[strings.ToLower(name)]; ok {
+		return time.LoadLocation(zone)
+	}
+	if strings.EqualFold(name, "local") {
+		return nil, fmt.Errorf("timezone must be a named zone, not Local")
+	}
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc, nil
+	}
+	// Title case words joined by underscores, e.g. "new york" -> New_York.
+	words := strings.Fields(strings.ReplaceAll(name, "_", " "))
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + strings.ToLower(w[1:])
+	}
+	city := strings.Join(words, "_")
+	for _, region := range zoneRegions {
+		if loc, err := time.LoadLocation(region + "/" + city); err == nil {
+			return loc, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown timezone %q, use an IANA name like 'Europe/Berlin', a city or an abbreviation like 'CET'", name)
+}
+
+// describeTime returns the fields reported for a point in time in a zone.
+func describeTime(t time.Time, loc *time.Location) map[string]any {
+	t = t.In(loc)
+	abbrev, offset := t.Zone()
+	return map[string]any{
+		"timezone":     loc.String(),
+		"abbreviation": abbrev,
+		"utc_offset":   formatOffset(offset),
+		"dst":          t.IsDST(),
+		"iso8601":      t.Format(time.RFC3339),
+		"date":         t.Format(time.DateOnly),
+		"time":         t.Format(time.TimeOnly),
+		"weekday":      t.Weekday().String(),
+	}
+}
+
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign, seconds = '-', -seconds
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+// dateTimeLayouts and clockLayouts are the accepted formats of a time to
+// convert, with and without a date.
+var clockLayouts = []string{"15:04", "15:04:05", "3pm", "3:04pm", "3 pm", "3:04 pm"}
+
+var dateTimeLayouts = []string{
+	time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04",
+	"02.01.2006 15:04", "2.1.2006 15:04", time.DateOnly,
+}
+
+// parseTimeIn parses a time given in loc. A time without a date is taken
+// as today in loc.
+func parseTimeIn(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	today := clock().In(loc)
+	for _, layout := range clockLayouts {
+		if t, err := time.Parse(layout, strings.ToLower(s)); err == nil {
+			return time.Date(today.Year(), today.Month(), today.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q, use e.g. '2026-03-29 14:30' or '14:30'", s)
+}
+
+// getTime reports the current or a given time in a timezone and optionally
+// converts it to another timezone.
+func getTime(args map[string]any) (any, error) {
+	name, _ := args["timezone"].(string)
+	loc, err := resolveZone(name)
+	if err != nil {
+		return nil, err
+	}
+	t := clock()
+	if s, ok := args["time"].(string); ok && s != "" {
+		if t, err = parseTimeIn(s, loc); err != nil {
+			return nil, err
+		}
+	}
+	result := describeTime(t, loc)
+	if !strings.EqualFold(name, loc.String()) {
+		result["input"] = name
+	}
+	to, _ := args["to_timezone"].(string)
+	if to == "" {
+		return result, nil
+	}
+	toLoc, err := resolveZone(to)
+	if err != nil {
+		return nil, err
+	}
+	converted := describeTime(t, toLoc)
+	if !strings.EqualFold(to, toLoc.String()) {
+		converted["input"] = to
+	}
+	_, fromOffset := t.In(loc).Zone()
+	_, toOffset := t.In(toLoc).Zone()
+	return map[string]any{
+		"from":              result,
+		"to":                converted,
+		"offset_difference": formatOffset(toOffset - fromOffset),
+	}, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveZone(t *testing.T) {
+	var cases = []struct {
+		name, want, err string
+	}{
+		{"Europe/Berlin", "Europe/Berlin", ""},
+		{"CET", "Europe/Berlin", ""},
+		{"mesz", "Europe/Berlin", ""},
+		{"PST", "America/Los_Angeles", ""},
+		{"GMT", "UTC", ""},
+		{"Halle (Saale)", "Europe/Berlin", ""},
+		{"München", "Europe/Berlin", ""},
+		{"berlin", "Europe/Berlin", ""},
+		{"new york", "America/New_York", ""},
+		{"Buenos Aires", "America/Argentina/Buenos_Aires", ""},
+		{"los_angeles", "America/Los_Angeles", ""},
+		{"tokyo", "Asia/Tokyo", ""},
+		{"", "", "timezone is required"},
+		{"Local", "", "not Local"},
+		{"Atlantis", "", "unknown timezone"},
+	}
+	for _, c := range cases {
+		loc, err := resolveZone(c.name)
+		switch {
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("resolveZone(%q): got %v, %v, want error %q", c.name, loc, err, c.err)
+		case c.err == "" && err != nil:
+			t.Errorf("resolveZone(%q): %v", c.name, err)
+		case c.err == "" && loc.String() != c.want:
+			t.Errorf("resolveZone(%q): got %s, want %s", c.name, loc, c.want)
+		}
+	}
+}
+
+func TestGetTime(t *testing.T) {
+	var cases = []struct {
+		name string
+		now  time.Time
+		args map[string]any
+		want map[string]any
+	}{
+		{
+			name: "CET in winter",
+			now:  time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC),
+			args: map[string]any{"timezone": "CET"},
+			want: map[string]any{"timezone": "Europe/Berlin", "abbreviation": "CET", "utc_offset": "+01:00", "dst": false, "time": "13:00:00", "input": "CET"},
+		},
+		{
+			name: "CET in summer",
+			now:  time.Date(2026, 7, 15, 12, 0, 0, 0, time.UTC),
+			args: map[string]any{"timezone": "CET"},
+			want: map[string]any{"abbreviation": "CEST", "utc_offset": "+02:00", "dst": true, "time": "14:00:00"},
+		},
+		{
+			name: "PST in summer",
+			now:  time.Date(2026, 7, 15, 12, 0, 0, 0, time.UTC),
+			args: map[string]any{"timezone": "PST"},
+			want: map[string]any{"timezone": "America/Los_Angeles", "abbreviation": "PDT", "utc_offset": "-07:00", "dst": true, "time": "05:00:00", "weekday": "Wednesday"},
+		},
+		{
+			name: "before the switch to summer time",
+			now:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			args: map[string]any{"timezone": "Europe/Berlin", "time": "2026-03-29 01:30"},
+			want: map[string]any{"utc_offset": "+01:00", "dst": false, "iso8601": "2026-03-29T01:30:00+01:00"},
+		},
+		{
+			name: "after the switch to summer time",
+			now:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			args: map[string]any{"timezone": "Europe/Berlin", "time": "2026-03-29 03:30"},
+			want: map[string]any{"utc_offset": "+02:00", "dst": true, "iso8601": "2026-03-29T03:30:00+02:00"},
+		},
+		{
+			name: "time of day is today in the zone",
+			now:  time.Date(2026, 5, 31, 23, 30, 0, 0, time.UTC),
+			args: map[string]any{"timezone": "Asia/Tokyo", "time": "3pm"},
+			want: map[string]any{"date": "2026-06-01", "time": "15:00:00", "weekday": "Monday"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setClock(t, c.now)
+			got, err := getTime(c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := got.(map[string]any)
+			for k, v := range c.want {
+				if result[k] != v {
+					t.Errorf("%s: got %v, want %v", k, result[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTimeConversion(t *testing.T) {
+	var cases = []struct {
+		name       string
+		now        time.Time
+		args       map[string]any
+		toTime     string
+		toOffset   string
+		difference string
+	}{
+		{
+			// The US switches to summer time three weeks before Europe.
+			name:       "between the switches",
+			now:        time.Date(2026, 3, 20, 8, 0, 0, 0, time.UTC),
+			args:       map[string]any{"timezone": "Berlin", "time": "14:30", "to_timezone": "New York"},
+			toTime:     "09:30:00",
+			toOffset:   "-04:00",
+			difference: "-05:00",
+		},
+		{
+			name:       "both in summer time",
+			now:        time.Date(2026, 7, 1, 8, 0, 0, 0, time.UTC),
+			args:       map[string]any{"timezone": "Berlin", "time": "14:30", "to_timezone": "New York"},
+			toTime:     "08:30:00",
+			toOffset:   "-04:00",
+			difference: "-06:00",
+		},
+		{
+			name:       "half hour offset",
+			now:        time.Date(2026, 1, 10, 8, 0, 0, 0, time.UTC),
+			args:       map[string]any{"timezone": "UTC", "time": "2026-01-10 12:00", "to_timezone": "IST"},
+			toTime:     "17:30:00",
+			toOffset:   "+05:30",
+			difference: "+05:30",
+		},
+		{
+			name:       "across the date line",
+			now:        time.Date(2026, 1, 10, 8, 0, 0, 0, time.UTC),
+			args:       map[string]any{"timezone": "Pacific/Auckland", "time": "2026-01-10 09:00", "to_timezone": "HST"},
+			toTime:     "10:00:00",
+			toOffset:   "-10:00",
+			difference: "-23:00",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setClock(t, c.now)
+			got, err := getTime(c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := got.(map[string]any)
+			to := result["to"].(map[string]any)
+			if to["time"] != c.toTime || to["utc_offset"] != c.toOffset || result["offset_difference"] != c.difference {
+				t.Errorf("got %v %v, difference %v, want %s %s, difference %s",
+					to["time"], to["utc_offset"], result["offset_difference"], c.toTime, c.toOffset, c.difference)
+			}
+		})
+	}
+}
+
+func TestGetTimeErrors(t *testing.T) {
+	setClock(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	var cases = []struct {
+		args map[string]any
+		err  string
+	}{
+		{map[string]any{}, "timezone is required"},
+		{map[string]any{"timezone": "CET", "time": "half past two"}, "cannot parse time"},
+		{map[string]any{"timezone": "CET", "to_timezone": "Mars"}, "unknown timezone"},
+	}
+	for _, c := range cases {
+		if _, err := getTime(c.args); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("getTime(%v): got %v, want %q", c.args, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Place is a geocoding result.
+type Place struct {
+	Name        string  `json:"name"`
+	Admin1      string  `json:"admin1"`
+	Country     string  `json:"country"`
+	CountryCode string  `json:"country_code"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	Population  int     `json:"population"`
+}
+
+// String returns name, region and country, e.g. "Halle, Saxony-Anhalt,
+// Germany".
+func (p Place) String() string {
+	parts := []string{p.Name}
+	if p.Admin1 != "" && p.Admin1 != p.Name {
+		parts = append(parts, p.Admin1)
+	}
+	if p.Country != "" {
+		parts = append(parts, p.Country)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Conditions are the current weather at a place.
+type Conditions struct {
+	Temperature float64
+	Humidity    int
+	WindSpeed   float64 // km/h
+	WeatherCode int
+}
+
+// DayForecast is the forecast for a single day.
+type DayForecast struct {
+	Date                     string
+	TemperatureMax           float64
+	TemperatureMin           float64
+	Precipitation            float64 // mm
+	PrecipitationProbability int     // percent
+	WeatherCode              int
+}
+
+// Weather is the current weather and an optional forecast, with
+// temperatures in Unit, "celsius" or "fahrenheit".
+type Weather struct {
+	Unit     string
+	Current  Conditions
+	Forecast []DayForecast
+}
+
+// WeatherProvider looks up places and their weather.
+type WeatherProvider interface {
+	// Geocode returns places matching a name, best match first.
+	Geocode(name string) ([]Place, error)
+	// Weather returns the current weather and a forecast for the given
+	// number of days, none if days is 0.
+	Weather(place Place, days int, unit string) (*Weather, error)
+}
+
+// maxForecastDays is the longest forecast open-meteo offers.
+const maxForecastDays = 16
+
+// OpenMeteo is a WeatherProvider using the open-meteo APIs. Both base URLs
+// can point to a local server, e.g. a stub in tests.
+type OpenMeteo struct {
+	ForecastURL  string // e.g. https://api.open-meteo.com
+	GeocodingURL string // e.g. https://geocoding-api.open-meteo.com
+	client       *http.Client
+	cache        *responseCache
+}
+
+// NewOpenMeteo returns an open-meteo provider caching responses for ttl, 0
+// disables the cache.
+func NewOpenMeteo(forecastURL, geocodingURL string, ttl time.Duration) *OpenMeteo {
+	return &OpenMeteo{
+		ForecastURL:  strings.TrimRight(forecastURL, "/"),
+		GeocodingURL: strings.TrimRight(geocodingURL, "/"),
+		client:       &http.Client{Timeout: 15 * time.Second},
+		cache:        newResponseCache(ttl),
+	}
+}
+
+// get fetches a URL and decodes the JSON response into v, from the cache if
+// possible.
+func (m *OpenMeteo) get(u string, v any) error {
+	body, ok := m.cache.get(u)
+	if !ok {
+		resp, err := m.client.Get(u)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, err = io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			// open-meteo explains errors as {"error": true, "reason": "..."}.
+			var apiErr struct {
+				Reason string `json:"reason"`
+			}
+			if json.Unmarshal(body, &apiErr) == nil && apiErr.Reason != "" {
+				return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, apiErr.Reason)
+			}
+			return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+		}
+		m.cache.put(u, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+func (m *OpenMeteo) Geocode(name string) ([]Place, error) {
+	q := url.Values{}
+	q.Set("name", name)
+	q.Set("count", "10")
+	q.Set("language", "en")
+	q.Set("format", "json")
+	var data struct {
+		Results []Place `json:"results"`
+	}
+	if err := m.get(m.GeocodingURL+"/v1/search?"+q.Encode(), &data); err != nil {
+		return nil, fmt.Errorf("geocoding request failed: %w", err)
+	}
+	return data.Results, nil
+}
+
+func (m *OpenMeteo) Weather(place Place, days int, unit string) (*Weather, error) {
+	q := url.Values{}
+	q.Set("latitude", fmt.Sprintf("%.4f", place.Latitude))
+	q.Set("longitude", fmt.Sprintf("%.4f", place.Longitude))
+	q.Set("current", "temperature_2m,relative_humidity_2m,weather_code,wind_speed_10m")
+	q.Set("wind_speed_unit", "kmh")
+	q.Set("temperature_unit", unit)
+	q.Set("timezone", "auto")
+	if days > 0 {
+		q.Set("daily", "weather_code,temperature_2m_max,temperature_2m_min,precipitation_sum,precipitation_probability_max")
+		q.Set("forecast_days", fmt.Sprint(days))
+	}
+	var data struct {
+		Current struct {
+			Temperature float64 `json:"temperature_2m"`
+			Humidity    int     `json:"relative_humidity_2m"`
+			WeatherCode int     `json:"weather_code"`
+			WindSpeed   float64 `json:"wind_speed_10m"`
+		} `json:"current"`
+		Daily struct {
+			Time                     []string  `json:"time"`
+			WeatherCode              []int     `json:"weather_code"`
+			TemperatureMax           []float64 `json:"temperature_2m_max"`
+			TemperatureMin           []float64 `json:"temperature_2m_min"`
+			Precipitation            []float64 `json:"precipitation_sum"`
+			PrecipitationProbability []int     `json:"precipitation_probability_max"`
+		} `json:"daily"`
+	}
+	if err := m.get(m.ForecastURL+"/v1/forecast?"+q.Encode(), &data); err != nil {
+		return nil, fmt.Errorf("weather request failed: %w", err)
+	}
+	w := &Weather{
+		Unit: unit,
+		Current: Conditions{
+			Temperature: data.Current.Temperature,
+			Humidity:    data.Current.Humidity,
+			WindSpeed:   data.Current.WindSpeed,
+			WeatherCode: data.Current.WeatherCode,
+		},
+	}
+	d := data.Daily
+	for i, date := range d.Time {
+		day := DayForecast{Date: date}
+		// Missing values, e.g. no probabilities for some models, stay zero.
+		if i < len(d.WeatherCode) {
+			day.WeatherCode = d.WeatherCode[i]
+		}
+		if i < len(d.TemperatureMax) {
+			day.TemperatureMax = d.TemperatureMax[i]
+		}
+		if i < len(d.TemperatureMin) {
+			day.TemperatureMin = d.TemperatureMin[i]
+		}
+		if i < len(d.Precipitation) {
+			day.Precipitation = d.Precipitation[i]
+		}
+		if i < len(d.PrecipitationProbability) {
+			day.PrecipitationProbability = d.PrecipitationProbability[i]
+		}
+		w.Forecast = append(w.Forecast, day)
+	}
+	return w, nil
+}
+
+// wmoCodeToCondition describes a WMO weather code, as open-meteo reports them.
+func wmoCodeToCondition(code int) string {
+	switch {
+	case code == 0:
+		return "Clear sky"
+	case code <= 2:
+		return "Partly cloudy"
+	case code == 3:
+		return "Overcast"
+	case code <= 49:
+		return "Foggy"
+	case code <= 59:
+		return "Drizzle"
+	case code <= 69:
+		return "Rain"
+	case code <= 79:
+		return "Snow"
+	case code <= 82:
+		return "Rain showers"
+	case code <= 86:
+		return "Snow showers"
+	case code <= 99:
+		return "Thunderstorm"
+	default:
+		return "Unknown"
+	}
+}
+
+// responseCache keeps response bodies by URL for a fixed time. A nil cache
+// stores nothing.
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &responseCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+func (c *responseCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || clock().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.body, true
+}
+
+func (c *responseCache) put(key string, body []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := clock()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry{body: body, expires: now.Add(c.ttl)}
+}
+
+var parenthetical = regexp.MustCompile(`\s*\(([^)]*)\)`)
+
+// findPlaces geocodes a location like "Halle (Saale)", "Halle, Saxony-Anhalt"
+// or "Springfield, US". The part after a comma or in parentheses narrows down
+// the results by region or country; a parenthetical that does not match any
+// region, like "Saale", is ignored.
+func findPlaces(p WeatherProvider, location string) ([]Place, error) {
+	name, hint, _ := strings.Cut(location, ",")
+	var hints []string
+	if m := parenthetical.FindStringSubmatch(name); m != nil {
+		hints = append(hints, m[1])
+		name = parenthetical.ReplaceAllString(name, "")
+	}
+	if hint = strings.TrimSpace(hint); hint != "" {
+		hints = append(hints, hint)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("city name is required")
+	}
+	places, err := p.Geocode(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(places) == 0 {
+		return nil, fmt.Errorf("city not found: %s", location)
+	}
+	for _, h := range hints {
+		var matching []Place
+		for _, pl := range places {
+			if placeMatches(pl, h) {
+				matching = append(matching, pl)
+			}
+		}
+		if len(matching) > 0 {
+			places = matching
+		} else if h == hint {
+			return nil, fmt.Errorf("no %s in %s, found: %s", name, hint, describePlaces(places))
+		}
+	}
+	return places, nil
+}
+
+// placeMatches reports whether a hint names the region or country of a
+// place, or a state or country code, e.g. "Saxony-Anhalt", "de", "Germany".
+func placeMatches(p Place, hint string) bool {
+	hint = strings.ToLower(strings.TrimSpace(hint))
+	for _, s := range []string{p.Admin1, p.Country, p.CountryCode} {
+		s = strings.ToLower(s)
+		if s != "" && (s == hint || len(hint) > 2 && strings.Contains(s, hint)) {
+			return true
+		}
+	}
+	return false
+}
+
+func describePlaces(places []Place) string {
+	var s []string
+	for _, p := range places {
+		s = append(s, p.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+// formatTemperature formats a temperature with the symbol of its unit.
+func formatTemperature(t float64, unit string) string {
+	if unit == "fahrenheit" {
+		return fmt.Sprintf("%.1f°F", t)
+	}
+	return fmt.Sprintf("%.1f°C", t)
+}
+
+// getWeather is the handler of the get_weather tool.
+func getWeather(p WeatherProvider) ToolHandler {
+	return func(args map[string]any) (any, error) {
+		city, _ := args["city"].(string)
+		if strings.TrimSpace(city) == "" {
+			return nil, fmt.Errorf("city name is required")
+		}
+		unit, _ := args["unit"].(string)
+		switch unit = strings.ToLower(unit); unit {
+		case "", "c", "celsius":
+			unit = "celsius"
+		case "f", "fahrenheit":
+			unit = "fahrenheit"
+		default:
+			return nil, fmt.Errorf("unknown unit %q, use celsius or fahrenheit", unit)
+		}
+		days := 0
+		if d, ok := args["days"].(float64); ok {
+			days = int(d)
+		}
+		if days < 0 || days > maxForecastDays {
+			return nil, fmt.Errorf("days must be between 0 and %d", maxForecastDays)
+		}
+		places, err := findPlaces(p, city)
+		if err != nil {
+			return nil, err
+		}
+		place := places[0]
+		w, err := p.Weather(place, days, unit)
+		if err != nil {
+			return nil, err
+		}
+		c := w.Current
+		result := map[string]any{
+			"city":        place.Name,
+			"region":      place.Admin1,
+			"country":     place.Country,
+			"temperature": formatTemperature(c.Temperature, unit),
+			"humidity":    fmt.Sprintf("%d%%", c.Humidity),
+			"wind_speed":  fmt.Sprintf("%.1f km/h", c.WindSpeed),
+			"condition":   wmoCodeToCondition(c.WeatherCode),
+		}
+		if len(w.Forecast) > 0 {
+			var forecast []map[string]any
+			for _, d := range w.Forecast {
+				forecast = append(forecast, map[string]any{
+					"date":                      d.Date,
+					"condition":                 wmoCodeToCondition(d.WeatherCode),
+					"max":                       formatTemperature(d.TemperatureMax, unit),
+					"min":                       formatTemperature(d.TemperatureMin, unit),
+					"precipitation":             fmt.Sprintf("%.1f mm", d.Precipitation),
+					"precipitation_probability": fmt.Sprintf("%d%%", d.PrecipitationProbability),
+				})
+			}
+			result["forecast"] = forecast
+		}
+		// Places of the same name elsewhere, so the model can ask the user
+		// or retry with "City, Region".
+		var others []Place
+		for _, pl := range places[1:] {
+			if strings.EqualFold(pl.Name, place.Name) {
+				others = append(others, pl)
+			}
+		}
+		if len(others) > 0 {
+			others = others[:min(len(others), 5)]
+			result["other_matches"] = describePlaces(others)
+			result["note"] = fmt.Sprintf("weather for %s, add region or country for another place, e.g. %q",
+				place, others[0].Name+", "+cmp.Or(others[0].Admin1, others[0].Country))
+		}
+		return result, nil
+	}
+}
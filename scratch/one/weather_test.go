@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// weatherStub serves geocoding and forecasts like open-meteo and counts the
+// requests per path.
+type weatherStub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	hits     map[string]int
+	forecast []string // query strings of the forecast requests
+}
+
+var stubPlaces = map[string][]Place{
+	"halle": {
+		{Name: "Halle", Admin1: "Saxony-Anhalt", Country: "Germany", CountryCode: "DE", Latitude: 51.4824, Longitude: 11.9697, Population: 238762},
+		{Name: "Halle", Admin1: "North Rhine-Westphalia", Country: "Germany", CountryCode: "DE", Latitude: 52.0607, Longitude: 8.3592, Population: 21917},
+		{Name: "Halle", Admin1: "Flanders", Country: "Belgium", CountryCode: "BE", Latitude: 50.7339, Longitude: 4.2345, Population: 38336},
+		{Name: "Hallein", Admin1: "Salzburg", Country: "Austria", CountryCode: "AT", Latitude: 47.6833, Longitude: 13.1, Population: 21000},
+	},
+	"leipzig": {
+		{Name: "Leipzig", Admin1: "Saxony", Country: "Germany", CountryCode: "DE", Latitude: 51.3396, Longitude: 12.3713, Population: 587857},
+	},
+}
+
+func newWeatherStub(t *testing.T) *weatherStub {
+	t.Helper()
+	s := &weatherStub{hits: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/search", func(w http.ResponseWriter, r *http.Request) {
+		s.hit(r)
+		json.NewEncoder(w).Encode(map[string]any{"results": stubPlaces[strings.ToLower(r.FormValue("name"))]})
+	})
+	mux.HandleFunc("GET /v1/forecast", func(w http.ResponseWriter, r *http.Request) {
+		s.hit(r)
+		temperature := 21.5
+		if r.FormValue("temperature_unit") == "fahrenheit" {
+			temperature = 70.7
+		}
+		resp := map[string]any{
+			"current": map[string]any{
+				"temperature_2m": temperature, "relative_humidity_2m": 64, "weather_code": 3, "wind_speed_10m": 12.2,
+			},
+		}
+		if days, _ := strconv.Atoi(r.FormValue("forecast_days")); days > 0 {
+			daily := map[string][]any{}
+			for i := range days {
+				daily["time"] = append(daily["time"], time.Date(2026, 10, 18+i, 0, 0, 0, 0, time.UTC).Format(time.DateOnly))
+				daily["weather_code"] = append(daily["weather_code"], 61)
+				daily["temperature_2m_max"] = append(daily["temperature_2m_max"], temperature+float64(i))
+				daily["temperature_2m_min"] = append(daily["temperature_2m_min"], temperature-10)
+				daily["precipitation_sum"] = append(daily["precipitation_sum"], 1.25)
+				// Some models have no probabilities for later days.
+				if i < 2 {
+					daily["precipitation_probability_max"] = append(daily["precipitation_probability_max"], 80)
+				}
+			}
+			resp["daily"] = daily
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *weatherStub) hit(r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits[r.URL.Path]++
+	if r.URL.Path == "/v1/forecast" {
+		s.forecast = append(s.forecast, r.URL.RawQuery)
+	}
+}
+
+func (s *weatherStub) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+func TestGetWeatherPlaces(t *testing.T) {
+	var cases = []struct {
+		city   string
+		region string
+		others string
+		note   string
+		err    string
+	}{
+		{city: "Halle", region: "Saxony-Anhalt",
+			others: "Halle, North Rhine-Westphalia, Germany; Halle, Flanders, Belgium",
+			note:   `e.g. "Halle, North Rhine-Westphalia"`},
+		// A parenthetical that names no region is ignored.
+		{city: "Halle (Saale)", region: "Saxony-Anhalt", others: "Halle, North Rhine-Westphalia, Germany; Halle, Flanders, Belgium"},
+		{city: "Halle, North Rhine-Westphalia", region: "North Rhine-Westphalia"},
+		{city: "Halle, westphalia", region: "North Rhine-Westphalia"},
+		{city: "Halle, Belgium", region: "Flanders"},
+		{city: "Halle, BE", region: "Flanders"},
+		{city: "Halle, DE", region: "Saxony-Anhalt", others: "Halle, North Rhine-Westphalia, Germany"},
+		{city: "Leipzig", region: "Saxony"},
+		{city: "Halle, Bavaria", err: "no Halle in Bavaria, found: Halle, Saxony-Anhalt, Germany; "},
+		{city: "Atlantis", err: "city not found: Atlantis"},
+		{city: " ", err: "city name is required"},
+		{city: "(Saale)", err: "city name is required"},
+	}
+	stub := newWeatherStub(t)
+	handler := getWeather(NewOpenMeteo(stub.URL, stub.URL, 0))
+	for _, c := range cases {
+		t.Run(c.city, func(t *testing.T) {
+			v, err := handler(map[string]any{"city": c.city})
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got %v, %v, want error %q", v, err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := v.(map[string]any)
+			if result["region"] != c.region {
+				t.Errorf("got region %v, want %s", result["region"], c.region)
+			}
+			if others, _ := result["other_matches"].(string); others != c.others {
+				t.Errorf("got other matches %q, want %q", others, c.others)
+			}
+			if note, _ := result["note"].(string); !strings.Contains(note, c.note) {
+				t.Errorf("note %q does not contain %q", note, c.note)
+			}
+		})
+	}
+}
+
+func TestGetWeatherUnits(t *testing.T) {
+	var cases = []struct {
+		unit        any
+		temperature string
+		query       string
+		err         string
+	}{
+		{nil, "21.5°C", "temperature_unit=celsius", ""},
+		{"C", "21.5°C", "temperature_unit=celsius", ""},
+		{"Fahrenheit", "70.7°F", "temperature_unit=fahrenheit", ""},
+		{"f", "70.7°F", "temperature_unit=fahrenheit", ""},
+		{"kelvin", "", "", `unknown unit "kelvin"`},
+	}
+	for _, c := range cases {
+		stub := newWeatherStub(t)
+		handler := getWeather(NewOpenMeteo(stub.URL, stub.URL, 0))
+		v, err := handler(map[string]any{"city": "Leipzig", "unit": c.unit})
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("unit %v: got %v, %v, want error %q", c.unit, v, err, c.err)
+			}
+			if n := stub.count("/v1/search"); n != 0 {
+				t.Errorf("unit %v: %d requests for an invalid unit", c.unit, n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := v.(map[string]any)
+		if result["temperature"] != c.temperature || result["humidity"] != "64%" || result["wind_speed"] != "12.2 km/h" {
+			t.Errorf("unit %v: got %v", c.unit, result)
+		}
+		if !strings.Contains(stub.forecast[0], c.query) || !strings.Contains(stub.forecast[0], "wind_speed_unit=kmh") {
+			t.Errorf("unit %v: forecast query %s, want %s", c.unit, stub.forecast[0], c.query)
+		}
+	}
+}
+
+func TestGetWeatherForecast(t *testing.T) {
+	stub := newWeatherStub(t)
+	handler := getWeather(NewOpenMeteo(stub.URL, stub.URL, 0))
+
+	v, err := handler(map[string]any{"city": "Leipzig", "unit": "fahrenheit", "days": float64(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stub.forecast[0], "forecast_days=3") {
+		t.Errorf("forecast query %s", stub.forecast[0])
+	}
+	forecast := v.(map[string]any)["forecast"].([]map[string]any)
+	if len(forecast) != 3 {
+		t.Fatalf("got %d days, want 3", len(forecast))
+	}
+	var want = []map[string]any{
+		{"date": "2026-10-18", "max": "70.7°F", "min": "60.7°F", "precipitation": "1.2 mm", "precipitation_probability": "80%"},
+		{"date": "2026-10-19", "max": "71.7°F", "min": "60.7°F", "precipitation": "1.2 mm", "precipitation_probability": "80%"},
+		{"date": "2026-10-20", "max": "72.7°F", "min": "60.7°F", "precipitation": "1.2 mm", "precipitation_probability": "0%"},
+	}
+	for i, day := range want {
+		for k, v := range day {
+			if forecast[i][k] != v {
+				t.Errorf("day %d %s: got %v, want %v", i, k, forecast[i][k], v)
+			}
+		}
+		if forecast[i]["condition"] != wmoCodeToCondition(61) {
+			t.Errorf("day %d: condition %v", i, forecast[i]["condition"])
+		}
+	}
+
+	// Without days there is no forecast and none is requested.
+	v, err = handler(map[string]any{"city": "Leipzig"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.(map[string]any)["forecast"]; ok {
+		t.Errorf("forecast without days: %v", v)
+	}
+	if strings.Contains(stub.forecast[1], "daily=") {
+		t.Errorf("forecast query without days %s", stub.forecast[1])
+	}
+
+	for _, days := range []float64{-1, maxForecastDays + 1} {
+		if _, err := handler(map[string]any{"city": "Leipzig", "days": days}); err == nil || !strings.Contains(err.Error(), "days must be between") {
+			t.Errorf("days %v: got %v", days, err)
+		}
+	}
+}
+
+func TestOpenMeteoCache(t *testing.T) {
+	stub := newWeatherStub(t)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	setClock(t, now)
+	handler := getWeather(NewOpenMeteo(stub.URL, stub.URL, 10*time.Minute))
+	args := map[string]any{"city": "Halle", "days": float64(2)}
+
+	var want map[string]any
+	for i := range 3 {
+		v, err := handler(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			want = v.(map[string]any)
+		} else if fmt.Sprint(v) != fmt.Sprint(want) {
+			t.Errorf("cached result %v differs from %v", v, want)
+		}
+	}
+	if g, f := stub.count("/v1/search"), stub.count("/v1/forecast"); g != 1 || f != 1 {
+		t.Errorf("within the TTL: %d geocoding and %d forecast requests, want 1 each", g, f)
+	}
+
+	// Another unit is another request, geocoding is still cached.
+	args["unit"] = "fahrenheit"
+	if _, err := handler(args); err != nil {
+		t.Fatal(err)
+	}
+	if g, f := stub.count("/v1/search"), stub.count("/v1/forecast"); g != 1 || f != 2 {
+		t.Errorf("other unit: %d geocoding and %d forecast requests, want 1 and 2", g, f)
+	}
+
+	setClock(t, now.Add(11*time.Minute))
+	if _, err := handler(args); err != nil {
+		t.Fatal(err)
+	}
+	if g, f := stub.count("/v1/search"), stub.count("/v1/forecast"); g != 2 || f != 3 {
+		t.Errorf("after the TTL: %d geocoding and %d forecast requests, want 2 and 3", g, f)
+	}
+}
+
+func TestOpenMeteoErrors(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": true, "reason": "Latitude must be in range of -90 to 90°. Given: 91.0."}`)
+	}))
+	defer failing.Close()
+	m := NewOpenMeteo(failing.URL, failing.URL, time.Minute)
+	_, err := m.Weather(Place{Name: "Nowhere", Latitude: 91}, 0, "celsius")
+	if err == nil || err.Error() != "weather request failed: unexpected status 400: Latitude must be in range of -90 to 90°. Given: 91.0." {
+		t.Errorf("got %v, want the reason of the status error", err)
+	}
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	_, err = getWeather(NewOpenMeteo(down.URL, down.URL, time.Minute))(map[string]any{"city": "Halle"})
+	if err == nil || !strings.Contains(err.Error(), "geocoding request failed") {
+		t.Errorf("got %v, want geocoding error", err)
+	}
+}